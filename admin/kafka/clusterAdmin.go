@@ -1 +1,522 @@
 package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/opentracing/jaeger"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrPartitionsDecrease is returned when a spec has less partitions than
+	// its topic, kafka can not remove partitions
+	ErrPartitionsDecrease = errors.New("kafka admin: partitions can not be decreased")
+	// ErrGroupActive is returned when resetting the offsets of a group with
+	// active members
+	ErrGroupActive = errors.New("kafka admin: consumer group has active members")
+)
+
+// cleanup policies of TopicSpec
+const (
+	CleanupDelete  = "delete"
+	CleanupCompact = "compact"
+)
+
+type (
+	// ClusterAdmin represents administration operations of a kafka cluster
+	ClusterAdmin interface {
+		// EnsureTopics creates the missing topics of specs and aligns the
+		// partitions and configuration of the existing ones, it is idempotent
+		// so it can be called at every service start
+		EnsureTopics(ctx context.Context, specs ...TopicSpec) error
+		DeleteTopic(ctx context.Context, topic string) error
+
+		ListConsumerGroups(ctx context.Context) ([]string, error)
+		DescribeConsumerGroup(ctx context.Context, group string) (*ConsumerGroupDescription, error)
+		// ResetOffsetsToTimestamp moves the offsets of group on topic to the
+		// first message produced at or after ts, the group must have no member
+		ResetOffsetsToTimestamp(ctx context.Context, group, topic string, ts time.Time) error
+		// ResetOffsetsToEarliest moves the offsets of group on topic to the
+		// oldest retained message, the group must have no member
+		ResetOffsetsToEarliest(ctx context.Context, group, topic string) error
+
+		CreateACLs(ctx context.Context, acls ...ACL) error
+		ListACLs(ctx context.Context, filter sarama.AclFilter) ([]ACL, error)
+		DeleteACLs(ctx context.Context, acls ...ACL) error
+
+		Close() error
+	}
+
+	// TopicSpec represents the desired state of a topic
+	TopicSpec struct {
+		Name string
+		// Partitions is the num.partitions of the brokers when zero, the
+		// partitions of an existing topic are then left unchanged
+		Partitions int32
+		// ReplicationFactor is the default.replication.factor of the
+		// brokers when zero, it is only used to create the topic
+		ReplicationFactor int16
+		// Retention is the retention.ms of the topic, the broker default when
+		// zero and infinite when negative
+		Retention time.Duration
+		// CleanupPolicy is CleanupDelete, CleanupCompact or both separated by
+		// a comma, the broker default when empty
+		CleanupPolicy string
+		// Config holds any other topic configuration
+		Config map[string]string
+	}
+
+	// ConsumerGroupDescription represents the state of a consumer group
+	ConsumerGroupDescription struct {
+		GroupID string
+		State   string
+		Members []GroupMember
+		// Offsets holds the committed offsets by topic and partition
+		Offsets map[string]map[int32]PartitionOffset
+	}
+
+	// GroupMember represents a member of a consumer group
+	GroupMember struct {
+		MemberID   string
+		ClientID   string
+		ClientHost string
+		// Assignment holds the partitions assigned by topic
+		Assignment map[string][]int32
+	}
+
+	// PartitionOffset represents the progress of a group on a partition
+	PartitionOffset struct {
+		Committed int64
+		End       int64
+		Lag       int64
+	}
+
+	// ACL represents a permission granted or denied to a principal on a resource
+	ACL struct {
+		Principal    string
+		Host         string
+		ResourceType sarama.AclResourceType
+		ResourceName string
+		PatternType  sarama.AclResourcePatternType
+		Operation    sarama.AclOperation
+		Permission   sarama.AclPermissionType
+	}
+
+	clusterAdmin struct {
+		client sarama.Client
+		admin  sarama.ClusterAdmin
+	}
+)
+
+// NewClusterAdmin connects to brokers, the config must set a version
+// supporting the admin requests, 2.4.0 or later for incremental configuration
+func NewClusterAdmin(brokers []string, kafkaConfigFn func() *sarama.Config) (ClusterAdmin, error) {
+	client, err := sarama.NewClient(brokers, kafkaConfigFn())
+	if err != nil {
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &clusterAdmin{
+		client: client,
+		admin:  admin,
+	}, nil
+}
+
+func (c *clusterAdmin) startSpan(ctx context.Context, method string) opentracing.Span {
+	return jaeger.Start(ctx, ">kafka.ClusterAdmin/"+method, ext.SpanKindRPCClient)
+}
+
+func (c *clusterAdmin) EnsureTopics(ctx context.Context, specs ...TopicSpec) (err error) {
+	span := c.startSpan(ctx, "EnsureTopics")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	topics, err := c.admin.ListTopics()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		detail, ok := topics[spec.Name]
+		if !ok {
+			// the CreateTopics versions of sarama can not ask the brokers
+			// for their defaults with -1
+			partitions, replicationFactor := spec.Partitions, spec.ReplicationFactor
+			if partitions <= 0 || replicationFactor <= 0 {
+				defaultPartitions, defaultReplicationFactor, err := c.brokerDefaults()
+				if err != nil {
+					return fmt.Errorf("broker defaults of %s: %w", spec.Name, err)
+				}
+				if partitions <= 0 {
+					partitions = defaultPartitions
+				}
+				if replicationFactor <= 0 {
+					replicationFactor = defaultReplicationFactor
+				}
+			}
+			err = c.admin.CreateTopic(spec.Name, &sarama.TopicDetail{
+				NumPartitions:     partitions,
+				ReplicationFactor: replicationFactor,
+				ConfigEntries:     spec.configEntries(),
+			}, false)
+			if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+				return fmt.Errorf("create topic %s: %w", spec.Name, err)
+			}
+			zap.S().Infow("Created kafka topic", "topic", spec.Name, "partitions", partitions, "replication_factor", replicationFactor)
+			continue
+		}
+
+		switch {
+		case spec.Partitions <= 0:
+		case spec.Partitions < detail.NumPartitions:
+			return fmt.Errorf("topic %s has %d partitions: %w", spec.Name, detail.NumPartitions, ErrPartitionsDecrease)
+		case spec.Partitions > detail.NumPartitions:
+			if err := c.admin.CreatePartitions(spec.Name, spec.Partitions, nil, false); err != nil {
+				return fmt.Errorf("create partitions of %s: %w", spec.Name, err)
+			}
+			zap.S().Infow("Increased kafka topic partitions", "topic", spec.Name, "from", detail.NumPartitions, "to", spec.Partitions)
+		}
+
+		changes := make(map[string]sarama.IncrementalAlterConfigsEntry)
+		for name, value := range spec.configEntries() {
+			if current, ok := detail.ConfigEntries[name]; ok && current != nil && *current == *value {
+				continue
+			}
+			changes[name] = sarama.IncrementalAlterConfigsEntry{
+				Operation: sarama.IncrementalAlterConfigsOperationSet,
+				Value:     value,
+			}
+		}
+		if len(changes) > 0 {
+			if err := c.admin.IncrementalAlterConfig(sarama.TopicResource, spec.Name, changes, false); err != nil {
+				return fmt.Errorf("alter config of %s: %w", spec.Name, err)
+			}
+			zap.S().Infow("Altered kafka topic config", "topic", spec.Name, "entries", len(changes))
+		}
+	}
+	return nil
+}
+
+// brokerDefaults returns the num.partitions and default.replication.factor
+// of the controller, 1 as the broker default when they are not reported
+func (c *clusterAdmin) brokerDefaults() (partitions int32, replicationFactor int16, err error) {
+	controller, err := c.client.Controller()
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := c.admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.BrokerResource,
+		Name:        strconv.Itoa(int(controller.ID())),
+		ConfigNames: []string{"num.partitions", "default.replication.factor"},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	partitions, replicationFactor = 1, 1
+	for _, entry := range entries {
+		switch entry.Name {
+		case "num.partitions":
+			value, err := strconv.ParseInt(entry.Value, 10, 32)
+			if err != nil {
+				return 0, 0, err
+			}
+			partitions = int32(value)
+		case "default.replication.factor":
+			value, err := strconv.ParseInt(entry.Value, 10, 16)
+			if err != nil {
+				return 0, 0, err
+			}
+			replicationFactor = int16(value)
+		}
+	}
+	return partitions, replicationFactor, nil
+}
+
+func (c *clusterAdmin) DeleteTopic(ctx context.Context, topic string) (err error) {
+	span := c.startSpan(ctx, "DeleteTopic")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.admin.DeleteTopic(topic)
+}
+
+func (c *clusterAdmin) ListConsumerGroups(ctx context.Context) (groups []string, err error) {
+	span := c.startSpan(ctx, "ListConsumerGroups")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	result, err := c.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+	for group := range result {
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func (c *clusterAdmin) DescribeConsumerGroup(ctx context.Context, group string) (description *ConsumerGroupDescription, err error) {
+	span := c.startSpan(ctx, "DescribeConsumerGroup")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	groups, err := c.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, sarama.ErrGroupIDNotFound
+	}
+	if groups[0].Err != sarama.ErrNoError {
+		return nil, groups[0].Err
+	}
+
+	description = &ConsumerGroupDescription{
+		GroupID: groups[0].GroupId,
+		State:   groups[0].State,
+		Offsets: make(map[string]map[int32]PartitionOffset),
+	}
+	for _, member := range groups[0].Members {
+		groupMember := GroupMember{
+			MemberID:   member.MemberId,
+			ClientID:   member.ClientId,
+			ClientHost: member.ClientHost,
+		}
+		if assignment, err := member.GetMemberAssignment(); err == nil && assignment != nil {
+			groupMember.Assignment = assignment.Topics
+		}
+		description.Members = append(description.Members, groupMember)
+	}
+
+	offsets, err := c.admin.ListConsumerGroupOffsets(group, nil)
+	if err != nil {
+		return nil, err
+	}
+	for topic, partitions := range offsets.Blocks {
+		description.Offsets[topic] = make(map[int32]PartitionOffset, len(partitions))
+		for partition, block := range partitions {
+			if block.Err != sarama.ErrNoError {
+				return nil, block.Err
+			}
+			end, err := c.client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, err
+			}
+			offset := PartitionOffset{
+				Committed: block.Offset,
+				End:       end,
+			}
+			if block.Offset >= 0 {
+				offset.Lag = end - block.Offset
+			}
+			description.Offsets[topic][partition] = offset
+		}
+	}
+	return description, nil
+}
+
+func (c *clusterAdmin) ResetOffsetsToTimestamp(ctx context.Context, group, topic string, ts time.Time) (err error) {
+	span := c.startSpan(ctx, "ResetOffsetsToTimestamp")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.resetOffsets(group, topic, ts.UnixMilli())
+}
+
+func (c *clusterAdmin) ResetOffsetsToEarliest(ctx context.Context, group, topic string) (err error) {
+	span := c.startSpan(ctx, "ResetOffsetsToEarliest")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.resetOffsets(group, topic, sarama.OffsetOldest)
+}
+
+// resetOffsets commits for every partition of topic the offset returned by
+// the brokers for at, the end of the partition when no message is that recent
+func (c *clusterAdmin) resetOffsets(group, topic string, at int64) error {
+	groups, err := c.admin.DescribeConsumerGroups([]string{group})
+	if err != nil {
+		return err
+	}
+	if len(groups) > 0 && len(groups[0].Members) > 0 {
+		return fmt.Errorf("reset offsets of %s: %w", group, ErrGroupActive)
+	}
+
+	partitions, err := c.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+
+	request := &sarama.OffsetCommitRequest{
+		Version:                 2,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+		RetentionTime:           -1,
+	}
+	for _, partition := range partitions {
+		offset, err := c.client.GetOffset(topic, partition, at)
+		if err != nil {
+			return err
+		}
+		if offset < 0 {
+			if offset, err = c.client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+				return err
+			}
+		}
+		request.AddBlock(topic, partition, offset, 0, 0, "")
+	}
+
+	coordinator, err := c.client.Coordinator(group)
+	if err != nil {
+		return err
+	}
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return err
+	}
+	for _, partitions := range response.Errors {
+		for partition, kerr := range partitions {
+			if kerr != sarama.ErrNoError {
+				return fmt.Errorf("reset offset of %s/%d: %w", topic, partition, kerr)
+			}
+		}
+	}
+	zap.S().Infow("Reset kafka consumer group offsets", "group", group, "topic", topic, "partitions", len(partitions))
+	return nil
+}
+
+func (c *clusterAdmin) CreateACLs(ctx context.Context, acls ...ACL) (err error) {
+	span := c.startSpan(ctx, "CreateACLs")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	resourceACLs := make([]*sarama.ResourceAcls, len(acls))
+	for index, acl := range acls {
+		resourceACLs[index] = &sarama.ResourceAcls{
+			Resource: acl.resource(),
+			Acls: []*sarama.Acl{{
+				Principal:      acl.Principal,
+				Host:           acl.host(),
+				Operation:      acl.Operation,
+				PermissionType: acl.Permission,
+			}},
+		}
+	}
+	return c.admin.CreateACLs(resourceACLs)
+}
+
+func (c *clusterAdmin) ListACLs(ctx context.Context, filter sarama.AclFilter) (acls []ACL, err error) {
+	span := c.startSpan(ctx, "ListACLs")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	resourceACLs, err := c.admin.ListAcls(filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range resourceACLs {
+		for _, acl := range resource.Acls {
+			acls = append(acls, ACL{
+				Principal:    acl.Principal,
+				Host:         acl.Host,
+				ResourceType: resource.ResourceType,
+				ResourceName: resource.ResourceName,
+				PatternType:  resource.ResourcePatternType,
+				Operation:    acl.Operation,
+				Permission:   acl.PermissionType,
+			})
+		}
+	}
+	return acls, nil
+}
+
+func (c *clusterAdmin) DeleteACLs(ctx context.Context, acls ...ACL) (err error) {
+	span := c.startSpan(ctx, "DeleteACLs")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	for _, acl := range acls {
+		acl := acl
+		host := acl.host()
+		_, err := c.admin.DeleteACL(sarama.AclFilter{
+			ResourceType:              acl.ResourceType,
+			ResourceName:              &acl.ResourceName,
+			ResourcePatternTypeFilter: acl.resource().ResourcePatternType,
+			Principal:                 &acl.Principal,
+			Host:                      &host,
+			Operation:                 acl.Operation,
+			PermissionType:            acl.Permission,
+		}, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clusterAdmin) Close() error {
+	return c.admin.Close()
+}
+
+// configEntries returns the topic configuration described by the spec
+func (s TopicSpec) configEntries() map[string]*string {
+	entries := make(map[string]*string, len(s.Config)+2)
+	for name, value := range s.Config {
+		value := value
+		entries[name] = &value
+	}
+	switch {
+	case s.Retention < 0:
+		retention := "-1"
+		entries["retention.ms"] = &retention
+	case s.Retention > 0:
+		retention := strconv.FormatInt(s.Retention.Milliseconds(), 10)
+		entries["retention.ms"] = &retention
+	}
+	if s.CleanupPolicy != "" {
+		policy := s.CleanupPolicy
+		entries["cleanup.policy"] = &policy
+	}
+	return entries
+}
+
+func (a ACL) resource() sarama.Resource {
+	pattern := a.PatternType
+	if pattern == sarama.AclPatternUnknown {
+		pattern = sarama.AclPatternLiteral
+	}
+	return sarama.Resource{
+		ResourceType:        a.ResourceType,
+		ResourceName:        a.ResourceName,
+		ResourcePatternType: pattern,
+	}
+}
+
+// host returns the host of the ACL, any host when empty
+func (a ACL) host() string {
+	if a.Host == "" {
+		return "*"
+	}
+	return a.Host
+}
@@ -0,0 +1,303 @@
+package kafka_test
+
+import (
+	"context"
+	"errors"
+	"lib/admin/kafka"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// newTestAdmin connects an admin to broker
+func newTestAdmin(t *testing.T, broker *sarama.MockBroker) kafka.ClusterAdmin {
+	admin, err := kafka.NewClusterAdmin([]string{broker.Addr()}, func() *sarama.Config {
+		config := sarama.NewConfig()
+		config.Version = sarama.V2_6_0_0
+		config.Net.ReadTimeout = time.Second
+		return config
+	})
+	if err != nil {
+		t.Fatalf("\tShould connect to the cluster. %v %v", ballotX, err)
+	}
+	return admin
+}
+
+// adminRequests counts the admin requests received by broker and returns
+// the details of the created topics
+func adminRequests(broker *sarama.MockBroker) (map[string]int, map[string]*sarama.TopicDetail) {
+	requests := map[string]int{}
+	details := map[string]*sarama.TopicDetail{}
+	for _, item := range broker.History() {
+		switch request := item.Request.(type) {
+		case *sarama.CreateTopicsRequest:
+			requests["create"]++
+			for name, detail := range request.TopicDetails {
+				details[name] = detail
+			}
+		case *sarama.CreatePartitionsRequest:
+			requests["partitions"]++
+		case *sarama.IncrementalAlterConfigsRequest:
+			requests["config"]++
+		}
+	}
+	return requests, details
+}
+
+// TestEnsureTopics validates topics are provisioned from their specs
+func TestEnsureTopics(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	brokerConfig := sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+		Version: 2,
+		Resources: []*sarama.ResourceResponse{{
+			Type:    sarama.BrokerResource,
+			Name:    strconv.Itoa(int(broker.BrokerID())),
+			Configs: []*sarama.ConfigEntry{
+				{Name: "num.partitions", Value: "6"},
+				{Name: "default.replication.factor", Value: "3"},
+			},
+		}},
+	})
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		// the topics are listed by every call, the brokers described once
+		// a topic is created without partitions
+		"DescribeConfigsRequest": sarama.NewMockSequence(
+			sarama.NewMockDescribeConfigsResponse(t),
+			sarama.NewMockDescribeConfigsResponse(t),
+			brokerConfig,
+			sarama.NewMockDescribeConfigsResponse(t),
+		),
+		"CreateTopicsRequest":            sarama.NewMockCreateTopicsResponse(t),
+		"CreatePartitionsRequest":        sarama.NewMockCreatePartitionsResponse(t),
+		"IncrementalAlterConfigsRequest": sarama.NewMockIncrementalAlterConfigsResponse(t),
+	})
+
+	admin := newTestAdmin(t, broker)
+	defer admin.Close()
+
+	t.Log("Given the need to provision topics at service start")
+	{
+		err := admin.EnsureTopics(context.Background(),
+			kafka.TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 1, Retention: time.Hour},
+			kafka.TopicSpec{Name: "payments", Partitions: 1, ReplicationFactor: 1, CleanupPolicy: kafka.CleanupCompact},
+		)
+		if err != nil {
+			t.Fatalf("\tShould provision the topics. %v %v", ballotX, err)
+		}
+
+		requests, details := adminRequests(broker)
+		if requests["create"] != 1 || requests["partitions"] != 1 || requests["config"] == 0 {
+			t.Errorf("\tShould create missing topics and align existing ones. %v %v", ballotX, requests)
+		} else {
+			t.Logf("\tShould create missing topics and align existing ones. %v", checkMark)
+		}
+
+		t.Log("\tWhen the specs leave the partitions and replicas to the brokers")
+		{
+			err := admin.EnsureTopics(context.Background(),
+				kafka.TopicSpec{Name: "orders"},
+				kafka.TopicSpec{Name: "invoices"},
+			)
+			if err != nil {
+				t.Fatalf("\t\tShould provision the topics. %v %v", ballotX, err)
+			}
+
+			requests, details = adminRequests(broker)
+			if requests["partitions"] != 1 {
+				t.Errorf("\t\tShould leave the partitions of an existing topic unchanged. %v %v", ballotX, requests)
+			} else {
+				t.Logf("\t\tShould leave the partitions of an existing topic unchanged. %v", checkMark)
+			}
+			if invoices := details["invoices"]; invoices == nil || invoices.NumPartitions != 6 || invoices.ReplicationFactor != 3 {
+				t.Errorf("\t\tShould create a topic with the partitions and replicas of the brokers. %v %+v", ballotX, invoices)
+			} else {
+				t.Logf("\t\tShould create a topic with the partitions and replicas of the brokers. %v", checkMark)
+			}
+		}
+
+		err = admin.EnsureTopics(context.Background(), kafka.TopicSpec{Name: "orders", Partitions: 1, ReplicationFactor: 1})
+		if !errors.Is(err, kafka.ErrPartitionsDecrease) {
+			t.Errorf("\tShould refuse to decrease partitions. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould refuse to decrease partitions. %v", checkMark)
+		}
+	}
+}
+
+// TestResetOffsets validates the offsets of an inactive group are committed
+// at the requested position of every partition
+func TestResetOffsets(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	ts := time.Now().Add(-time.Hour)
+	billing := sarama.NewMockWrapper(&sarama.DescribeGroupsResponse{
+		Version: 4,
+		Groups:  []*sarama.GroupDescription{{GroupId: "billing", State: "Empty"}},
+	})
+	shipping := sarama.NewMockWrapper(&sarama.DescribeGroupsResponse{
+		Version: 4,
+		Groups: []*sarama.GroupDescription{{
+			GroupId: "shipping",
+			State:   "Stable",
+			Members: map[string]*sarama.GroupMemberDescription{"member": {MemberId: "member", ClientId: "shipping-1"}},
+		}},
+	})
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		// billing is described by both resets before shipping
+		"DescribeGroupsRequest": sarama.NewMockSequence(billing, billing, shipping),
+		// partition 1 has no message produced after ts
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetOldest, 5).
+			SetOffset("orders", 1, sarama.OffsetOldest, 8).
+			SetOffset("orders", 0, ts.UnixMilli(), 20).
+			SetOffset("orders", 1, ts.UnixMilli(), -1).
+			SetOffset("orders", 1, sarama.OffsetNewest, 30),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "billing", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "shipping", broker),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	admin := newTestAdmin(t, broker)
+	defer admin.Close()
+
+	// committed returns the offsets of the last commit by partition
+	committed := func() map[int32]int64 {
+		offsets := map[int32]int64{}
+		for _, item := range broker.History() {
+			if request, ok := item.Request.(*sarama.OffsetCommitRequest); ok {
+				for partition := int32(0); partition < 2; partition++ {
+					if offset, _, err := request.Offset("orders", partition); err == nil {
+						offsets[partition] = offset
+					}
+				}
+			}
+		}
+		return offsets
+	}
+
+	t.Log("Given the need to replay the messages of a topic")
+	{
+		if err := admin.ResetOffsetsToEarliest(context.Background(), "billing", "orders"); err != nil {
+			t.Fatalf("\tShould reset the offsets. %v %v", ballotX, err)
+		}
+		if offsets := committed(); offsets[0] != 5 || offsets[1] != 8 {
+			t.Errorf("\tShould commit the oldest offsets. %v %v", ballotX, offsets)
+		} else {
+			t.Logf("\tShould commit the oldest offsets. %v", checkMark)
+		}
+
+		if err := admin.ResetOffsetsToTimestamp(context.Background(), "billing", "orders", ts); err != nil {
+			t.Fatalf("\tShould reset the offsets. %v %v", ballotX, err)
+		}
+		if offsets := committed(); offsets[0] != 20 || offsets[1] != 30 {
+			t.Errorf("\tShould commit the offsets at the timestamp or the end of the partition. %v %v", ballotX, offsets)
+		} else {
+			t.Logf("\tShould commit the offsets at the timestamp or the end of the partition. %v", checkMark)
+		}
+
+		if err := admin.ResetOffsetsToEarliest(context.Background(), "shipping", "orders"); !errors.Is(err, kafka.ErrGroupActive) {
+			t.Errorf("\tShould refuse to reset the offsets of an active group. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould refuse to reset the offsets of an active group. %v", checkMark)
+		}
+	}
+}
+
+// TestACLs validates ACLs are created, listed and deleted
+func TestACLs(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetController(broker.BrokerID()).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+		"CreateAclsRequest":   sarama.NewMockCreateAclsResponse(t),
+		"DescribeAclsRequest": sarama.NewMockListAclsResponse(t),
+		"DeleteAclsRequest":   sarama.NewMockDeleteAclsResponse(t),
+	})
+
+	admin := newTestAdmin(t, broker)
+	defer admin.Close()
+
+	acl := kafka.ACL{
+		Principal:    "User:billing",
+		ResourceType: sarama.AclResourceTopic,
+		ResourceName: "orders",
+		Operation:    sarama.AclOperationRead,
+		Permission:   sarama.AclPermissionAllow,
+	}
+
+	t.Log("Given the need to manage the permissions of a service")
+	{
+		if err := admin.CreateACLs(context.Background(), acl); err != nil {
+			t.Fatalf("\tShould create the ACL. %v %v", ballotX, err)
+		}
+		var created *sarama.CreateAclsRequest
+		for _, item := range broker.History() {
+			if request, ok := item.Request.(*sarama.CreateAclsRequest); ok {
+				created = request
+			}
+		}
+		if created == nil || len(created.AclCreations) != 1 || created.AclCreations[0].Host != "*" ||
+			created.AclCreations[0].ResourcePatternType != sarama.AclPatternLiteral {
+			t.Errorf("\tShould create a literal ACL for any host. %v", ballotX)
+		} else {
+			t.Logf("\tShould create a literal ACL for any host. %v", checkMark)
+		}
+
+		principal, name := acl.Principal, acl.ResourceName
+		acls, err := admin.ListACLs(context.Background(), sarama.AclFilter{
+			ResourceType:              sarama.AclResourceTopic,
+			ResourceName:              &name,
+			ResourcePatternTypeFilter: sarama.AclPatternLiteral,
+			Principal:                 &principal,
+			Operation:                 sarama.AclOperationRead,
+			PermissionType:            sarama.AclPermissionAny,
+		})
+		if err != nil || len(acls) != 1 || acls[0].Principal != acl.Principal || acls[0].ResourceName != acl.ResourceName {
+			t.Errorf("\tShould list the ACLs matching the filter. %v %v %+v", ballotX, err, acls)
+		} else {
+			t.Logf("\tShould list the ACLs matching the filter. %v", checkMark)
+		}
+
+		if err := admin.DeleteACLs(context.Background(), acl); err != nil {
+			t.Fatalf("\tShould delete the ACL. %v %v", ballotX, err)
+		}
+		var deleted *sarama.DeleteAclsRequest
+		for _, item := range broker.History() {
+			if request, ok := item.Request.(*sarama.DeleteAclsRequest); ok {
+				deleted = request
+			}
+		}
+		if deleted == nil || len(deleted.Filters) != 1 || *deleted.Filters[0].Principal != acl.Principal || *deleted.Filters[0].Host != "*" {
+			t.Errorf("\tShould delete the ACL of the principal. %v", ballotX)
+		} else {
+			t.Logf("\tShould delete the ACL of the principal. %v", checkMark)
+		}
+	}
+}
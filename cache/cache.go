@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -29,10 +31,16 @@ type CacheHelper interface {
 	GetType(ctx context.Context, key string) (string, error)
 }
 
+// CacheScripting runs Lua scripts, it is implemented by the redis helpers
+type CacheScripting interface {
+	EvalScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error)
+}
+
 type CacheHelperEnhancement interface {
 	CacheHelper
 	GetTransaction(ctx context.Context, transactionID string) CacheTransactionExecution
 	GetPipeline(ctx context.Context, transactionID string) CachePipelineExecution
+	Watch(ctx context.Context, fn func(CacheWatchTransaction) error, keys ...string) error
 }
 
 type CacheCommandType string
@@ -62,10 +70,12 @@ type (
 
 	CacheTransactionExecution interface {
 		CacheMutilCommandBuilder
+		CacheCommandBuilder
 	}
 
 	CachePipelineExecution interface {
 		CacheMutilCommandBuilder
+		CacheCommandBuilder
 	}
 
 	CachePipelineResult struct {
@@ -96,8 +106,9 @@ func (r *baseRedisCachePipeline) Exec(context.Context) (result []CachePipelineRe
 		outputResult []redis.Cmder
 	)
 
+	// redis.Nil of a single command is reported in its own result
 	outputResult, err = r.Pipeliner.Exec()
-	if err != nil {
+	if err != nil && err != redis.Nil {
 		return nil, err
 	}
 
@@ -134,6 +145,16 @@ func (r *baseRedisCachePipeline) Exec(context.Context) (result []CachePipelineRe
 				Result: []interface{}{v.Val()},
 				Err:    item.Err(),
 			}
+		case *redis.BoolCmd:
+			result[index] = CachePipelineResult{
+				Result: []interface{}{v.Val()},
+				Err:    item.Err(),
+			}
+		case *redis.StatusCmd:
+			result[index] = CachePipelineResult{
+				Result: []interface{}{v.Val()},
+				Err:    item.Err(),
+			}
 		default:
 			result[index] = CachePipelineResult{
 				Result: item.Args(),
@@ -148,39 +169,80 @@ func (r *baseRedisCachePipeline) Discard(context.Context) error {
 	return r.Pipeliner.Discard()
 }
 
+// BuildCommand queues a command from positional data, data[0] is always the key:
+//   - Get, GetInterface, Increase, Del: key
+//   - AddMemberWithScore: key, member, score
+//   - GetMembersWithScore: key, start, stop
+//   - RemoveMembersWithScore: key, min, max
+//   - SetNX: key, value, expiration or key, value, ttl, unit
+//   - Expire: key, expiration or key, ttl, unit
 func (r *baseRedisCachePipeline) BuildCommand(ctx context.Context, cacheCommandType CacheCommandType, data ...interface{}) (err error) {
 	if len(data) == 0 {
 		return errors.New("missing data to process")
 	}
 
+	keyCache, ok := data[0].(string)
+	if !ok {
+		return fmt.Errorf("key of %s must be a string, got %T", cacheCommandType, data[0])
+	}
 	var (
-		keyCache string = data[0].(string)
-		cmd      redis.Cmder
+		args = data[1:]
+		cmd  redis.Cmder
 	)
 
 	switch cacheCommandType {
-	case CacheCommandTypeGetInterface:
+	case CacheCommandTypeGet, CacheCommandTypeGetInterface:
 		cmd = r.Pipeliner.Get(keyCache)
 	case CacheCommandTypeAddMemberWithScore:
-		member := redis.Z{
-			Member: data[1],
-			Score:  data[2].(float64),
+		if len(args) != 2 {
+			return fmt.Errorf("%s requires key, member and score", cacheCommandType)
+		}
+		score, err := float64Arg(args[1])
+		if err != nil {
+			return err
+		}
+		cmd = r.Pipeliner.ZAdd(keyCache, redis.Z{
+			Member: args[0],
+			Score:  score,
+		})
+	case CacheCommandTypeGetMembersWithScore:
+		if len(args) != 2 {
+			return fmt.Errorf("%s requires key, start and stop", cacheCommandType)
+		}
+		start, err := int64Arg(args[0])
+		if err != nil {
+			return err
 		}
-		cmd = r.Pipeliner.ZAdd(keyCache, member)
+		stop, err := int64Arg(args[1])
+		if err != nil {
+			return err
+		}
+		cmd = r.Pipeliner.ZRangeWithScores(keyCache, start, stop)
 	case CacheCommandTypeRemoveMembersWithScore:
-		var (
-			min string = data[1].(string)
-			max string = data[2].(string)
-		)
+		if len(args) != 2 {
+			return fmt.Errorf("%s requires key, min and max", cacheCommandType)
+		}
+		min, okMin := args[0].(string)
+		max, okMax := args[1].(string)
+		if !okMin || !okMax {
+			return fmt.Errorf("min and max of %s must be strings", cacheCommandType)
+		}
 		cmd = r.Pipeliner.ZRemRangeByScore(keyCache, min, max)
+	case CacheCommandTypeSetNX:
+		if len(args) < 2 {
+			return fmt.Errorf("%s requires key, value and expiration", cacheCommandType)
+		}
+		expiration, err := expirationArg(args[1:])
+		if err != nil {
+			return err
+		}
+		cmd = r.Pipeliner.SetNX(keyCache, args[0], expiration)
 	case CacheCommandTypeExpire:
-		var (
-			value    interface{}   = data[1]
-			ttl      uint32        = data[2].(uint32)
-			duration time.Duration = data[3].(time.Duration)
-		)
-
-		cmd = r.Pipeliner.SetNX(keyCache, value, time.Duration(ttl)*(duration))
+		expiration, err := expirationArg(args)
+		if err != nil {
+			return err
+		}
+		cmd = r.Pipeliner.Expire(keyCache, expiration)
 	case CacheCommandTypeIncrease:
 		cmd = r.Pipeliner.Incr(keyCache)
 	case CacheCommandTypeDel:
@@ -194,6 +256,55 @@ func (r *baseRedisCachePipeline) BuildCommand(ctx context.Context, cacheCommandT
 	return nil
 }
 
+// expirationArg accepts either a time.Duration or a ttl followed by its unit
+func expirationArg(args []interface{}) (time.Duration, error) {
+	switch len(args) {
+	case 1:
+		if expiration, ok := args[0].(time.Duration); ok {
+			return expiration, nil
+		}
+	case 2:
+		ttl, err := int64Arg(args[0])
+		if err != nil {
+			return 0, err
+		}
+		if unit, ok := args[1].(time.Duration); ok {
+			return time.Duration(ttl) * unit, nil
+		}
+	}
+	return 0, errors.New("expiration must be a time.Duration or a ttl followed by a time.Duration unit")
+}
+
+func int64Arg(arg interface{}) (int64, error) {
+	switch v := arg.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("expected an integer, got %T", arg)
+}
+
+func float64Arg(arg interface{}) (float64, error) {
+	switch v := arg.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	value, err := int64Arg(arg)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number, got %T", arg)
+	}
+	return float64(value), nil
+}
+
 func (r *baseRedisCachePipeline) GetCommands(context.Context) (CacheLazyExecute, error) {
 	return r, nil
 }
@@ -204,29 +315,79 @@ type CacheOption struct {
 	Value interface{}
 }
 
+// NewCacheHelper creates a helper over a single node, or a cluster when
+// several addresses are given, and panics when redis is not reachable. The
+// "redlock" option set to true makes the addresses independent nodes, see
+// WithRedlock. Use NewRedisCacheHelper to configure the connection and
+// handle the error
 func NewCacheHelper(addrs []string, opts ...CacheOption) CacheHelper {
-	if len(addrs) > 1 {
-		clusterClient, err := initRedisCluster(addrs)
-		if err != nil {
-			zap.S().Panic("Failed to inti redis cluster", zap.Error(err))
-		}
-		return &clusterRedisHelper{
-			clusterClient: clusterClient,
-		}
-	}
-
-	// get db config
-	var db int = 0
+	var redisOpts []RedisOption
 	for _, item := range opts {
-		if item.Key == "db" {
-			db = item.Value.(int)
+		switch item.Key {
+		case "db":
+			if db, ok := item.Value.(int); ok {
+				redisOpts = append(redisOpts, WithDB(db))
+			}
+		case "redlock":
+			if redlock, ok := item.Value.(bool); ok && redlock {
+				redisOpts = append(redisOpts, WithRedlock())
+			}
 		}
 	}
-	client, err := initRedis(addrs[0], db)
+
+	helper, err := NewRedisCacheHelper(addrs, redisOpts...)
 	if err != nil {
 		zap.S().Panic("Failed to init redis", zap.Error(err))
 	}
-	return &redisHelper{
-		client: client,
+	return helper
+}
+
+// NewRedisCacheHelper creates a helper over a single node, a cluster when
+// several addresses are given, independent nodes with WithRedlock or the
+// master of a sentinel deployment with WithSentinel
+func NewRedisCacheHelper(addrs []string, opts ...RedisOption) (CacheHelper, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("missing redis address")
+	}
+
+	options := &redisOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.err != nil {
+		return nil, options.err
+	}
+
+	switch {
+	case options.masterName != "":
+		client, err := initRedisFailover(options.failover(addrs))
+		if err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+		instrument(client, options, options.masterName)
+		return newRedisHelper(client), nil
+	case options.redlock && len(addrs) > 1:
+		helper, err := newRedlockHelper(addrs, options)
+		if err != nil {
+			return nil, err
+		}
+		return helper, nil
+	case len(addrs) > 1:
+		clusterClient, err := initRedisCluster(options.cluster(addrs))
+		if err != nil {
+			_ = clusterClient.Close()
+			return nil, err
+		}
+		instrument(clusterClient, options, strings.Join(addrs, ","))
+		return newClusterRedisHelper(clusterClient), nil
+	}
+
+	client, err := initRedis(options.client(addrs[0]))
+	if err != nil {
+		_ = client.Close()
+		return nil, err
 	}
+	instrument(client, options, addrs[0])
+	return newRedisHelper(client), nil
 }
@@ -0,0 +1,60 @@
+package cache_test
+
+import (
+	"lib/cache"
+	"reflect"
+	"testing"
+)
+
+const checkMark = "✓"
+const ballotX = "✗"
+
+type codecUser struct {
+	Name  string
+	Email string
+	Age   int
+}
+
+// TestCodecRoundTrip validates every codec decodes what it encodes
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := []cache.Codec{cache.JSONCodec, cache.GobCodec, cache.MsgpackCodec}
+	want := codecUser{Name: "Bill", Email: "bill@ardanstudios.com", Age: 42}
+
+	t.Log("Given the need to encode cache values with different codecs")
+	{
+		for _, codec := range codecs {
+			t.Logf("\tWhen using the %q codec", codec.Name())
+			{
+				data, err := codec.Marshal(want)
+				if err != nil {
+					t.Fatalf("\t\tShould be able to marshal the value. %v %v", ballotX, err)
+				}
+				t.Logf("\t\tShould be able to marshal the value. %v", checkMark)
+
+				var got codecUser
+				if err := codec.Unmarshal(data, &got); err != nil {
+					t.Fatalf("\t\tShould be able to unmarshal the value. %v %v", ballotX, err)
+				}
+				t.Logf("\t\tShould be able to unmarshal the value. %v", checkMark)
+
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("\t\tShould get back the same value. %v %+v", ballotX, got)
+				} else {
+					t.Logf("\t\tShould get back the same value. %v", checkMark)
+				}
+			}
+		}
+	}
+}
+
+// TestProtobufCodecRejectsPlainStruct validates protobuf codec requires proto.Message
+func TestProtobufCodecRejectsPlainStruct(t *testing.T) {
+	t.Log("Given the need to encode a value which is not a protobuf message")
+	{
+		if _, err := cache.ProtobufCodec.Marshal(codecUser{}); err == nil {
+			t.Errorf("\tShould return an error. %v", ballotX)
+		} else {
+			t.Logf("\tShould return an error. %v", checkMark)
+		}
+	}
+}
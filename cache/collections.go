@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// CacheCollections represents operations on redis data structures
+type CacheCollections interface {
+	// Hashes
+	HSet(ctx context.Context, key string, fields map[string]interface{}) error
+	HGet(ctx context.Context, key, field string) (string, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key string, fields ...string) (int64, error)
+	HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error)
+
+	// Sets
+	SAdd(ctx context.Context, key string, members ...interface{}) (int64, error)
+	SRem(ctx context.Context, key string, members ...interface{}) (int64, error)
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
+
+	// Sorted sets
+	ZAdd(ctx context.Context, key string, members ...redis.Z) (int64, error)
+	ZIncrBy(ctx context.Context, key string, increment float64, member string) (float64, error)
+	ZRem(ctx context.Context, key string, members ...interface{}) (int64, error)
+	ZRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) ([]redis.Z, error)
+	ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) ([]redis.Z, error)
+
+	// Lists
+	LPush(ctx context.Context, key string, values ...interface{}) (int64, error)
+	RPush(ctx context.Context, key string, values ...interface{}) (int64, error)
+	LRange(ctx context.Context, key string, start, stop int64) ([]string, error)
+	BRPop(ctx context.Context, timeout time.Duration, keys ...string) ([]string, error)
+
+	// Streams
+	XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (string, error)
+	XGroupCreate(ctx context.Context, stream, group, start string) error
+	XReadGroup(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) ([]redis.XStream, error)
+	XAck(ctx context.Context, stream, group string, ids ...string) (int64, error)
+}
+
+// redisCollections implements CacheCollections for both single node and
+// cluster clients
+type redisCollections struct {
+	client redis.Cmdable
+	name   string
+}
+
+func (c *redisCollections) startSpan(ctx context.Context, method string) opentracing.Span {
+	return jaeger.Start(ctx, ">helper."+c.name+"/"+method, ext.SpanKindRPCClient)
+}
+
+func (c *redisCollections) HSet(ctx context.Context, key string, fields map[string]interface{}) (err error) {
+	span := c.startSpan(ctx, "HSet")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.HMSet(key, fields).Err()
+}
+
+func (c *redisCollections) HGet(ctx context.Context, key, field string) (value string, err error) {
+	span := c.startSpan(ctx, "HGet")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.HGet(key, field).Result()
+}
+
+func (c *redisCollections) HGetAll(ctx context.Context, key string) (values map[string]string, err error) {
+	span := c.startSpan(ctx, "HGetAll")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.HGetAll(key).Result()
+}
+
+func (c *redisCollections) HDel(ctx context.Context, key string, fields ...string) (count int64, err error) {
+	span := c.startSpan(ctx, "HDel")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.HDel(key, fields...).Result()
+}
+
+func (c *redisCollections) HIncrBy(ctx context.Context, key, field string, increment int64) (value int64, err error) {
+	span := c.startSpan(ctx, "HIncrBy")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.HIncrBy(key, field, increment).Result()
+}
+
+func (c *redisCollections) SAdd(ctx context.Context, key string, members ...interface{}) (count int64, err error) {
+	span := c.startSpan(ctx, "SAdd")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.SAdd(key, members...).Result()
+}
+
+func (c *redisCollections) SRem(ctx context.Context, key string, members ...interface{}) (count int64, err error) {
+	span := c.startSpan(ctx, "SRem")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.SRem(key, members...).Result()
+}
+
+func (c *redisCollections) SMembers(ctx context.Context, key string) (members []string, err error) {
+	span := c.startSpan(ctx, "SMembers")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.SMembers(key).Result()
+}
+
+func (c *redisCollections) SIsMember(ctx context.Context, key string, member interface{}) (isMember bool, err error) {
+	span := c.startSpan(ctx, "SIsMember")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.SIsMember(key, member).Result()
+}
+
+func (c *redisCollections) ZAdd(ctx context.Context, key string, members ...redis.Z) (count int64, err error) {
+	span := c.startSpan(ctx, "ZAdd")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.ZAdd(key, members...).Result()
+}
+
+func (c *redisCollections) ZIncrBy(ctx context.Context, key string, increment float64, member string) (score float64, err error) {
+	span := c.startSpan(ctx, "ZIncrBy")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.ZIncrBy(key, increment, member).Result()
+}
+
+func (c *redisCollections) ZRem(ctx context.Context, key string, members ...interface{}) (count int64, err error) {
+	span := c.startSpan(ctx, "ZRem")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.ZRem(key, members...).Result()
+}
+
+// ZRangeByScore returns members with their scores between min and max, use
+// "-inf" and "+inf" for open bounds and a zero count for no limit
+func (c *redisCollections) ZRangeByScore(ctx context.Context, key string, min, max string, offset, count int64) (members []redis.Z, err error) {
+	span := c.startSpan(ctx, "ZRangeByScore")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.ZRangeByScoreWithScores(key, redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: offset,
+		Count:  count,
+	}).Result()
+}
+
+func (c *redisCollections) ZRevRangeWithScores(ctx context.Context, key string, start, stop int64) (members []redis.Z, err error) {
+	span := c.startSpan(ctx, "ZRevRangeWithScores")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.ZRevRangeWithScores(key, start, stop).Result()
+}
+
+func (c *redisCollections) LPush(ctx context.Context, key string, values ...interface{}) (length int64, err error) {
+	span := c.startSpan(ctx, "LPush")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.LPush(key, values...).Result()
+}
+
+func (c *redisCollections) RPush(ctx context.Context, key string, values ...interface{}) (length int64, err error) {
+	span := c.startSpan(ctx, "RPush")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.RPush(key, values...).Result()
+}
+
+func (c *redisCollections) LRange(ctx context.Context, key string, start, stop int64) (values []string, err error) {
+	span := c.startSpan(ctx, "LRange")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.LRange(key, start, stop).Result()
+}
+
+// BRPop returns the popped key and value, ErrCacheMiss when timeout elapsed
+func (c *redisCollections) BRPop(ctx context.Context, timeout time.Duration, keys ...string) (values []string, err error) {
+	span := c.startSpan(ctx, "BRPop")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.BRPop(timeout, keys...).Result()
+}
+
+// XAdd appends values to stream and returns the generated ID, stream is capped
+// to approximately maxLen entries when maxLen is positive
+func (c *redisCollections) XAdd(ctx context.Context, stream string, maxLen int64, values map[string]interface{}) (id string, err error) {
+	span := c.startSpan(ctx, "XAdd")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: maxLen,
+		Values:       values,
+	}).Result()
+}
+
+// XGroupCreate creates a consumer group, and the stream if it does not exist
+func (c *redisCollections) XGroupCreate(ctx context.Context, stream, group, start string) (err error) {
+	span := c.startSpan(ctx, "XGroupCreate")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.XGroupCreateMkStream(stream, group, start).Err()
+}
+
+// XReadGroup reads new messages of streams for consumer, block zero waits
+// forever and a negative block returns immediately
+func (c *redisCollections) XReadGroup(ctx context.Context, group, consumer string, streams []string, count int64, block time.Duration) (result []redis.XStream, err error) {
+	span := c.startSpan(ctx, "XReadGroup")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	ids := make([]string, 0, len(streams)*2)
+	ids = append(ids, streams...)
+	for range streams {
+		ids = append(ids, ">")
+	}
+	return c.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  ids,
+		Count:    count,
+		Block:    block,
+	}).Result()
+}
+
+func (c *redisCollections) XAck(ctx context.Context, stream, group string, ids ...string) (count int64, err error) {
+	span := c.startSpan(ctx, "XAck")
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return c.client.XAck(stream, group, ids...).Result()
+}
@@ -0,0 +1,117 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// TestCollections validates the hash, set, sorted set, list and stream helpers
+func TestCollections(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+
+	collections, ok := helper.(cache.CacheCollections)
+	if !ok {
+		t.Fatalf("Should implement CacheCollections. %v", ballotX)
+	}
+
+	t.Log("Given the need to store a session in a hash")
+	{
+		if err := collections.HSet(ctx, "session", map[string]interface{}{"user": "u1", "visits": 1}); err != nil {
+			t.Fatalf("\tShould set the fields. %v %v", ballotX, err)
+		}
+		if visits, err := collections.HIncrBy(ctx, "session", "visits", 2); err != nil || visits != 3 {
+			t.Errorf("\tShould increment a field. %v %d %v", ballotX, visits, err)
+		} else {
+			t.Logf("\tShould increment a field. %v", checkMark)
+		}
+		if removed, err := collections.HDel(ctx, "session", "visits"); err != nil || removed != 1 {
+			t.Errorf("\tShould delete a field. %v %d %v", ballotX, removed, err)
+		}
+		if fields, err := collections.HGetAll(ctx, "session"); err != nil || len(fields) != 1 || fields["user"] != "u1" {
+			t.Errorf("\tShould get all the fields. %v %v %v", ballotX, fields, err)
+		} else {
+			t.Logf("\tShould get all the fields. %v", checkMark)
+		}
+	}
+
+	t.Log("Given the need to track the members of a set")
+	{
+		if added, err := collections.SAdd(ctx, "online", "u1", "u2", "u1"); err != nil || added != 2 {
+			t.Fatalf("\tShould add the distinct members. %v %d %v", ballotX, added, err)
+		}
+		if _, err := collections.SRem(ctx, "online", "u2"); err != nil {
+			t.Fatalf("\tShould remove a member. %v %v", ballotX, err)
+		}
+		member, err := collections.SIsMember(ctx, "online", "u2")
+		if members, membersErr := collections.SMembers(ctx, "online"); err != nil || membersErr != nil || member || len(members) != 1 {
+			t.Errorf("\tShould keep the remaining members. %v %v %v", ballotX, members, err)
+		} else {
+			t.Logf("\tShould keep the remaining members. %v", checkMark)
+		}
+	}
+
+	t.Log("Given the need to rank players in a leaderboard")
+	{
+		if _, err := collections.ZAdd(ctx, "leaderboard", redis.Z{Score: 10, Member: "u1"}, redis.Z{Score: 20, Member: "u2"}); err != nil {
+			t.Fatalf("\tShould add the scores. %v %v", ballotX, err)
+		}
+		if score, err := collections.ZIncrBy(ctx, "leaderboard", 15, "u1"); err != nil || score != 25 {
+			t.Errorf("\tShould increment a score. %v %v %v", ballotX, score, err)
+		}
+		if top, err := collections.ZRevRangeWithScores(ctx, "leaderboard", 0, 0); err != nil || len(top) != 1 || top[0].Member != "u1" {
+			t.Errorf("\tShould rank the highest score first. %v %v %v", ballotX, top, err)
+		} else {
+			t.Logf("\tShould rank the highest score first. %v", checkMark)
+		}
+		if members, err := collections.ZRangeByScore(ctx, "leaderboard", "15", "+inf", 0, 10); err != nil || len(members) != 2 || members[0].Member != "u2" {
+			t.Errorf("\tShould return the members in a score range. %v %v %v", ballotX, members, err)
+		} else {
+			t.Logf("\tShould return the members in a score range. %v", checkMark)
+		}
+	}
+
+	t.Log("Given the need to queue jobs in a list")
+	{
+		if _, err := collections.LPush(ctx, "jobs", "j1", "j2"); err != nil {
+			t.Fatalf("\tShould push the jobs. %v %v", ballotX, err)
+		}
+		if values, err := collections.BRPop(ctx, time.Second, "jobs"); err != nil || len(values) != 2 || values[1] != "j1" {
+			t.Errorf("\tShould pop the oldest job. %v %v %v", ballotX, values, err)
+		} else {
+			t.Logf("\tShould pop the oldest job. %v", checkMark)
+		}
+		if values, err := collections.LRange(ctx, "jobs", 0, -1); err != nil || len(values) != 1 || values[0] != "j2" {
+			t.Errorf("\tShould keep the remaining job. %v %v %v", ballotX, values, err)
+		} else {
+			t.Logf("\tShould keep the remaining job. %v", checkMark)
+		}
+	}
+
+	t.Log("Given the need to consume a stream with a group")
+	{
+		if err := collections.XGroupCreate(ctx, "events", "workers", "$"); err != nil {
+			t.Fatalf("\tShould create the group and the stream. %v %v", ballotX, err)
+		}
+		id, err := collections.XAdd(ctx, "events", 100, map[string]interface{}{"type": "created"})
+		if err != nil {
+			t.Fatalf("\tShould add an entry. %v %v", ballotX, err)
+		}
+
+		streams, err := collections.XReadGroup(ctx, "workers", "w1", []string{"events"}, 10, -1)
+		if err != nil || len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != id {
+			t.Fatalf("\tShould read the new entry. %v %v %v", ballotX, streams, err)
+		}
+		t.Logf("\tShould read the new entry. %v", checkMark)
+
+		if acked, err := collections.XAck(ctx, "events", "workers", id); err != nil || acked != 1 {
+			t.Errorf("\tShould acknowledge the entry. %v %d %v", ballotX, acked, err)
+		} else {
+			t.Logf("\tShould acknowledge the entry. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned by GetOrLoad when the value is cached as not found
+// (negative caching), loaders may return it to signal a missing value
+var ErrNotFound = errors.New("cache: value not found")
+
+const (
+	entryFlagValue    byte = 0
+	entryFlagNotFound byte = 1
+	entryHeaderSize        = 9
+
+	defaultLockRetryInterval = 50 * time.Millisecond
+	defaultLoadTimeout       = 10 * time.Second
+)
+
+type (
+	// LoaderOption represents option of Loader
+	LoaderOption func(*loaderOptions)
+
+	loaderOptions struct {
+		lockTTL           time.Duration
+		lockWait          time.Duration
+		lockRetryInterval time.Duration
+		loadTimeout       time.Duration
+		staleTTL          time.Duration
+		notFoundTTL       time.Duration
+		isNotFound        func(error) bool
+	}
+
+	// Loader implements read-through caching of values of type T. Keys handled
+	// by a loader with stale-while-revalidate or negative caching enabled are
+	// stored with a small header and must only be read through the loader
+	Loader[T any] struct {
+		typed   *Typed[T]
+		locker  Locker
+		group   singleflight.Group
+		options loaderOptions
+	}
+
+	loaderEntry[T any] struct {
+		value      T
+		notFound   bool
+		softExpiry time.Time
+	}
+)
+
+// WithLoadLock takes a distributed lock with a Locker before calling the loader
+// so only one pod loads a missing key, other pods wait up to wait for the value
+// to appear in cache before loading it themselves
+func WithLoadLock(ttl, wait time.Duration) LoaderOption {
+	return func(o *loaderOptions) {
+		o.lockTTL = ttl
+		o.lockWait = wait
+	}
+}
+
+// WithLoadTimeout bounds the calls to the loader, 10 seconds by default. The
+// loader does not run with the context of the caller as it is shared by the
+// concurrent misses of the key
+func WithLoadTimeout(timeout time.Duration) LoaderOption {
+	return func(o *loaderOptions) {
+		o.loadTimeout = timeout
+	}
+}
+
+// WithStaleWhileRevalidate keeps values for staleTTL after their expiration,
+// expired values are returned immediately while being reloaded in background
+func WithStaleWhileRevalidate(staleTTL time.Duration) LoaderOption {
+	return func(o *loaderOptions) {
+		o.staleTTL = staleTTL
+	}
+}
+
+// WithNegativeCache caches "not found" results of the loader for ttl, isNotFound
+// reports whether a loader error means not found, by default ErrNotFound
+func WithNegativeCache(ttl time.Duration, isNotFound func(error) bool) LoaderOption {
+	return func(o *loaderOptions) {
+		o.notFoundTTL = ttl
+		if isNotFound != nil {
+			o.isNotFound = isNotFound
+		}
+	}
+}
+
+// NewLoader creates a read-through loader over a typed cache
+func NewLoader[T any](typed *Typed[T], opts ...LoaderOption) *Loader[T] {
+	options := loaderOptions{
+		lockRetryInterval: defaultLockRetryInterval,
+		loadTimeout:       defaultLoadTimeout,
+		isNotFound: func(err error) bool {
+			return errors.Is(err, ErrNotFound)
+		},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	loader := &Loader[T]{
+		typed:   typed,
+		options: options,
+	}
+	if options.lockTTL > 0 {
+		var helper CacheHelper = typed.helper
+		if tiered, ok := helper.(*tieredCacheHelper); ok {
+			helper = tiered.remote
+		}
+		locker, err := NewLocker([]CacheHelper{helper})
+		if err != nil {
+			zap.S().Warnw("Failed to create load lock, keys are loaded without lock", "error", err)
+		}
+		loader.locker = locker
+	}
+	return loader
+}
+
+// GetOrLoad returns value of key, on cache miss value is loaded by loader and
+// stored with expiration. Concurrent misses of the same key in this process
+// share a single call to loader, which keeps running when ctx is done
+func (l *Loader[T]) GetOrLoad(ctx context.Context, key string, expiration time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	entry, err := l.read(ctx, key)
+	if err == nil {
+		if !entry.softExpiry.IsZero() && time.Now().After(entry.softExpiry) {
+			l.revalidate(key, expiration, loader)
+		}
+		if entry.notFound {
+			return entry.value, ErrNotFound
+		}
+		return entry.value, nil
+	}
+	if err != ErrCacheMiss {
+		return entry.value, err
+	}
+
+	results := l.group.DoChan(key, func() (interface{}, error) {
+		loadCtx, cancel := l.loadContext(ctx)
+		defer cancel()
+		return l.load(loadCtx, key, expiration, loader)
+	})
+	select {
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			var zero T
+			return zero, result.Err
+		}
+		return result.Val.(T), nil
+	}
+}
+
+// loadContext detaches the load from ctx, keeping its span, so a caller
+// giving up does not fail the other callers waiting for the same key
+func (l *Loader[T]) loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	loadCtx := context.Background()
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		loadCtx = opentracing.ContextWithSpan(loadCtx, span)
+	}
+	return context.WithTimeout(loadCtx, l.options.loadTimeout)
+}
+
+// revalidate reloads an expired value in background, at most once at a time
+// per key
+func (l *Loader[T]) revalidate(key string, expiration time.Duration, loader func(ctx context.Context) (T, error)) {
+	go func() {
+		_, err, _ := l.group.Do(key, func() (interface{}, error) {
+			ctx, cancel := l.loadContext(context.Background())
+			defer cancel()
+			return l.loadAndStore(ctx, key, expiration, loader)
+		})
+		if err != nil && !l.options.isNotFound(err) {
+			zap.S().Warnw("Failed to revalidate cache key", "key", key, "error", err)
+		}
+	}()
+}
+
+func (l *Loader[T]) load(ctx context.Context, key string, expiration time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	if l.locker == nil {
+		return l.loadAndStore(ctx, key, expiration, loader)
+	}
+
+	lock, err := l.locker.TryLock(ctx, fmt.Sprintf("%s:loadlock", key), l.options.lockTTL)
+	if err == nil {
+		defer func() {
+			// the lock is only released if it did not expire meanwhile
+			if err := l.locker.Unlock(ctx, lock); err != nil {
+				zap.S().Debugw("Failed to release load lock", "key", key, "error", err)
+			}
+		}()
+
+		// another pod may have stored the key between the miss and the lock
+		if entry, err := l.read(ctx, key); err == nil {
+			if entry.notFound {
+				return entry.value, ErrNotFound
+			}
+			return entry.value, nil
+		}
+		return l.loadAndStore(ctx, key, expiration, loader)
+	}
+	if err != ErrLockNotObtained {
+		return l.loadAndStore(ctx, key, expiration, loader)
+	}
+
+	// another pod is loading the key, wait for it to be stored
+	deadline := time.Now().Add(l.options.lockWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-time.After(l.options.lockRetryInterval):
+		}
+
+		entry, err := l.read(ctx, key)
+		if err == nil {
+			if entry.notFound {
+				return entry.value, ErrNotFound
+			}
+			return entry.value, nil
+		}
+		if err != ErrCacheMiss {
+			break
+		}
+	}
+	return l.loadAndStore(ctx, key, expiration, loader)
+}
+
+func (l *Loader[T]) loadAndStore(ctx context.Context, key string, expiration time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	value, err := loader(ctx)
+	if err != nil {
+		if l.options.notFoundTTL > 0 && l.options.isNotFound(err) {
+			if storeErr := l.write(ctx, key, loaderEntry[T]{notFound: true}, l.options.notFoundTTL); storeErr != nil {
+				zap.S().Warnw("Failed to cache not found value", "key", key, "error", storeErr)
+			}
+			return value, ErrNotFound
+		}
+		return value, err
+	}
+
+	if err = l.write(ctx, key, loaderEntry[T]{value: value}, expiration); err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// enveloped reports whether entries need a header to store their metadata
+func (l *Loader[T]) enveloped() bool {
+	return l.options.staleTTL > 0 || l.options.notFoundTTL > 0
+}
+
+func (l *Loader[T]) read(ctx context.Context, key string) (entry loaderEntry[T], err error) {
+	if !l.enveloped() {
+		entry.value, err = l.typed.Get(ctx, key)
+		return entry, err
+	}
+
+	data, err := l.typed.helper.getBytes(ctx, key)
+	if err != nil {
+		return entry, err
+	}
+	if len(data) < entryHeaderSize {
+		return entry, errors.New("cache: invalid loader entry")
+	}
+
+	if softExpiry := int64(binary.BigEndian.Uint64(data[1:entryHeaderSize])); softExpiry > 0 {
+		entry.softExpiry = time.Unix(0, softExpiry)
+	}
+	if data[0] == entryFlagNotFound {
+		entry.notFound = true
+		return entry, nil
+	}
+	entry.value, err = l.typed.decode(data[entryHeaderSize:])
+	return entry, err
+}
+
+func (l *Loader[T]) write(ctx context.Context, key string, entry loaderEntry[T], expiration time.Duration) error {
+	if !l.enveloped() {
+		return l.typed.Set(ctx, key, entry.value, expiration)
+	}
+
+	data := make([]byte, entryHeaderSize)
+	if entry.notFound {
+		data[0] = entryFlagNotFound
+	} else {
+		payload, err := l.typed.codec.Marshal(entry.value)
+		if err != nil {
+			return err
+		}
+		data[0] = entryFlagValue
+		data = append(data, payload...)
+
+		// keep value physically for the stale period, the soft expiry tells
+		// readers when it must be revalidated
+		if l.options.staleTTL > 0 && expiration > 0 {
+			binary.BigEndian.PutUint64(data[1:entryHeaderSize], uint64(time.Now().Add(expiration).UnixNano()))
+			expiration += l.options.staleTTL
+		}
+	}
+	return l.typed.helper.setBytes(ctx, key, data, expiration)
+}
@@ -0,0 +1,168 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLoaderDeduplicatesMisses validates concurrent misses call the loader once
+func TestLoaderDeduplicatesMisses(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+
+	typed, err := cache.NewTyped[string](helper, nil)
+	if err != nil {
+		t.Fatalf("Should be able to create a typed cache. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to load a missing key from many goroutines")
+	{
+		var calls int32
+		loader := func(ctx context.Context) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+			return "value", nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if value, err := typed.GetOrLoad(ctx, "hot", time.Minute, loader); err != nil || value != "value" {
+					t.Errorf("\tShould load the value. %v %q %v", ballotX, value, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if calls != 1 {
+			t.Errorf("\tShould call the loader once. %v %d", ballotX, calls)
+		} else {
+			t.Logf("\tShould call the loader once. %v", checkMark)
+		}
+	}
+}
+
+// TestLoaderNegativeCache validates not found results are cached
+func TestLoaderNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+
+	typed, err := cache.NewTyped[string](helper, nil)
+	if err != nil {
+		t.Fatalf("Should be able to create a typed cache. %v %v", ballotX, err)
+	}
+	loader := cache.NewLoader(typed, cache.WithNegativeCache(time.Minute, nil), cache.WithStaleWhileRevalidate(time.Minute))
+
+	t.Log("Given the need to cache a missing value")
+	{
+		var calls int32
+		load := func(ctx context.Context) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return "", cache.ErrNotFound
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := loader.GetOrLoad(ctx, "missing", time.Minute, load); err != cache.ErrNotFound {
+				t.Fatalf("\tShould return ErrNotFound. %v %v", ballotX, err)
+			}
+		}
+		t.Logf("\tShould return ErrNotFound. %v", checkMark)
+
+		if calls != 1 {
+			t.Errorf("\tShould call the loader once. %v %d", ballotX, calls)
+		} else {
+			t.Logf("\tShould call the loader once. %v", checkMark)
+		}
+	}
+}
+
+// TestLoaderLoadLock validates a slow loader does not release the lock of another pod
+func TestLoaderLoadLock(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestHelper(t)
+
+	typed, err := cache.NewTyped[string](helper, nil)
+	if err != nil {
+		t.Fatalf("Should be able to create a typed cache. %v %v", ballotX, err)
+	}
+	loader := cache.NewLoader(typed, cache.WithLoadLock(time.Second, 0))
+
+	t.Log("Given the need to load a key under a lock which expires")
+	{
+		load := func(ctx context.Context) (string, error) {
+			// the lock expires and is taken by another pod while loading
+			server.FastForward(2 * time.Second)
+			if err := server.Set("lock:{slow:loadlock}", "other"); err != nil {
+				return "", err
+			}
+			return "value", nil
+		}
+
+		if value, err := loader.GetOrLoad(ctx, "slow", time.Minute, load); err != nil || value != "value" {
+			t.Fatalf("\tShould load the value. %v %q %v", ballotX, value, err)
+		}
+		t.Logf("\tShould load the value. %v", checkMark)
+
+		if owner, err := server.Get("lock:{slow:loadlock}"); err != nil || owner != "other" {
+			t.Errorf("\tShould keep the lock of the other pod. %v %q %v", ballotX, owner, err)
+		} else {
+			t.Logf("\tShould keep the lock of the other pod. %v", checkMark)
+		}
+	}
+}
+
+// TestLoaderDetachedLoad validates a caller giving up does not cancel the load
+// shared with the other callers
+func TestLoaderDetachedLoad(t *testing.T) {
+	_, helper := newTestHelper(t)
+
+	typed, err := cache.NewTyped[string](helper, nil)
+	if err != nil {
+		t.Fatalf("Should be able to create a typed cache. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to share a load between callers with their own deadlines")
+	{
+		started, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		load := func(ctx context.Context) (string, error) {
+			once.Do(func() { close(started) })
+			<-release
+			return "value", ctx.Err()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error, 1)
+		go func() {
+			_, err := typed.GetOrLoad(ctx, "shared", time.Minute, load)
+			first <- err
+		}()
+		<-started
+
+		second := make(chan error, 1)
+		go func() {
+			_, err := typed.GetOrLoad(context.Background(), "shared", time.Minute, load)
+			second <- err
+		}()
+
+		cancel()
+		if err := <-first; err != context.Canceled {
+			t.Errorf("\tShould return when the caller gives up. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould return when the caller gives up. %v", checkMark)
+		}
+
+		close(release)
+		if err := <-second; err != nil {
+			t.Errorf("\tShould keep loading the value for the other caller. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould keep loading the value for the other caller. %v", checkMark)
+		}
+	}
+}
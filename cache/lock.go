@@ -0,0 +1,434 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrLockNotObtained is returned when the lock is held by someone else
+	ErrLockNotObtained = errors.New("cache: lock not obtained")
+	// ErrLockNotHeld is returned when the lock expired or was taken by someone else
+	ErrLockNotHeld = errors.New("cache: lock not held")
+	// ErrInvalidLockTTL is returned for a TTL shorter than a millisecond
+	ErrInvalidLockTTL = errors.New("cache: lock ttl must be at least a millisecond")
+)
+
+const (
+	defaultLockRetryDelay = 100 * time.Millisecond
+	// lockClockDriftFactor is the clock drift allowed between redlock nodes
+	lockClockDriftFactor = 0.01
+)
+
+var (
+	// acquireLockScript sets the lock and returns the next fencing token, or 1
+	// when ARGV[3] does not ask for one, 0 when the lock is already held
+	acquireLockScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	if ARGV[3] == "1" then
+		return redis.call("INCR", KEYS[2])
+	end
+	return 1
+end
+return 0`)
+
+	// releaseLockScript deletes the lock only if it is still owned by the caller
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	// extendLockScript resets the lock TTL only if it is still owned by the caller
+	extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+type (
+	// Locker represents a distributed lock manager
+	Locker interface {
+		// Lock waits until the lock is obtained or ctx is done
+		Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+		// TryLock returns ErrLockNotObtained immediately if the lock is held
+		TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+		// Unlock releases the lock if it is still owned by the caller
+		Unlock(ctx context.Context, lock *Lock) error
+		// Extend resets the TTL of the lock if it is still owned by the caller
+		Extend(ctx context.Context, lock *Lock, ttl time.Duration) error
+	}
+
+	// LockerOption represents option of the locker
+	LockerOption func(*redisLocker)
+
+	// Lock represents an obtained lock
+	Lock struct {
+		key   string
+		value string
+		token int64
+
+		mu       sync.Mutex
+		ttl      time.Duration
+		stopOnce sync.Once
+		stop     chan struct{}
+		lost     chan struct{}
+		// extended tells the watchdog the TTL was reset by Extend
+		extended chan struct{}
+	}
+
+	// redlockHelper serves commands from its first node, the others only
+	// take part in the locks
+	redlockHelper struct {
+		*redisHelper
+		nodes []*redisHelper
+	}
+
+	redisLocker struct {
+		nodes      []CacheScripting
+		quorum     int
+		retryDelay time.Duration
+		watchdog   bool
+	}
+)
+
+// WithLockRetryDelay sets delay between attempts of Lock
+func WithLockRetryDelay(delay time.Duration) LockerOption {
+	return func(l *redisLocker) {
+		l.retryDelay = delay
+	}
+}
+
+// WithLockWatchdog renews the TTL of obtained locks every third of their TTL
+// until they are unlocked, Lock.Lost is closed if renewals keep failing until
+// the lock expires
+func WithLockWatchdog() LockerOption {
+	return func(l *redisLocker) {
+		l.watchdog = true
+	}
+}
+
+// NewLocker creates a locker over helpers returned by NewCacheHelper, passing
+// several independent helpers, or a helper created with WithRedlock, enables
+// the Redlock algorithm where a lock is obtained when a majority of them agree
+func NewLocker(helpers []CacheHelper, opts ...LockerOption) (Locker, error) {
+	var nodes []CacheScripting
+	for _, helper := range helpers {
+		if redlock, ok := helper.(*redlockHelper); ok {
+			for _, node := range redlock.nodes {
+				nodes = append(nodes, node)
+			}
+			continue
+		}
+		node, ok := helper.(CacheScripting)
+		if !ok {
+			return nil, errors.New("cache helper does not support scripting")
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("missing cache helper for locker")
+	}
+
+	locker := &redisLocker{
+		nodes:      nodes,
+		quorum:     len(nodes)/2 + 1,
+		retryDelay: defaultLockRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(locker)
+	}
+	return locker, nil
+}
+
+// NewRedlockLocker creates a locker running the Redlock algorithm over
+// independent redis nodes, one per address, configured with redisOpts
+func NewRedlockLocker(addrs []string, redisOpts []RedisOption, opts ...LockerOption) (Locker, error) {
+	helpers := make([]CacheHelper, len(addrs))
+	for index, addr := range addrs {
+		helper, err := NewRedisCacheHelper([]string{addr}, redisOpts...)
+		if err != nil {
+			return nil, err
+		}
+		helpers[index] = helper
+	}
+	return NewLocker(helpers, opts...)
+}
+
+func newRedlockHelper(addrs []string, options *redisOptions) (*redlockHelper, error) {
+	helper := &redlockHelper{}
+	for _, addr := range addrs {
+		client, err := initRedis(options.client(addr))
+		if err != nil {
+			_ = client.Close()
+			for _, node := range helper.nodes {
+				_ = node.client.Close()
+			}
+			return nil, err
+		}
+		nodeOptions := *options
+		if nodeOptions.metricsName != "" {
+			nodeOptions.metricsName += "@" + addr
+		}
+		instrument(client, &nodeOptions, addr)
+		helper.nodes = append(helper.nodes, newRedisHelper(client))
+	}
+	helper.redisHelper = helper.nodes[0]
+	return helper, nil
+}
+
+// Key returns key of the lock
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the fencing token of the lock, tokens of a key increase every
+// time the lock is obtained so resources can reject writes of older holders.
+// Redlock has no single counter to order its holders so its tokens are 0
+func (l *Lock) Token() int64 {
+	return l.token
+}
+
+// Lost is closed when the watchdog fails to renew the lock before it expires
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lock) getTTL() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ttl
+}
+
+func (l *Lock) stopWatchdog() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+func (r *redisLocker) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	for {
+		lock, err := r.TryLock(ctx, key, ttl)
+		if err != ErrLockNotObtained {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(r.retryDelay):
+		}
+	}
+}
+
+func (r *redisLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidLockTTL
+	}
+
+	value := make([]byte, 16)
+	if _, err := rand.Read(value); err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{
+		key:      key,
+		value:    hex.EncodeToString(value),
+		ttl:      ttl,
+		stop:     make(chan struct{}),
+		lost:     make(chan struct{}),
+		extended: make(chan struct{}, 1),
+	}
+
+	start := time.Now()
+	var (
+		acquired int
+		failed   int
+		lastErr  error
+	)
+	for _, node := range r.nodes {
+		token, err := r.acquire(ctx, node, lock)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		if token > 0 {
+			acquired++
+			if r.fencing() {
+				lock.token = token
+			}
+		}
+	}
+
+	drift := time.Duration(float64(ttl)*lockClockDriftFactor) + 2*time.Millisecond
+	if acquired < r.quorum || time.Since(start)+drift >= ttl {
+		r.release(ctx, lock)
+		// report the error only when it prevented reaching the quorum
+		if lastErr != nil && acquired+failed >= r.quorum {
+			return nil, lastErr
+		}
+		return nil, ErrLockNotObtained
+	}
+
+	if r.watchdog {
+		go r.renew(lock)
+	}
+	return lock, nil
+}
+
+func (r *redisLocker) Unlock(ctx context.Context, lock *Lock) error {
+	lock.stopWatchdog()
+
+	released := r.release(ctx, lock)
+	if released < r.quorum {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (r *redisLocker) Extend(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidLockTTL
+	}
+	if err := r.extend(ctx, lock, ttl); err != nil {
+		return err
+	}
+
+	select {
+	case lock.extended <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// extend resets the TTL of lock on the nodes still owned by the caller
+func (r *redisLocker) extend(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	var extended int
+	for _, node := range r.nodes {
+		result, err := node.EvalScript(ctx, extendLockScript, []string{lockKey(lock.key)}, lock.value, ttl.Milliseconds())
+		if err != nil {
+			continue
+		}
+		if count, ok := result.(int64); ok && count == 1 {
+			extended++
+		}
+	}
+	if extended < r.quorum {
+		return ErrLockNotHeld
+	}
+	lock.mu.Lock()
+	lock.ttl = ttl
+	lock.mu.Unlock()
+	return nil
+}
+
+// renew extends the lock every third of its current TTL until it is
+// unlocked, failed renewals are retried until the lock expires
+func (r *redisLocker) renew(lock *Lock) {
+	ttl := lock.getTTL()
+	expiry := time.Now().Add(ttl)
+	timer := time.NewTimer(ttl / 3)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-lock.extended:
+			ttl = lock.getTTL()
+			expiry = time.Now().Add(ttl)
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(ttl / 3)
+			continue
+		case <-timer.C:
+		}
+
+		ttl = lock.getTTL()
+		timeout := ttl / 3
+		if remaining := time.Until(expiry); remaining < timeout {
+			timeout = remaining
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := r.extend(ctx, lock, ttl)
+		cancel()
+		if err == nil {
+			expiry = start.Add(ttl)
+			timer.Reset(ttl / 3)
+			continue
+		}
+
+		remaining := time.Until(expiry)
+		if remaining <= 0 {
+			zap.S().Warnw("Failed to renew lock", "key", lock.key, "error", err)
+			close(lock.lost)
+			return
+		}
+		zap.S().Debugw("Retrying lock renewal", "key", lock.key, "remaining", remaining, "error", err)
+		retry := ttl / 10
+		if retry > remaining {
+			retry = remaining
+		}
+		timer.Reset(retry)
+	}
+}
+
+func (r *redisLocker) acquire(ctx context.Context, node CacheScripting, lock *Lock) (int64, error) {
+	keys := []string{lockKey(lock.key), fencingKey(lock.key)}
+	fence := "0"
+	if r.fencing() {
+		fence = "1"
+	}
+	result, err := node.EvalScript(ctx, acquireLockScript, keys, lock.value, lock.ttl.Milliseconds(), fence)
+	if err != nil {
+		return 0, err
+	}
+	token, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected lock script result %v", result)
+	}
+	return token, nil
+}
+
+// fencing reports whether tokens are given, only a single node has a counter
+// every holder goes through
+func (r *redisLocker) fencing() bool {
+	return len(r.nodes) == 1
+}
+
+// release deletes the lock on every node and returns how many nodes released it
+func (r *redisLocker) release(ctx context.Context, lock *Lock) int {
+	var released int
+	for _, node := range r.nodes {
+		result, err := node.EvalScript(ctx, releaseLockScript, []string{lockKey(lock.key)}, lock.value)
+		if err != nil {
+			continue
+		}
+		if count, ok := result.(int64); ok && count == 1 {
+			released++
+		}
+	}
+	return released
+}
+
+// lockKey uses a hash tag so the lock and its fencing counter share the same
+// cluster slot
+func lockKey(key string) string {
+	return fmt.Sprintf("lock:{%s}", key)
+}
+
+func fencingKey(key string) string {
+	return fmt.Sprintf("lock:{%s}:fencing", key)
+}
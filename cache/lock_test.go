@@ -0,0 +1,162 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestLocker validates a lock is exclusive and fencing tokens increase
+func TestLocker(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestHelper(t)
+
+	locker, err := cache.NewLocker([]cache.CacheHelper{helper})
+	if err != nil {
+		t.Fatalf("Should be able to create a locker. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to protect a resource with a lock")
+	{
+		first, err := locker.TryLock(ctx, "payment", time.Second)
+		if err != nil {
+			t.Fatalf("\tShould obtain the free lock. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould obtain the free lock. %v", checkMark)
+
+		if _, err := locker.TryLock(ctx, "payment", time.Second); err != cache.ErrLockNotObtained {
+			t.Errorf("\tShould not obtain a held lock. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould not obtain a held lock. %v", checkMark)
+		}
+
+		t.Log("\tWhen the lock expired and was obtained by another holder")
+		{
+			server.FastForward(2 * time.Second)
+			second, err := locker.TryLock(ctx, "payment", time.Second)
+			if err != nil {
+				t.Fatalf("\t\tShould obtain the expired lock. %v %v", ballotX, err)
+			}
+			if second.Token() <= first.Token() {
+				t.Errorf("\t\tShould get a greater fencing token. %v %d <= %d", ballotX, second.Token(), first.Token())
+			} else {
+				t.Logf("\t\tShould get a greater fencing token. %v", checkMark)
+			}
+
+			if err := locker.Unlock(ctx, first); err != cache.ErrLockNotHeld {
+				t.Errorf("\t\tShould not release the lock of another holder. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould not release the lock of another holder. %v", checkMark)
+			}
+			if err := locker.Unlock(ctx, second); err != nil {
+				t.Errorf("\t\tShould release its own lock. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould release its own lock. %v", checkMark)
+			}
+		}
+	}
+}
+
+// TestRedlock validates a lock is obtained from a majority of independent nodes
+func TestRedlock(t *testing.T) {
+	ctx := context.Background()
+	var (
+		servers []*miniredis.Miniredis
+		addrs   []string
+	)
+	for i := 0; i < 3; i++ {
+		server, _ := newTestHelper(t)
+		servers = append(servers, server)
+		addrs = append(addrs, server.Addr())
+	}
+
+	helper := cache.NewCacheHelper(addrs, cache.CacheOption{Key: "redlock", Value: true})
+	locker, err := cache.NewLocker([]cache.CacheHelper{helper})
+	if err != nil {
+		t.Fatalf("Should be able to create a locker. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to lock across independent redis nodes")
+	{
+		if _, err := locker.TryLock(ctx, "payment", 0); err != cache.ErrInvalidLockTTL {
+			t.Errorf("\tShould refuse a lock without TTL. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould refuse a lock without TTL. %v", checkMark)
+		}
+
+		servers[0].Close()
+		lock, err := locker.TryLock(ctx, "payment", time.Second)
+		if err != nil {
+			t.Fatalf("\tShould obtain the lock from a majority of the nodes. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould obtain the lock from a majority of the nodes. %v", checkMark)
+
+		if servers[1].Exists("lock:{payment}") && servers[2].Exists("lock:{payment}") && lock.Token() == 0 {
+			t.Logf("\tShould hold the lock on the nodes without fencing token. %v", checkMark)
+		} else {
+			t.Errorf("\tShould hold the lock on the nodes without fencing token. %v %v", ballotX, lock.Token())
+		}
+
+		servers[1].Del("lock:{payment}")
+		if err := locker.Unlock(ctx, lock); err != cache.ErrLockNotHeld {
+			t.Errorf("\tShould report a lock held by a minority of the nodes. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould report a lock held by a minority of the nodes. %v", checkMark)
+		}
+	}
+}
+
+// TestLockWatchdog validates the watchdog follows the TTL set by Extend and
+// only reports the lock lost once renewals failed until it expired
+func TestLockWatchdog(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestHelper(t)
+
+	locker, err := cache.NewLocker([]cache.CacheHelper{helper}, cache.WithLockWatchdog())
+	if err != nil {
+		t.Fatalf("Should be able to create a locker. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to keep a lock while its holder runs")
+	{
+		lock, err := locker.TryLock(ctx, "payment", 3*time.Second)
+		if err != nil {
+			t.Fatalf("\tShould obtain the free lock. %v %v", ballotX, err)
+		}
+		defer locker.Unlock(ctx, lock)
+
+		if err := locker.Extend(ctx, lock, 300*time.Millisecond); err != nil {
+			t.Fatalf("\tShould shorten the lock. %v %v", ballotX, err)
+		}
+		server.FastForward(250 * time.Millisecond)
+		time.Sleep(150 * time.Millisecond)
+		if ttl := server.TTL("lock:{payment}"); ttl <= 50*time.Millisecond {
+			t.Errorf("\tShould renew the lock every third of its new TTL. %v %v", ballotX, ttl)
+		} else {
+			t.Logf("\tShould renew the lock every third of its new TTL. %v", checkMark)
+		}
+
+		server.SetError("ERR down")
+		time.Sleep(150 * time.Millisecond)
+		server.SetError("")
+		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-lock.Lost():
+			t.Errorf("\tShould keep the lock through a transient failure. %v", ballotX)
+		default:
+			t.Logf("\tShould keep the lock through a transient failure. %v", checkMark)
+		}
+
+		server.SetError("ERR down")
+		defer server.SetError("")
+		select {
+		case <-lock.Lost():
+			t.Logf("\tShould report the lock lost once it expired. %v", checkMark)
+		case <-time.After(time.Second):
+			t.Errorf("\tShould report the lock lost once it expired. %v", ballotX)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// localCache is a bounded in-memory LRU cache with per entry expiration
+	localCache struct {
+		mu      sync.Mutex
+		size    int
+		items   map[string]*list.Element
+		order   *list.List
+		nowFunc func() time.Time
+		maxTTL  time.Duration
+	}
+
+	localCacheEntry struct {
+		key       string
+		data      []byte
+		expiredAt time.Time
+	}
+)
+
+func newLocalCache(size int, maxTTL time.Duration) *localCache {
+	return &localCache{
+		size:    size,
+		items:   make(map[string]*list.Element, size),
+		order:   list.New(),
+		nowFunc: time.Now,
+		maxTTL:  maxTTL,
+	}
+}
+
+func (c *localCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*localCacheEntry)
+	if !entry.expiredAt.IsZero() && c.nowFunc().After(entry.expiredAt) {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.data, true
+}
+
+// set stores data of key, expiration is capped by the max TTL of the cache
+func (c *localCache) set(key string, data []byte, expiration time.Duration) {
+	if c.size <= 0 {
+		return
+	}
+	if c.maxTTL > 0 && (expiration <= 0 || expiration > c.maxTTL) {
+		expiration = c.maxTTL
+	}
+	var expiredAt time.Time
+	if expiration > 0 {
+		expiredAt = c.nowFunc().Add(expiration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*localCacheEntry)
+		entry.data = data
+		entry.expiredAt = expiredAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&localCacheEntry{
+		key:       key,
+		data:      data,
+		expiredAt: expiredAt,
+	})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *localCache) del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.removeElement(element)
+		}
+	}
+}
+
+func (c *localCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element, c.size)
+	c.order.Init()
+}
+
+func (c *localCache) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*localCacheEntry)
+	delete(c.items, entry.key)
+}
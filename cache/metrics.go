@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"lib/log"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	metricsNamespace = "cache"
+	metricsSubsystem = "redis"
+)
+
+type (
+	// instrumentedClient is implemented by both single node and cluster clients
+	instrumentedClient interface {
+		WrapProcess(fn func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error)
+		WrapProcessPipeline(fn func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error)
+		PoolStats() *redis.PoolStats
+	}
+
+	cacheMetrics struct {
+		hits     prometheus.Counter
+		misses   prometheus.Counter
+		errors   *prometheus.CounterVec
+		duration *prometheus.HistogramVec
+
+		slowThreshold time.Duration
+	}
+
+	// poolCollector reads the pool stats of a client on every scrape
+	poolCollector struct {
+		stats func() *redis.PoolStats
+
+		idleConns  *prometheus.Desc
+		totalConns *prometheus.Desc
+		staleConns *prometheus.Desc
+		hits       *prometheus.Desc
+		misses     *prometheus.Desc
+		timeouts   *prometheus.Desc
+	}
+)
+
+// instrument records metrics of every command processed by client and logs
+// the ones slower than the slow log threshold
+func instrument(client instrumentedClient, options *redisOptions, defaultName string) {
+	if options.disableMetrics {
+		return
+	}
+
+	name := options.metricsName
+	if name == "" {
+		name = defaultName
+	}
+	registerer := options.registerer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	metrics := newCacheMetrics(registerer, name)
+	metrics.slowThreshold = options.slowThreshold
+	if err := registerer.Register(newPoolCollector(name, client.PoolStats)); err != nil {
+		zap.S().Warnw("Failed to register cache pool metrics", "name", name, "error", err)
+	}
+
+	client.WrapProcess(func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := oldProcess(cmd)
+			metrics.observe(cmd.Name(), time.Since(start), cmd)
+			return err
+		}
+	})
+	client.WrapProcessPipeline(func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			start := time.Now()
+			err := oldProcess(cmds)
+			metrics.observe("pipeline", time.Since(start), cmds...)
+			return err
+		}
+	})
+}
+
+func newCacheMetrics(registerer prometheus.Registerer, name string) *cacheMetrics {
+	labels := prometheus.Labels{"name": name}
+	return &cacheMetrics{
+		hits: registerCollector(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "hits_total",
+			Help:        "Number of keys read from cache that existed.",
+			ConstLabels: labels,
+		})),
+		misses: registerCollector(registerer, prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "misses_total",
+			Help:        "Number of keys read from cache that did not exist.",
+			ConstLabels: labels,
+		})),
+		errors: registerCollector(registerer, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "errors_total",
+			Help:        "Number of failed cache commands.",
+			ConstLabels: labels,
+		}, []string{"command"})),
+		duration: registerCollector(registerer, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Subsystem:   metricsSubsystem,
+			Name:        "command_duration_seconds",
+			Help:        "Latency of cache commands, pipelines are observed as a whole.",
+			ConstLabels: labels,
+			Buckets:     []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"command"})),
+	}
+}
+
+// registerCollector reuses the collector registered by a previous helper with
+// the same name
+func registerCollector[T prometheus.Collector](registerer prometheus.Registerer, collector T) T {
+	if err := registerer.Register(collector); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			if existing, ok := registered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		zap.S().Warnw("Failed to register cache metrics", "error", err)
+	}
+	return collector
+}
+
+func (m *cacheMetrics) observe(command string, elapsed time.Duration, cmds ...redis.Cmder) {
+	m.duration.WithLabelValues(command).Observe(elapsed.Seconds())
+
+	for _, cmd := range cmds {
+		err := cmd.Err()
+		switch cmd.Name() {
+		case "get", "hget":
+			if err == nil {
+				m.hits.Inc()
+			} else if err == redis.Nil {
+				m.misses.Inc()
+			}
+		case "mget", "hmget":
+			if sliceCmd, ok := cmd.(*redis.SliceCmd); ok && err == nil {
+				for _, value := range sliceCmd.Val() {
+					if value == nil {
+						m.misses.Inc()
+					} else {
+						m.hits.Inc()
+					}
+				}
+			}
+		}
+		if err != nil && err != redis.Nil {
+			m.errors.WithLabelValues(cmd.Name()).Inc()
+		}
+	}
+
+	if m.slowThreshold > 0 && elapsed >= m.slowThreshold {
+		logger().Warnw("Slow cache command", "command", command, "key", firstKey(cmds), "commands", len(cmds), "duration", elapsed)
+	}
+}
+
+// firstKey returns the key of the first command, values are never logged
+func firstKey(cmds []redis.Cmder) string {
+	if len(cmds) == 0 {
+		return ""
+	}
+	args := cmds[0].Args()
+	if len(args) < 2 {
+		return ""
+	}
+	return fmt.Sprint(args[1])
+}
+
+// logger returns the application logger once log.InitZap has been called
+func logger() *zap.SugaredLogger {
+	if log.Logger.SugaredLogger != nil {
+		return log.Logger.SugaredLogger
+	}
+	return zap.S()
+}
+
+func newPoolCollector(name string, stats func() *redis.PoolStats) *poolCollector {
+	labels := prometheus.Labels{"name": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, metricsSubsystem, metric), help, nil, labels)
+	}
+	return &poolCollector{
+		stats:      stats,
+		idleConns:  desc("pool_idle_connections", "Number of idle connections in the pool."),
+		totalConns: desc("pool_total_connections", "Number of connections in the pool."),
+		staleConns: desc("pool_stale_connections_total", "Number of stale connections removed from the pool."),
+		hits:       desc("pool_hits_total", "Number of times a free connection was found in the pool."),
+		misses:     desc("pool_misses_total", "Number of times a free connection was not found in the pool."),
+		timeouts:   desc("pool_timeouts_total", "Number of times waiting for a connection timed out."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.staleConns
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.timeouts
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(c.staleConns, prometheus.CounterValue, float64(stats.StaleConns))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+}
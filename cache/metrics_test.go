@@ -0,0 +1,59 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestCacheMetrics validates hits and misses are counted per helper
+func TestCacheMetrics(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Should be able to start miniredis. %v %v", ballotX, err)
+	}
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	helper, err := cache.NewRedisCacheHelper([]string{server.Addr()},
+		cache.WithMetricsName("test"), cache.WithMetricsRegisterer(registry))
+	if err != nil {
+		t.Fatalf("Should be able to create the helper. %v %v", ballotX, err)
+	}
+
+	t.Log("Given the need to monitor the cache")
+	{
+		var value string
+		_ = helper.Set(ctx, "key", "value", time.Minute)
+		_ = helper.Get(ctx, "key", &value)
+		_ = helper.Get(ctx, "missing", &value)
+
+		expected := `
+# HELP cache_redis_hits_total Number of keys read from cache that existed.
+# TYPE cache_redis_hits_total counter
+cache_redis_hits_total{name="test"} 1
+# HELP cache_redis_misses_total Number of keys read from cache that did not exist.
+# TYPE cache_redis_misses_total counter
+cache_redis_misses_total{name="test"} 1
+`
+		err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "cache_redis_hits_total", "cache_redis_misses_total")
+		if err != nil {
+			t.Errorf("\tShould count hits and misses. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould count hits and misses. %v", checkMark)
+		}
+
+		if count := testutil.CollectAndCount(registry, "cache_redis_pool_total_connections"); count != 1 {
+			t.Errorf("\tShould expose pool stats. %v %d", ballotX, count)
+		} else {
+			t.Logf("\tShould expose pool stats. %v", checkMark)
+		}
+	}
+}
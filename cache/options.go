@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// RedisOption represents option of the redis connection
+	RedisOption func(*redisOptions)
+
+	redisOptions struct {
+		db         int
+		username   string
+		password   string
+		masterName string
+		redlock    bool
+		tlsConfig  *tls.Config
+
+		poolSize     int
+		minIdleConns int
+		poolTimeout  time.Duration
+		idleTimeout  time.Duration
+
+		dialTimeout  time.Duration
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+
+		maxRetries      int
+		minRetryBackoff time.Duration
+		maxRetryBackoff time.Duration
+
+		metricsName    string
+		registerer     prometheus.Registerer
+		disableMetrics bool
+		slowThreshold  time.Duration
+
+		err error
+	}
+)
+
+// WithDB selects the database of a single node or sentinel connection
+func WithDB(db int) RedisOption {
+	return func(o *redisOptions) {
+		o.db = db
+	}
+}
+
+// WithAuth authenticates connections, username is only required with redis
+// ACLs and may be empty
+func WithAuth(username, password string) RedisOption {
+	return func(o *redisOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithTLSConfig enables TLS with config
+func WithTLSConfig(config *tls.Config) RedisOption {
+	return func(o *redisOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSRootCA enables TLS trusting the PEM encoded certificates of caPEM
+func WithTLSRootCA(caPEM []byte) RedisOption {
+	return func(o *redisOptions) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			o.err = errors.New("failed to parse redis CA certificate")
+			return
+		}
+		if o.tlsConfig == nil {
+			o.tlsConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		o.tlsConfig.RootCAs = pool
+	}
+}
+
+// WithPool sets the maximum number of connections per node, the minimum
+// number of idle connections and how long to wait for a free connection
+func WithPool(size, minIdleConns int, timeout time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.poolSize = size
+		o.minIdleConns = minIdleConns
+		o.poolTimeout = timeout
+	}
+}
+
+// WithIdleTimeout closes connections idle for longer than timeout
+func WithIdleTimeout(timeout time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithTimeouts sets dial, read and write timeouts
+func WithTimeouts(dial, read, write time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.dialTimeout = dial
+		o.readTimeout = read
+		o.writeTimeout = write
+	}
+}
+
+// WithRetry retries failed commands up to maxRetries times with a backoff
+// between minBackoff and maxBackoff
+func WithRetry(maxRetries int, minBackoff, maxBackoff time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.maxRetries = maxRetries
+		o.minRetryBackoff = minBackoff
+		o.maxRetryBackoff = maxBackoff
+	}
+}
+
+// WithSentinel connects to the master masterName through a failover client,
+// the addresses given to the constructor are the ones of the sentinels
+func WithSentinel(masterName string) RedisOption {
+	return func(o *redisOptions) {
+		o.masterName = masterName
+	}
+}
+
+// WithRedlock connects to each address as an independent node instead of a
+// cluster, the helper serves commands from the first node and NewLocker
+// obtains its locks from a majority of the nodes
+func WithRedlock() RedisOption {
+	return func(o *redisOptions) {
+		o.redlock = true
+	}
+}
+
+// WithMetricsName sets the name label of the cache metrics, the addresses
+// are used by default
+func WithMetricsName(name string) RedisOption {
+	return func(o *redisOptions) {
+		o.metricsName = name
+	}
+}
+
+// WithMetricsRegisterer registers the cache metrics with registerer instead
+// of the default prometheus registerer
+func WithMetricsRegisterer(registerer prometheus.Registerer) RedisOption {
+	return func(o *redisOptions) {
+		o.registerer = registerer
+	}
+}
+
+// WithoutMetrics disables the cache metrics
+func WithoutMetrics() RedisOption {
+	return func(o *redisOptions) {
+		o.disableMetrics = true
+	}
+}
+
+// WithSlowLog logs commands and pipelines taking longer than threshold
+func WithSlowLog(threshold time.Duration) RedisOption {
+	return func(o *redisOptions) {
+		o.slowThreshold = threshold
+	}
+}
+
+// onConnect authenticates with a username since go-redis only sends AUTH
+// with a password, the database is then selected here as go-redis selects it
+// before calling OnConnect
+func (o *redisOptions) onConnect() func(*redis.Conn) error {
+	if o.username == "" {
+		return nil
+	}
+	return func(conn *redis.Conn) error {
+		if err := conn.Process(redis.NewStatusCmd("auth", o.username, o.password)); err != nil {
+			return err
+		}
+		if o.db > 0 {
+			return conn.Select(o.db).Err()
+		}
+		return nil
+	}
+}
+
+// connectionDB returns the database go-redis has to select by itself
+func (o *redisOptions) connectionDB() int {
+	if o.username != "" {
+		return 0
+	}
+	return o.db
+}
+
+// connectionPassword returns the password go-redis has to send by itself
+func (o *redisOptions) connectionPassword() string {
+	if o.username != "" {
+		return ""
+	}
+	return o.password
+}
+
+func (o *redisOptions) client(addr string) *redis.Options {
+	return &redis.Options{
+		Addr:            addr,
+		DB:              o.connectionDB(),
+		OnConnect:       o.onConnect(),
+		Password:        o.connectionPassword(),
+		MaxRetries:      o.maxRetries,
+		MinRetryBackoff: o.minRetryBackoff,
+		MaxRetryBackoff: o.maxRetryBackoff,
+		DialTimeout:     o.dialTimeout,
+		ReadTimeout:     o.readTimeout,
+		WriteTimeout:    o.writeTimeout,
+		PoolSize:        o.poolSize,
+		MinIdleConns:    o.minIdleConns,
+		PoolTimeout:     o.poolTimeout,
+		IdleTimeout:     o.idleTimeout,
+		TLSConfig:       o.tlsConfig,
+	}
+}
+
+func (o *redisOptions) failover(sentinelAddrs []string) *redis.FailoverOptions {
+	return &redis.FailoverOptions{
+		MasterName:      o.masterName,
+		SentinelAddrs:   sentinelAddrs,
+		DB:              o.connectionDB(),
+		OnConnect:       o.onConnect(),
+		Password:        o.connectionPassword(),
+		MaxRetries:      o.maxRetries,
+		MinRetryBackoff: o.minRetryBackoff,
+		MaxRetryBackoff: o.maxRetryBackoff,
+		DialTimeout:     o.dialTimeout,
+		ReadTimeout:     o.readTimeout,
+		WriteTimeout:    o.writeTimeout,
+		PoolSize:        o.poolSize,
+		MinIdleConns:    o.minIdleConns,
+		PoolTimeout:     o.poolTimeout,
+		IdleTimeout:     o.idleTimeout,
+		TLSConfig:       o.tlsConfig,
+	}
+}
+
+func (o *redisOptions) cluster(addrs []string) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Addrs:           addrs,
+		OnConnect:       o.onConnect(),
+		Password:        o.connectionPassword(),
+		MaxRetries:      o.maxRetries,
+		MinRetryBackoff: o.minRetryBackoff,
+		MaxRetryBackoff: o.maxRetryBackoff,
+		DialTimeout:     o.dialTimeout,
+		ReadTimeout:     o.readTimeout,
+		WriteTimeout:    o.writeTimeout,
+		PoolSize:        o.poolSize,
+		MinIdleConns:    o.minIdleConns,
+		PoolTimeout:     o.poolTimeout,
+		IdleTimeout:     o.idleTimeout,
+		TLSConfig:       o.tlsConfig,
+	}
+}
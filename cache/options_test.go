@@ -0,0 +1,39 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestRedisOptionsAuth validates an ACL user can select another database
+func TestRedisOptionsAuth(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Should be able to start miniredis. %v %v", ballotX, err)
+	}
+	defer server.Close()
+	server.RequireUserAuth("app", "secret")
+
+	t.Log("Given the need to connect with an ACL user to the database 2")
+	{
+		helper, err := cache.NewRedisCacheHelper([]string{server.Addr()}, cache.WithAuth("app", "secret"), cache.WithDB(2), cache.WithoutMetrics())
+		if err != nil {
+			t.Fatalf("\tShould authenticate before selecting the database. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould authenticate before selecting the database. %v", checkMark)
+
+		if err := helper.Set(ctx, "config", "v1", time.Minute); err != nil {
+			t.Fatalf("\tShould be able to set the value. %v %v", ballotX, err)
+		}
+		if !server.DB(2).Exists("config") || server.DB(0).Exists("config") {
+			t.Errorf("\tShould write to the database 2. %v", ballotX)
+		} else {
+			t.Logf("\tShould write to the database 2. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrTxFailed is returned by a watch transaction when a watched key was
+// modified before the transaction was executed
+var ErrTxFailed error = redis.TxFailedErr
+
+type (
+	// Future represents the result of a queued command, it is only available
+	// after the pipeline or transaction has been executed
+	Future[T any] struct {
+		cmd   redis.Cmder
+		value func() T
+	}
+
+	// StringFuture represents the result of a queued command returning a string
+	StringFuture struct {
+		*Future[string]
+	}
+
+	// CacheCommandBuilder queues type-safe commands in a pipeline or a transaction
+	CacheCommandBuilder interface {
+		Get(key string) *StringFuture
+		Set(key string, value interface{}, expiration time.Duration) *Future[string]
+		SetNX(key string, value interface{}, expiration time.Duration) *Future[bool]
+		Del(keys ...string) *Future[int64]
+		Expire(key string, expiration time.Duration) *Future[bool]
+		Incr(key string) *Future[int64]
+		IncrBy(key string, value int64) *Future[int64]
+		HSet(key, field string, value interface{}) *Future[bool]
+		HGet(key, field string) *StringFuture
+		HGetAll(key string) *Future[map[string]string]
+		HDel(key string, fields ...string) *Future[int64]
+		SAdd(key string, members ...interface{}) *Future[int64]
+		SMembers(key string) *Future[[]string]
+		ZAdd(key string, members ...redis.Z) *Future[int64]
+		ZRangeWithScores(key string, start, stop int64) *Future[[]redis.Z]
+		ZRangeByScoreWithScores(key string, min, max string) *Future[[]redis.Z]
+		ZRemRangeByScore(key string, min, max string) *Future[int64]
+	}
+
+	// CacheWatchTransaction represents an optimistic transaction, keys read
+	// through it are watched and commands queued by Pipelined are only
+	// executed if none of the watched keys changed in between
+	CacheWatchTransaction interface {
+		Get(ctx context.Context, key string, value interface{}) error
+		Pipelined(ctx context.Context, fn func(CacheCommandBuilder) error) error
+	}
+
+	redisWatchTransaction struct {
+		tx *redis.Tx
+	}
+)
+
+func newFuture[T any](cmd redis.Cmder, value func() T) *Future[T] {
+	return &Future[T]{
+		cmd:   cmd,
+		value: value,
+	}
+}
+
+// Result returns value and error of the command
+func (f *Future[T]) Result() (T, error) {
+	return f.value(), f.cmd.Err()
+}
+
+// Val returns value of the command
+func (f *Future[T]) Val() T {
+	return f.value()
+}
+
+// Err returns error of the command, redis.Nil when key does not exist
+func (f *Future[T]) Err() error {
+	return f.cmd.Err()
+}
+
+// Unmarshal decodes a JSON value stored by CacheHelper.Set or Set
+func (f *StringFuture) Unmarshal(value interface{}) error {
+	data, err := f.Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), value)
+}
+
+func (r *baseRedisCachePipeline) Get(key string) *StringFuture {
+	cmd := r.Pipeliner.Get(key)
+	return &StringFuture{newFuture(cmd, cmd.Val)}
+}
+
+// Set stores value encoded as JSON, the same way CacheHelper.Set does
+func (r *baseRedisCachePipeline) Set(key string, value interface{}, expiration time.Duration) *Future[string] {
+	data, err := json.Marshal(value)
+	if err != nil {
+		cmd := redis.NewStatusResult("", err)
+		return newFuture(cmd, cmd.Val)
+	}
+	cmd := r.Pipeliner.Set(key, string(data), expiration)
+	return newFuture(cmd, cmd.Val)
+}
+
+// SetNX stores value encoded as JSON only if key does not exist
+func (r *baseRedisCachePipeline) SetNX(key string, value interface{}, expiration time.Duration) *Future[bool] {
+	data, err := json.Marshal(value)
+	if err != nil {
+		cmd := redis.NewBoolResult(false, err)
+		return newFuture(cmd, cmd.Val)
+	}
+	cmd := r.Pipeliner.SetNX(key, string(data), expiration)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) Del(keys ...string) *Future[int64] {
+	cmd := r.Pipeliner.Del(keys...)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) Expire(key string, expiration time.Duration) *Future[bool] {
+	cmd := r.Pipeliner.Expire(key, expiration)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) Incr(key string) *Future[int64] {
+	cmd := r.Pipeliner.Incr(key)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) IncrBy(key string, value int64) *Future[int64] {
+	cmd := r.Pipeliner.IncrBy(key, value)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) HSet(key, field string, value interface{}) *Future[bool] {
+	cmd := r.Pipeliner.HSet(key, field, value)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) HGet(key, field string) *StringFuture {
+	cmd := r.Pipeliner.HGet(key, field)
+	return &StringFuture{newFuture(cmd, cmd.Val)}
+}
+
+func (r *baseRedisCachePipeline) HGetAll(key string) *Future[map[string]string] {
+	cmd := r.Pipeliner.HGetAll(key)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) HDel(key string, fields ...string) *Future[int64] {
+	cmd := r.Pipeliner.HDel(key, fields...)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) SAdd(key string, members ...interface{}) *Future[int64] {
+	cmd := r.Pipeliner.SAdd(key, members...)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) SMembers(key string) *Future[[]string] {
+	cmd := r.Pipeliner.SMembers(key)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) ZAdd(key string, members ...redis.Z) *Future[int64] {
+	cmd := r.Pipeliner.ZAdd(key, members...)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) ZRangeWithScores(key string, start, stop int64) *Future[[]redis.Z] {
+	cmd := r.Pipeliner.ZRangeWithScores(key, start, stop)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) ZRangeByScoreWithScores(key string, min, max string) *Future[[]redis.Z] {
+	cmd := r.Pipeliner.ZRangeByScoreWithScores(key, redis.ZRangeBy{
+		Min: min,
+		Max: max,
+	})
+	return newFuture(cmd, cmd.Val)
+}
+
+func (r *baseRedisCachePipeline) ZRemRangeByScore(key string, min, max string) *Future[int64] {
+	cmd := r.Pipeliner.ZRemRangeByScore(key, min, max)
+	return newFuture(cmd, cmd.Val)
+}
+
+func (t *redisWatchTransaction) Get(ctx context.Context, key string, value interface{}) error {
+	data, err := t.tx.Get(key).Bytes()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (t *redisWatchTransaction) Pipelined(ctx context.Context, fn func(CacheCommandBuilder) error) error {
+	_, err := t.tx.Pipelined(func(pipe redis.Pipeliner) error {
+		return fn(&baseRedisCachePipeline{
+			Pipeliner: pipe,
+		})
+	})
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
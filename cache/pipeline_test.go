@@ -0,0 +1,80 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+)
+
+// TestPipelineFutures validates typed commands return their results after Exec
+func TestPipelineFutures(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+	enhancement := helper.(cache.CacheHelperEnhancement)
+
+	t.Log("Given the need to run several commands in one round trip")
+	{
+		pipeline := enhancement.GetPipeline(ctx, "pipeline")
+		set := pipeline.Set("user", codecUser{Name: "Bill"}, time.Minute)
+		incr := pipeline.Incr("counter")
+		get := pipeline.Get("user")
+		missing := pipeline.Get("missing")
+
+		exec, err := pipeline.GetCommands(ctx)
+		if err != nil {
+			t.Fatalf("\tShould get the commands. %v %v", ballotX, err)
+		}
+		if _, err := exec.Exec(ctx); err != nil {
+			t.Fatalf("\tShould execute the pipeline. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould execute the pipeline. %v", checkMark)
+
+		var user codecUser
+		if set.Err() != nil || incr.Val() != 1 || get.Unmarshal(&user) != nil || user.Name != "Bill" {
+			t.Errorf("\tShould resolve every future. %v %v %d %+v", ballotX, set.Err(), incr.Val(), user)
+		} else {
+			t.Logf("\tShould resolve every future. %v", checkMark)
+		}
+		if missing.Err() != cache.ErrCacheMiss {
+			t.Errorf("\tShould report a missing key on its own future. %v %v", ballotX, missing.Err())
+		} else {
+			t.Logf("\tShould report a missing key on its own future. %v", checkMark)
+		}
+	}
+}
+
+// TestWatchTransaction validates a transaction fails when a watched key changes
+func TestWatchTransaction(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+	enhancement := helper.(cache.CacheHelperEnhancement)
+
+	t.Log("Given the need to update a key only if it did not change")
+	{
+		if err := helper.Set(ctx, "balance", 10, 0); err != nil {
+			t.Fatalf("\tShould be able to set the value. %v %v", ballotX, err)
+		}
+
+		err := enhancement.Watch(ctx, func(tx cache.CacheWatchTransaction) error {
+			var balance int
+			if err := tx.Get(ctx, "balance", &balance); err != nil {
+				return err
+			}
+			// concurrent writer
+			if err := helper.Set(ctx, "balance", 20, 0); err != nil {
+				return err
+			}
+			return tx.Pipelined(ctx, func(pipe cache.CacheCommandBuilder) error {
+				pipe.Set("balance", balance+5, 0)
+				return nil
+			})
+		}, "balance")
+
+		if err != cache.ErrTxFailed {
+			t.Errorf("\tShould return ErrTxFailed. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould return ErrTxFailed. %v", checkMark)
+		}
+	}
+}
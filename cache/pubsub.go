@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	subscriptionReceiveInterval = time.Second
+	subscriptionRetryDelay      = time.Second
+)
+
+type (
+	// CachePubSub represents redis pub/sub operations
+	CachePubSub interface {
+		// Publish sends value to channel, []byte and string values are sent as
+		// is and other values are encoded as JSON
+		Publish(ctx context.Context, channel string, value interface{}) error
+		// Subscribe calls fn for every message of channels until ctx is done
+		Subscribe(ctx context.Context, channels []string, fn SubscribeFunc, opts ...SubscribeOption) error
+		// PSubscribe calls fn for every message of channels matching patterns
+		// until ctx is done
+		PSubscribe(ctx context.Context, patterns []string, fn SubscribeFunc, opts ...SubscribeOption) error
+		// SubscribeExpiredKeys calls fn with every key expiring in db until ctx
+		// is done, the server must have notify-keyspace-events including "Ex"
+		SubscribeExpiredKeys(ctx context.Context, db int, fn func(key string) error, opts ...SubscribeOption) error
+	}
+
+	// SubscribeOption represents option of a subscription
+	SubscribeOption func(*subscribeOptions)
+
+	subscribeOptions struct {
+		concurrency   int
+		onResubscribe func()
+	}
+
+	// pubSubCacheHelper is implemented by the redis helpers
+	pubSubCacheHelper interface {
+		publish(ctx context.Context, channel string, data []byte) error
+		subscribe(channels ...string) *redis.PubSub
+	}
+)
+
+// WithSubscribeConcurrency handles up to concurrency messages at the same
+// time, messages are handled one by one in order by default
+func WithSubscribeConcurrency(concurrency int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WithResubscribeHook calls fn every time the subscription is re-established
+// after a connection loss, messages published in between are lost
+func WithResubscribeHook(fn func()) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onResubscribe = fn
+	}
+}
+
+func encodePubSubValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return json.Marshal(value)
+}
+
+func expiredKeysChannel(db int) string {
+	return fmt.Sprintf("__keyevent@%d__:expired", db)
+}
+
+func expiredKeysHandler(fn func(key string) error) SubscribeFunc {
+	return func(msg CacheMessage) error {
+		return fn(msg.Payload)
+	}
+}
+
+// runSubscription waits for the first subscription confirmation then
+// dispatches messages to fn in background until ctx is done. go-redis
+// reconnects and subscribes again by itself when the connection is lost
+func runSubscription(ctx context.Context, pubsub *redis.PubSub, subscriptions int, fn SubscribeFunc, opts ...SubscribeOption) error {
+	options := subscribeOptions{
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Close()
+	}()
+
+	go func() {
+		var (
+			wg        sync.WaitGroup
+			semaphore = make(chan struct{}, options.concurrency)
+			confirmed = 1
+		)
+		defer wg.Wait()
+
+		handle := func(msg *redis.Message) {
+			if err := fn(CacheMessage{Message: *msg}); err != nil {
+				zap.S().Warnw("Failed to handle cache message", "channel", msg.Channel, "error", err)
+			}
+		}
+
+		for {
+			msg, err := pubsub.ReceiveTimeout(subscriptionReceiveInterval)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+					continue
+				}
+				zap.S().Warnw("Failed to receive cache message", "error", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(subscriptionRetryDelay):
+				}
+				continue
+			}
+
+			switch v := msg.(type) {
+			case *redis.Subscription:
+				confirmed++
+				if confirmed > subscriptions && options.onResubscribe != nil {
+					options.onResubscribe()
+				}
+			case *redis.Message:
+				if options.concurrency <= 1 {
+					handle(v)
+					continue
+				}
+				semaphore <- struct{}{}
+				wg.Add(1)
+				go func() {
+					defer func() {
+						<-semaphore
+						wg.Done()
+					}()
+					handle(v)
+				}()
+			}
+		}
+	}()
+	return nil
+}
+
+func (h *redisHelper) Publish(ctx context.Context, channel string, value interface{}) error {
+	data, err := encodePubSubValue(value)
+	if err != nil {
+		return err
+	}
+	return h.publish(ctx, channel, data)
+}
+
+func (h *redisHelper) Subscribe(ctx context.Context, channels []string, fn SubscribeFunc, opts ...SubscribeOption) error {
+	return runSubscription(ctx, h.client.Subscribe(channels...), len(channels), fn, opts...)
+}
+
+func (h *redisHelper) PSubscribe(ctx context.Context, patterns []string, fn SubscribeFunc, opts ...SubscribeOption) error {
+	return runSubscription(ctx, h.client.PSubscribe(patterns...), len(patterns), fn, opts...)
+}
+
+func (h *redisHelper) SubscribeExpiredKeys(ctx context.Context, db int, fn func(key string) error, opts ...SubscribeOption) error {
+	return h.Subscribe(ctx, []string{expiredKeysChannel(db)}, expiredKeysHandler(fn), opts...)
+}
+
+func (h *clusterRedisHelper) Publish(ctx context.Context, channel string, value interface{}) error {
+	data, err := encodePubSubValue(value)
+	if err != nil {
+		return err
+	}
+	return h.publish(ctx, channel, data)
+}
+
+func (h *clusterRedisHelper) Subscribe(ctx context.Context, channels []string, fn SubscribeFunc, opts ...SubscribeOption) error {
+	return runSubscription(ctx, h.clusterClient.Subscribe(channels...), len(channels), fn, opts...)
+}
+
+func (h *clusterRedisHelper) PSubscribe(ctx context.Context, patterns []string, fn SubscribeFunc, opts ...SubscribeOption) error {
+	return runSubscription(ctx, h.clusterClient.PSubscribe(patterns...), len(patterns), fn, opts...)
+}
+
+// SubscribeExpiredKeys subscribes on every master since keyspace notifications
+// are only sent by the node owning the key, masters added later are not covered
+func (h *clusterRedisHelper) SubscribeExpiredKeys(ctx context.Context, db int, fn func(key string) error, opts ...SubscribeOption) error {
+	ctx, cancel := context.WithCancel(ctx)
+	err := h.clusterClient.ForEachMaster(func(client *redis.Client) error {
+		return runSubscription(ctx, client.Subscribe(expiredKeysChannel(db)), 1, expiredKeysHandler(fn), opts...)
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+	// release the derived context along with its parent
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return nil
+}
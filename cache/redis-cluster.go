@@ -12,17 +12,26 @@ import (
 )
 
 type clusterRedisHelper struct {
+	redisCollections
 	clusterClient *redis.ClusterClient
 }
 
-func initRedisCluster(addrs []string) (*redis.ClusterClient, error) {
-	clusterClient := redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs: addrs,
-	})
+func initRedisCluster(options *redis.ClusterOptions) (*redis.ClusterClient, error) {
+	clusterClient := redis.NewClusterClient(options)
 	_, err := clusterClient.Ping().Result()
 	return clusterClient, err
 }
 
+func newClusterRedisHelper(clusterClient *redis.ClusterClient) *clusterRedisHelper {
+	return &clusterRedisHelper{
+		redisCollections: redisCollections{
+			client: clusterClient,
+			name:   "clusterRedisHelper",
+		},
+		clusterClient: clusterClient,
+	}
+}
+
 func (h *clusterRedisHelper) GetTransaction(ctx context.Context, transactionID string) CacheTransactionExecution {
 	txPipeline := h.clusterClient.TxPipeline()
 	return &redisCacheTransaction{
@@ -42,6 +51,21 @@ func (h *clusterRedisHelper) GetPipeline(ctx context.Context, transactionID stri
 	}
 }
 
+// Watch runs fn in an optimistic transaction watching keys, ErrTxFailed is
+// returned when a watched key changed before the queued commands executed
+func (h *clusterRedisHelper) Watch(ctx context.Context, fn func(CacheWatchTransaction) error, keys ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/Watch", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.clusterClient.Watch(func(tx *redis.Tx) error {
+		return fn(&redisWatchTransaction{
+			tx: tx,
+		})
+	}, keys...)
+}
+
 func (h *clusterRedisHelper) Exists(ctx context.Context, key string) (err error) {
 	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/Exists", ext.SpanKindRPCClient)
 	defer func() {
@@ -205,3 +229,91 @@ func (h *clusterRedisHelper) GetType(ctx context.Context, key string) (string, e
 func (h *clusterRedisHelper) RenameKey(ctx context.Context, oldkey, newkey string) error {
 	return nil
 }
+
+func (h *clusterRedisHelper) getBytes(ctx context.Context, key string) (data []byte, err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/GetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.clusterClient.Get(key).Bytes()
+}
+
+// mGetBytes issues one GET per key through a pipeline because MGET fails with
+// CROSSSLOT when the keys are spread over several cluster slots
+func (h *clusterRedisHelper) mGetBytes(ctx context.Context, keys ...string) (result map[string][]byte, err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/MGetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	pipeline := h.clusterClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for index, key := range keys {
+		cmds[index] = pipeline.Get(key)
+	}
+	_, err = pipeline.Exec()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	err = nil
+
+	result = make(map[string][]byte, len(keys))
+	for index, cmd := range cmds {
+		data, cmdErr := cmd.Bytes()
+		if cmdErr != nil {
+			continue
+		}
+		result[keys[index]] = data
+	}
+	return result, nil
+}
+
+func (h *clusterRedisHelper) mGetBytesTTL(ctx context.Context, keys ...string) (result map[string]expiringBytes, err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/MGetBytesTTL", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return getBytesTTL(h.clusterClient.Pipeline(), keys)
+}
+
+func (h *clusterRedisHelper) setBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/SetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.clusterClient.Set(key, data, expiration).Err()
+}
+
+func (h *clusterRedisHelper) setNXBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (isSuccess bool, err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/SetNXBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.clusterClient.SetNX(key, data, expiration).Result()
+}
+
+func (h *clusterRedisHelper) publish(ctx context.Context, channel string, data []byte) (err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/Publish", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.clusterClient.Publish(channel, data).Err()
+}
+
+func (h *clusterRedisHelper) subscribe(channels ...string) *redis.PubSub {
+	return h.clusterClient.Subscribe(channels...)
+}
+
+func (h *clusterRedisHelper) EvalScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (result interface{}, err error) {
+	span := jaeger.Start(ctx, ">helper.clusterRedisHelper/EvalScript", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return script.Run(h.clusterClient, keys, args...).Result()
+}
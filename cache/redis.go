@@ -13,18 +13,32 @@ import (
 )
 
 type redisHelper struct {
+	redisCollections
 	client *redis.Client
 }
 
-func initRedis(addr string, db int) (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-		DB:   db,
-	})
+func initRedis(options *redis.Options) (*redis.Client, error) {
+	client := redis.NewClient(options)
 	_, err := client.Ping().Result()
 	return client, err
 }
 
+func initRedisFailover(options *redis.FailoverOptions) (*redis.Client, error) {
+	client := redis.NewFailoverClient(options)
+	_, err := client.Ping().Result()
+	return client, err
+}
+
+func newRedisHelper(client *redis.Client) *redisHelper {
+	return &redisHelper{
+		redisCollections: redisCollections{
+			client: client,
+			name:   "redisHelper",
+		},
+		client: client,
+	}
+}
+
 func (h *redisHelper) GetTransaction(ctx context.Context, transactionID string) CacheTransactionExecution {
 	txPipeline := h.client.TxPipeline()
 	return &redisCacheTransaction{
@@ -44,6 +58,21 @@ func (h *redisHelper) GetPipeline(ctx context.Context, transactionID string) Cac
 	}
 }
 
+// Watch runs fn in an optimistic transaction watching keys, ErrTxFailed is
+// returned when a watched key changed before the queued commands executed
+func (h *redisHelper) Watch(ctx context.Context, fn func(CacheWatchTransaction) error, keys ...string) (err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/Watch", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.client.Watch(func(tx *redis.Tx) error {
+		return fn(&redisWatchTransaction{
+			tx: tx,
+		})
+	}, keys...)
+}
+
 func (h *redisHelper) Exists(ctx context.Context, key string) (err error) {
 	span := jaeger.Start(ctx, ">helper.redisHelper/Exists", ext.SpanKindRPCClient)
 	defer func() {
@@ -224,3 +253,80 @@ func (h *redisHelper) GetType(ctx context.Context, key string) (string, error) {
 	}()
 	return h.client.Type(key).Result()
 }
+
+func (h *redisHelper) getBytes(ctx context.Context, key string) (data []byte, err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/GetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.client.Get(key).Bytes()
+}
+
+func (h *redisHelper) mGetBytes(ctx context.Context, keys ...string) (result map[string][]byte, err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/MGetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	values, err := h.client.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result = make(map[string][]byte, len(keys))
+	for index, value := range values {
+		if data, ok := value.(string); ok {
+			result[keys[index]] = []byte(data)
+		}
+	}
+	return result, nil
+}
+
+func (h *redisHelper) mGetBytesTTL(ctx context.Context, keys ...string) (result map[string]expiringBytes, err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/MGetBytesTTL", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return getBytesTTL(h.client.Pipeline(), keys)
+}
+
+func (h *redisHelper) setBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/SetBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.client.Set(key, data, expiration).Err()
+}
+
+func (h *redisHelper) setNXBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (isSuccess bool, err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/SetNXBytes", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.client.SetNX(key, data, expiration).Result()
+}
+
+func (h *redisHelper) publish(ctx context.Context, channel string, data []byte) (err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/Publish", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return h.client.Publish(channel, data).Err()
+}
+
+func (h *redisHelper) subscribe(channels ...string) *redis.PubSub {
+	return h.client.Subscribe(channels...)
+}
+
+func (h *redisHelper) EvalScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (result interface{}, err error) {
+	span := jaeger.Start(ctx, ">helper.redisHelper/EvalScript", ext.SpanKindRPCClient)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+
+	return script.Run(h.client, keys, args...).Result()
+}
@@ -0,0 +1,319 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	defaultLocalCacheSize      = 10000
+	defaultLocalCacheTTL       = time.Minute
+	defaultInvalidationChannel = "cache:invalidation"
+)
+
+type (
+	// TieredCacheHelper is a CacheHelper keeping an in-process LRU layer in
+	// front of redis, kept coherent across pods by pub/sub invalidations
+	TieredCacheHelper interface {
+		CacheHelper
+		Close() error
+	}
+
+	// TieredOption represents option of the tiered cache
+	TieredOption func(*tieredCacheHelper)
+
+	// expiringCacheHelper is implemented by the redis helpers to read values
+	// with their remaining TTL
+	expiringCacheHelper interface {
+		bytesCacheHelper
+		mGetBytesTTL(ctx context.Context, keys ...string) (map[string]expiringBytes, error)
+	}
+
+	// expiringBytes is a value and its remaining TTL, 0 when it does not expire
+	expiringBytes struct {
+		data []byte
+		ttl  time.Duration
+	}
+
+	tieredCacheHelper struct {
+		remote  expiringCacheHelper
+		pubsub  pubSubCacheHelper
+		local   *localCache
+		size    int
+		ttl     time.Duration
+		channel string
+		nodeID  string
+		cancel  context.CancelFunc
+	}
+
+	invalidationMessage struct {
+		NodeID string   `json:"node_id"`
+		Keys   []string `json:"keys"`
+	}
+)
+
+// WithLocalSize sets the maximum number of keys kept in memory
+func WithLocalSize(size int) TieredOption {
+	return func(h *tieredCacheHelper) {
+		h.size = size
+	}
+}
+
+// WithLocalTTL sets the maximum time a key is kept in memory, it bounds how
+// long a pod may serve a stale value if an invalidation is lost
+func WithLocalTTL(ttl time.Duration) TieredOption {
+	return func(h *tieredCacheHelper) {
+		h.ttl = ttl
+	}
+}
+
+// WithInvalidationChannel sets the pub/sub channel used for invalidations,
+// every pod sharing the same keys must use the same channel
+func WithInvalidationChannel(channel string) TieredOption {
+	return func(h *tieredCacheHelper) {
+		h.channel = channel
+	}
+}
+
+// NewTieredCacheHelper creates a two-tier cache over a helper returned by
+// NewCacheHelper
+func NewTieredCacheHelper(remote CacheHelper, opts ...TieredOption) (TieredCacheHelper, error) {
+	bytesHelper, ok := remote.(expiringCacheHelper)
+	if !ok {
+		return nil, errors.New("cache helper does not support tiered cache")
+	}
+	pubsubHelper, ok := remote.(pubSubCacheHelper)
+	if !ok {
+		return nil, errors.New("cache helper does not support pub/sub")
+	}
+
+	nodeID := make([]byte, 8)
+	if _, err := rand.Read(nodeID); err != nil {
+		return nil, err
+	}
+
+	h := &tieredCacheHelper{
+		remote:  bytesHelper,
+		pubsub:  pubsubHelper,
+		size:    defaultLocalCacheSize,
+		ttl:     defaultLocalCacheTTL,
+		channel: defaultInvalidationChannel,
+		nodeID:  hex.EncodeToString(nodeID),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.local = newLocalCache(h.size, h.ttl)
+
+	// the local layer is dropped when the subscription is re-established
+	// since invalidations may have been missed in between
+	ctx, cancel := context.WithCancel(context.Background())
+	err := runSubscription(ctx, h.pubsub.subscribe(h.channel), 1, h.handleInvalidation, WithResubscribeHook(h.local.clear))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	h.cancel = cancel
+
+	return h, nil
+}
+
+func (h *tieredCacheHelper) handleInvalidation(msg CacheMessage) error {
+	var invalidation invalidationMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &invalidation); err != nil {
+		return err
+	}
+	if invalidation.NodeID == h.nodeID {
+		return nil
+	}
+	h.local.del(invalidation.Keys...)
+	return nil
+}
+
+// invalidate drops keys from the local layer and broadcasts it to other pods
+func (h *tieredCacheHelper) invalidate(ctx context.Context, keys ...string) error {
+	h.local.del(keys...)
+
+	data, err := json.Marshal(invalidationMessage{NodeID: h.nodeID, Keys: keys})
+	if err != nil {
+		return err
+	}
+	return h.pubsub.publish(ctx, h.channel, data)
+}
+
+func (h *tieredCacheHelper) Close() error {
+	h.cancel()
+	return nil
+}
+
+func (h *tieredCacheHelper) Exists(ctx context.Context, key string) error {
+	if _, ok := h.local.get(key); ok {
+		return nil
+	}
+	return h.remote.Exists(ctx, key)
+}
+
+func (h *tieredCacheHelper) Get(ctx context.Context, key string, value interface{}) error {
+	data, err := h.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &value)
+}
+
+func (h *tieredCacheHelper) GetInterface(ctx context.Context, key string, value interface{}) (interface{}, error) {
+	return h.remote.GetInterface(ctx, key, value)
+}
+
+func (h *tieredCacheHelper) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return h.setBytes(ctx, key, data, expiration)
+}
+
+func (h *tieredCacheHelper) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return h.setNXBytes(ctx, key, data, expiration)
+}
+
+func (h *tieredCacheHelper) Del(ctx context.Context, key string) error {
+	if err := h.remote.Del(ctx, key); err != nil {
+		return err
+	}
+	return h.invalidate(ctx, key)
+}
+
+func (h *tieredCacheHelper) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	if err := h.remote.Expire(ctx, key, expiration); err != nil {
+		return err
+	}
+	return h.invalidate(ctx, key)
+}
+
+func (h *tieredCacheHelper) DelMulti(ctx context.Context, keys ...string) error {
+	if err := h.remote.DelMulti(ctx, keys...); err != nil {
+		return err
+	}
+	return h.invalidate(ctx, keys...)
+}
+
+func (h *tieredCacheHelper) GetKeysByPattern(ctx context.Context, pattern string, cursor uint64, limit int64) ([]string, uint64, error) {
+	return h.remote.GetKeysByPattern(ctx, pattern, cursor, limit)
+}
+
+func (h *tieredCacheHelper) RenameKey(ctx context.Context, oldKey, newKey string) error {
+	if err := h.remote.RenameKey(ctx, oldKey, newKey); err != nil {
+		return err
+	}
+	return h.invalidate(ctx, oldKey, newKey)
+}
+
+func (h *tieredCacheHelper) GetType(ctx context.Context, key string) (string, error) {
+	return h.remote.GetType(ctx, key)
+}
+
+func (h *tieredCacheHelper) getBytes(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := h.local.get(key); ok {
+		return data, nil
+	}
+	values, err := h.remote.mGetBytesTTL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	h.local.set(key, value.data, value.ttl)
+	return value.data, nil
+}
+
+func (h *tieredCacheHelper) mGetBytes(ctx context.Context, keys ...string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	var missingKeys []string
+	for _, key := range keys {
+		if data, ok := h.local.get(key); ok {
+			result[key] = data
+			continue
+		}
+		missingKeys = append(missingKeys, key)
+	}
+	if len(missingKeys) == 0 {
+		return result, nil
+	}
+
+	values, err := h.remote.mGetBytesTTL(ctx, missingKeys...)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		h.local.set(key, value.data, value.ttl)
+		result[key] = value.data
+	}
+	return result, nil
+}
+
+func (h *tieredCacheHelper) setBytes(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	if err := h.remote.setBytes(ctx, key, data, expiration); err != nil {
+		return err
+	}
+	if err := h.invalidate(ctx, key); err != nil {
+		return err
+	}
+	h.local.set(key, data, expiration)
+	return nil
+}
+
+func (h *tieredCacheHelper) setNXBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error) {
+	isSuccess, err := h.remote.setNXBytes(ctx, key, data, expiration)
+	if err != nil || !isSuccess {
+		return isSuccess, err
+	}
+	if err := h.invalidate(ctx, key); err != nil {
+		return isSuccess, err
+	}
+	h.local.set(key, data, expiration)
+	return isSuccess, nil
+}
+
+// getBytesTTL reads keys with their remaining TTL through pipeline, keys which
+// expire in between are left out
+func getBytesTTL(pipeline redis.Pipeliner, keys []string) (map[string]expiringBytes, error) {
+	getCmds := make([]*redis.StringCmd, len(keys))
+	ttlCmds := make([]*redis.DurationCmd, len(keys))
+	for index, key := range keys {
+		getCmds[index] = pipeline.Get(key)
+		ttlCmds[index] = pipeline.PTTL(key)
+	}
+	if _, err := pipeline.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	result := make(map[string]expiringBytes, len(keys))
+	for index, key := range keys {
+		data, err := getCmds[index].Bytes()
+		if err != nil {
+			continue
+		}
+		// PTTL is -1 without expiration and -2 when the key is gone
+		ttl := ttlCmds[index].Val()
+		switch {
+		case ttl > 0:
+			result[key] = expiringBytes{data: data, ttl: ttl}
+		case ttl == -time.Millisecond:
+			result[key] = expiringBytes{data: data}
+		}
+	}
+	return result, nil
+}
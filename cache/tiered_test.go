@@ -0,0 +1,101 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+)
+
+// TestTieredCacheInvalidation validates a write on one pod invalidates the
+// local layer of the other pods
+func TestTieredCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestHelper(t)
+
+	podA, err := cache.NewTieredCacheHelper(helper)
+	if err != nil {
+		t.Fatalf("Should be able to create a tiered cache. %v %v", ballotX, err)
+	}
+	defer podA.Close()
+	podB, err := cache.NewTieredCacheHelper(cache.NewCacheHelper([]string{server.Addr()}))
+	if err != nil {
+		t.Fatalf("Should be able to create a tiered cache. %v %v", ballotX, err)
+	}
+	defer podB.Close()
+
+	t.Log("Given the need to share a key between two pods")
+	{
+		if err := podA.Set(ctx, "config", "v1", time.Minute); err != nil {
+			t.Fatalf("\tShould be able to set the value. %v %v", ballotX, err)
+		}
+
+		var value string
+		if err := podB.Get(ctx, "config", &value); err != nil || value != "v1" {
+			t.Fatalf("\tShould read the first value. %v %q %v", ballotX, value, err)
+		}
+		t.Logf("\tShould read the first value. %v", checkMark)
+
+		t.Log("\tWhen the value is read from the local layer")
+		{
+			server.Set("config", `"changed behind the cache"`)
+			if err := podB.Get(ctx, "config", &value); err != nil || value != "v1" {
+				t.Errorf("\t\tShould not round-trip to redis. %v %q %v", ballotX, value, err)
+			} else {
+				t.Logf("\t\tShould not round-trip to redis. %v", checkMark)
+			}
+		}
+
+		t.Log("\tWhen the value is updated by the other pod")
+		{
+			if err := podA.Set(ctx, "config", "v2", time.Minute); err != nil {
+				t.Fatalf("\t\tShould be able to set the value. %v %v", ballotX, err)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				if err := podB.Get(ctx, "config", &value); err == nil && value == "v2" {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if value != "v2" {
+				t.Errorf("\t\tShould read the new value. %v %q", ballotX, value)
+			} else {
+				t.Logf("\t\tShould read the new value. %v", checkMark)
+			}
+		}
+	}
+}
+
+// TestTieredCacheRemoteTTL validates local entries expire with the remote ones
+func TestTieredCacheRemoteTTL(t *testing.T) {
+	ctx := context.Background()
+	server, helper := newTestHelper(t)
+
+	tiered, err := cache.NewTieredCacheHelper(helper, cache.WithLocalTTL(0))
+	if err != nil {
+		t.Fatalf("Should be able to create a tiered cache. %v %v", ballotX, err)
+	}
+	defer tiered.Close()
+
+	t.Log("Given the need to keep a remote key for a short time without local TTL")
+	{
+		server.Set("session", `"token"`)
+		server.SetTTL("session", 50*time.Millisecond)
+
+		var value string
+		if err := tiered.Get(ctx, "session", &value); err != nil || value != "token" {
+			t.Fatalf("\tShould read the value. %v %q %v", ballotX, value, err)
+		}
+		t.Logf("\tShould read the value. %v", checkMark)
+
+		time.Sleep(60 * time.Millisecond)
+		server.FastForward(60 * time.Millisecond)
+		if err := tiered.Get(ctx, "session", &value); err != cache.ErrCacheMiss {
+			t.Errorf("\tShould not keep the value after its remote expiration. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould not keep the value after its remote expiration. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// ErrCacheMiss is returned when the key does not exist in cache, it is the
+// same value as redis.Nil so existing checks keep working
+var ErrCacheMiss = redis.Nil
+
+// bytesCacheHelper is implemented by the redis helpers to let typed wrappers
+// bypass the JSON encoding done by CacheHelper.Get/Set
+type bytesCacheHelper interface {
+	CacheHelper
+	getBytes(ctx context.Context, key string) ([]byte, error)
+	mGetBytes(ctx context.Context, keys ...string) (map[string][]byte, error)
+	setBytes(ctx context.Context, key string, data []byte, expiration time.Duration) error
+	setNXBytes(ctx context.Context, key string, data []byte, expiration time.Duration) (bool, error)
+}
+
+// Typed represents a type-safe view of a CacheHelper for values of type T
+type Typed[T any] struct {
+	helper   bytesCacheHelper
+	codec    Codec
+	newValue func() T
+	loader   *Loader[T]
+}
+
+// NewTyped creates a typed cache over a helper returned by NewCacheHelper,
+// values are encoded with JSONCodec when codec is nil
+func NewTyped[T any](helper CacheHelper, codec Codec) (*Typed[T], error) {
+	bytesHelper, ok := helper.(bytesCacheHelper)
+	if !ok {
+		return nil, errors.New("cache helper does not support typed access")
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	typed := &Typed[T]{
+		helper: bytesHelper,
+		codec:  codec,
+	}
+
+	// pointer types must be allocated before decoding, protobuf messages
+	// in particular cannot be decoded through a pointer to a nil pointer
+	var zero T
+	if typeValue := reflect.TypeOf(zero); typeValue != nil && typeValue.Kind() == reflect.Ptr {
+		elemType := typeValue.Elem()
+		typed.newValue = func() T {
+			return reflect.New(elemType).Interface().(T)
+		}
+	}
+	typed.loader = NewLoader(typed)
+	return typed, nil
+}
+
+// Codec returns codec used to encode values
+func (t *Typed[T]) Codec() Codec {
+	return t.codec
+}
+
+// Get returns value of key, ErrCacheMiss when key does not exist
+func (t *Typed[T]) Get(ctx context.Context, key string) (value T, err error) {
+	data, err := t.helper.getBytes(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	return t.decode(data)
+}
+
+// MGet returns values of existing keys, missing keys are not present in result
+func (t *Typed[T]) MGet(ctx context.Context, keys ...string) (map[string]T, error) {
+	if len(keys) == 0 {
+		return map[string]T{}, nil
+	}
+
+	values, err := t.helper.mGetBytes(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]T, len(values))
+	for key, data := range values {
+		value, err := t.decode(data)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// Set stores value of key with expiration, zero expiration means no expiration
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, expiration time.Duration) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.helper.setBytes(ctx, key, data, expiration)
+}
+
+// SetNX stores value of key only when key does not exist yet
+func (t *Typed[T]) SetNX(ctx context.Context, key string, value T, expiration time.Duration) (bool, error) {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return t.helper.setNXBytes(ctx, key, data, expiration)
+}
+
+// GetOrLoad returns value of key, on cache miss value is loaded by loader and
+// stored with expiration. Concurrent misses of the same key in this process
+// share a single call to loader, use NewLoader for more options
+func (t *Typed[T]) GetOrLoad(ctx context.Context, key string, expiration time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	return t.loader.GetOrLoad(ctx, key, expiration, loader)
+}
+
+func (t *Typed[T]) decode(data []byte) (value T, err error) {
+	if t.newValue != nil {
+		value = t.newValue()
+		err = t.codec.Unmarshal(data, value)
+		return value, err
+	}
+	err = t.codec.Unmarshal(data, &value)
+	return value, err
+}
@@ -0,0 +1,64 @@
+package cache_test
+
+import (
+	"context"
+	"lib/cache"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestHelper(t *testing.T) (*miniredis.Miniredis, cache.CacheHelper) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Should be able to start miniredis. %v %v", ballotX, err)
+	}
+	t.Cleanup(server.Close)
+	return server, cache.NewCacheHelper([]string{server.Addr()})
+}
+
+// TestTypedCache validates typed values are stored and loaded with a codec
+func TestTypedCache(t *testing.T) {
+	ctx := context.Background()
+	_, helper := newTestHelper(t)
+
+	t.Log("Given the need to store typed values in cache")
+	{
+		typed, err := cache.NewTyped[*codecUser](helper, cache.MsgpackCodec)
+		if err != nil {
+			t.Fatalf("\tShould be able to create a typed cache. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould be able to create a typed cache. %v", checkMark)
+
+		t.Log("\tWhen the key does not exist")
+		{
+			if _, err := typed.Get(ctx, "user:missing"); err != cache.ErrCacheMiss {
+				t.Errorf("\t\tShould return ErrCacheMiss. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould return ErrCacheMiss. %v", checkMark)
+			}
+		}
+
+		t.Log("\tWhen the key has been set")
+		{
+			want := &codecUser{Name: "Lisa", Email: "lisa@ardanstudios.com"}
+			if err := typed.Set(ctx, "user:lisa", want, time.Minute); err != nil {
+				t.Fatalf("\t\tShould be able to set the value. %v %v", ballotX, err)
+			}
+			got, err := typed.Get(ctx, "user:lisa")
+			if err != nil || got.Name != want.Name || got.Email != want.Email {
+				t.Errorf("\t\tShould get back the same value. %v %+v %v", ballotX, got, err)
+			} else {
+				t.Logf("\t\tShould get back the same value. %v", checkMark)
+			}
+
+			values, err := typed.MGet(ctx, "user:lisa", "user:missing")
+			if err != nil || len(values) != 1 || values["user:lisa"].Name != want.Name {
+				t.Errorf("\t\tShould only return existing keys from MGet. %v %+v %v", ballotX, values, err)
+			} else {
+				t.Logf("\t\tShould only return existing keys from MGet. %v", checkMark)
+			}
+		}
+	}
+}
@@ -73,4 +73,5 @@ const (
 
 const (
 	DomainMDKey = "domain"
+	UserIDMDKey = "user_id"
 )
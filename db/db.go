@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/globalsign/mgo"
@@ -13,6 +14,14 @@ type DBHelper interface {
 	Begin() (*sql.Tx, error)
 	Commit(tx *sql.Tx) error
 	RollBack(tx *sql.Tx) error
+	// BeginTx starts a transaction bound to ctx
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	// WithTx runs fn in a transaction, see TxOptions
+	WithTx(ctx context.Context, opts *TxOptions, fn func(tx *sql.Tx) error) error
+	// Ping checks a connection can be made, it can be used by readiness probes
+	Ping(ctx context.Context) error
+	// Stats returns the statistics of the connection pool
+	Stats() sql.DBStats
 }
 
 type NoSQLDBHelper interface {
@@ -1,10 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	"go.uber.org/zap"
 )
 
@@ -12,15 +13,44 @@ type dbHelper struct {
 	db *sql.DB
 }
 
+// NewMySQLDBHelper panics when the database cannot be reached, see
+// NewMySQLHelper to configure the connections
 func NewMySQLDBHelper(host, username, password, database string, port int) DBHelper {
-	db, err := initMysql(host, username, password, database, port)
+	helper, err := NewMySQLHelper(host, username, password, database, port)
 	if err != nil {
 		fmt.Println("Panic Failed to init mysql", zap.Error(err)) // not log
 		panic(err)
 	}
+	return helper
+}
+
+// NewMySQLHelper creates a helper over a pool of connections configured by
+// opts, it returns an error when the database cannot be reached
+func NewMySQLHelper(host, username, password, database string, port int, opts ...MySQLOption) (DBHelper, error) {
+	options := &mysqlOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.err != nil {
+		return nil, options.err
+	}
+
+	db, err := initMysql(options.config(host, username, password, database, port))
+	if err != nil {
+		return nil, err
+	}
+	if options.maxOpenConns > 0 {
+		db.SetMaxOpenConns(options.maxOpenConns)
+	}
+	if options.maxIdleConns > 0 {
+		db.SetMaxIdleConns(options.maxIdleConns)
+	}
+	db.SetConnMaxLifetime(options.connMaxLifetime)
+	db.SetConnMaxIdleTime(options.connMaxIdleTime)
+
 	return &dbHelper{
 		db: db,
-	}
+	}, nil
 }
 
 func (h *dbHelper) Open() *sql.DB {
@@ -42,15 +72,24 @@ func (h *dbHelper) Commit(tx *sql.Tx) error {
 func (h *dbHelper) RollBack(tx *sql.Tx) error {
 	return tx.Rollback()
 }
-func initMysql(host, username, password, database string, port int) (*sql.DB, error) {
-	connectionStr := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", username, password, host, port, database)
 
-	db, err := sql.Open("mysql", connectionStr)
+func (h *dbHelper) Ping(ctx context.Context) error {
+	return h.db.PingContext(ctx)
+}
+
+func (h *dbHelper) Stats() sql.DBStats {
+	return h.db.Stats()
+}
+
+func initMysql(config *mysql.Config) (*sql.DB, error) {
+	connector, err := mysql.NewConnector(config)
 	if err != nil {
 		return nil, err
 	}
 
+	db := sql.OpenDB(connector)
 	if err := db.Ping(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
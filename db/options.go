@@ -0,0 +1,150 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type (
+	// MySQLOption represents option of the mysql connection
+	MySQLOption func(*mysqlOptions)
+
+	mysqlOptions struct {
+		maxOpenConns    int
+		maxIdleConns    int
+		connMaxLifetime time.Duration
+		connMaxIdleTime time.Duration
+
+		dialTimeout  time.Duration
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+
+		tlsConfig *tls.Config
+		parseTime bool
+		location  *time.Location
+		charset   string
+		collation string
+		params    map[string]string
+
+		err error
+	}
+)
+
+// WithPool sets the maximum number of open and idle connections, the driver
+// keeps two idle connections and no limit of open ones by default
+func WithPool(maxOpenConns, maxIdleConns int) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.maxOpenConns = maxOpenConns
+		o.maxIdleConns = maxIdleConns
+	}
+}
+
+// WithConnLifetime closes connections opened for longer than maxLifetime or
+// idle for longer than maxIdleTime, it should be shorter than wait_timeout
+func WithConnLifetime(maxLifetime, maxIdleTime time.Duration) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.connMaxLifetime = maxLifetime
+		o.connMaxIdleTime = maxIdleTime
+	}
+}
+
+// WithTimeouts sets dial, read and write timeouts
+func WithTimeouts(dial, read, write time.Duration) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.dialTimeout = dial
+		o.readTimeout = read
+		o.writeTimeout = write
+	}
+}
+
+// WithTLSConfig enables TLS with config
+func WithTLSConfig(config *tls.Config) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.tlsConfig = config
+	}
+}
+
+// WithTLSRootCA enables TLS trusting the PEM encoded certificates of caPEM
+func WithTLSRootCA(caPEM []byte) MySQLOption {
+	return func(o *mysqlOptions) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			o.err = errors.New("failed to parse mysql CA certificate")
+			return
+		}
+		if o.tlsConfig == nil {
+			o.tlsConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		o.tlsConfig.RootCAs = pool
+	}
+}
+
+// WithParseTime scans DATE and DATETIME columns into time.Time in location,
+// UTC when location is nil
+func WithParseTime(location *time.Location) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.parseTime = true
+		o.location = location
+	}
+}
+
+// WithCharset sets the charset and the collation of the connections, for
+// instance utf8mb4 and utf8mb4_unicode_ci. The collation, which implies its
+// charset, is sent in the handshake, the charset is only used without
+// collation since the driver runs SET NAMES for it which resets the
+// collation to the default one of the charset
+func WithCharset(charset, collation string) MySQLOption {
+	return func(o *mysqlOptions) {
+		o.charset = charset
+		o.collation = collation
+	}
+}
+
+// WithParams adds params to the DSN, they are sent as system variables such
+// as time_zone or sql_mode
+func WithParams(params map[string]string) MySQLOption {
+	return func(o *mysqlOptions) {
+		if o.params == nil {
+			o.params = map[string]string{}
+		}
+		for key, value := range params {
+			o.params[key] = value
+		}
+	}
+}
+
+func (o *mysqlOptions) config(host, username, password, database string, port int) *mysql.Config {
+	config := mysql.NewConfig()
+	config.User = username
+	config.Passwd = password
+	config.Net = "tcp"
+	config.Addr = fmt.Sprintf("%v:%v", host, port)
+	config.DBName = database
+	config.Timeout = o.dialTimeout
+	config.ReadTimeout = o.readTimeout
+	config.WriteTimeout = o.writeTimeout
+	config.TLS = o.tlsConfig
+	config.ParseTime = o.parseTime
+	if o.location != nil {
+		config.Loc = o.location
+	}
+	if o.collation != "" {
+		config.Collation = o.collation
+	}
+
+	config.Params = map[string]string{}
+	for key, value := range o.params {
+		config.Params[key] = value
+	}
+	if o.charset != "" && o.collation == "" {
+		config.Params["charset"] = o.charset
+	}
+	return config
+}
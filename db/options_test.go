@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// TestMySQLOptions validates the options are set in the configuration parsed
+// by the driver from the DSN. The statements the driver runs on connect are
+// not checked since it needs a server: it sends the collation in the
+// handshake and runs SET NAMES only for the charset param
+func TestMySQLOptions(t *testing.T) {
+	t.Log("Given the need to configure the mysql connections")
+	{
+		options := &mysqlOptions{}
+		for _, opt := range []MySQLOption{
+			WithParseTime(nil),
+			WithCharset("utf8mb4", "utf8mb4_unicode_ci"),
+			WithTimeouts(time.Second, 2*time.Second, 2*time.Second),
+			WithParams(map[string]string{"time_zone": "'+00:00'"}),
+		} {
+			opt(options)
+		}
+
+		config, err := mysql.ParseDSN(options.config("localhost", "app", "secret", "payments", 3306).FormatDSN())
+		if err != nil {
+			t.Fatalf("\tShould format a valid DSN. %v %v", ballotX, err)
+		}
+		if config.User != "app" || config.Addr != "localhost:3306" || config.DBName != "payments" || !config.ParseTime ||
+			config.Timeout != time.Second || config.ReadTimeout != 2*time.Second || config.Params["time_zone"] != "'+00:00'" {
+			t.Errorf("\tShould set the options in the DSN. %v %+v", ballotX, config)
+		} else {
+			t.Logf("\tShould set the options in the DSN. %v", checkMark)
+		}
+
+		if _, ok := config.Params["charset"]; ok || config.Collation != "utf8mb4_unicode_ci" {
+			t.Errorf("\tShould set the collation without the charset resetting it. %v %v %v", ballotX, config.Collation, config.Params)
+		} else {
+			t.Logf("\tShould set the collation without the charset resetting it. %v", checkMark)
+		}
+
+		options = &mysqlOptions{}
+		WithCharset("utf8mb4", "")(options)
+		config, err = mysql.ParseDSN(options.config("localhost", "app", "secret", "payments", 3306).FormatDSN())
+		if err != nil || config.Params["charset"] != "utf8mb4" {
+			t.Errorf("\tShould set the charset without collation. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould set the charset without collation. %v", checkMark)
+		}
+
+		if _, err := NewMySQLHelper("localhost", "app", "secret", "payments", 3306, WithTLSRootCA([]byte("invalid"))); err == nil {
+			t.Errorf("\tShould return an error for an invalid CA. %v", ballotX)
+		} else {
+			t.Logf("\tShould return an error for an invalid CA. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+)
+
+// DefaultTxAttempts is how many times WithTx runs a transaction failing on a
+// deadlock or a serialization failure
+const DefaultTxAttempts = 3
+
+// MySQL errors after which a transaction can be run again
+const (
+	errLockDeadlock       = 1213
+	sqlStateSerialization = "40001"
+)
+
+const txRetryBackoff = 20 * time.Millisecond
+
+// savepoints names the savepoints of Savepoint
+var savepoints uint64
+
+// TxOptions represents options of WithTx
+type TxOptions struct {
+	// Isolation is the isolation level, the one of the server by default
+	Isolation sql.IsolationLevel
+	// ReadOnly starts a read only transaction
+	ReadOnly bool
+	// MaxAttempts is how many times the transaction is run when it fails on a
+	// deadlock or a serialization failure, DefaultTxAttempts when not set
+	MaxAttempts int
+}
+
+func (h *dbHelper) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return h.db.BeginTx(ctx, opts)
+}
+
+// WithTx runs fn in a transaction committed when fn succeeds and rolled back
+// when it fails or panics, fn is run again on deadlocks so it must not have
+// side effects outside of tx
+func (h *dbHelper) WithTx(ctx context.Context, opts *TxOptions, fn func(tx *sql.Tx) error) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = h.runTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}, fn)
+		if err == nil || !IsRetryable(err) || attempt == attempts {
+			return err
+		}
+
+		zap.S().Debugw("Retrying transaction", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * txRetryBackoff):
+		}
+	}
+	return err
+}
+
+func (h *dbHelper) runTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := h.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			zap.S().Warnw("Failed to roll back transaction", "error", rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Savepoint runs fn in a savepoint of tx, the writes of fn are rolled back
+// when it fails or panics while tx goes on, savepoints can be nested
+func Savepoint(ctx context.Context, tx *sql.Tx, fn func(tx *sql.Tx) error) (err error) {
+	name := fmt.Sprintf("sp_%d", atomic.AddUint64(&savepoints, 1))
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rollbackErr != nil {
+			zap.S().Warnw("Failed to roll back savepoint", "savepoint", name, "error", rollbackErr)
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// IsRetryable reports whether err is a deadlock or a serialization failure,
+// after which the whole transaction can be run again
+func IsRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == errLockDeadlock || string(mysqlErr.SQLState[:]) == sqlStateSerialization
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+type (
+	// recordDriver records the statements and fails the commits of commitErrs
+	recordDriver struct {
+		statements []string
+		commitErrs []error
+	}
+
+	recordConn struct {
+		driver *recordDriver
+	}
+)
+
+func (d *recordDriver) Open(name string) (driver.Conn, error) {
+	return &recordConn{driver: d}, nil
+}
+
+// Connect makes recordDriver its own connector so each test opens it with
+// sql.OpenDB instead of registering it
+func (d *recordDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *recordDriver) Driver() driver.Driver {
+	return d
+}
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *recordConn) Close() error {
+	return nil
+}
+
+func (c *recordConn) Begin() (driver.Tx, error) {
+	c.driver.statements = append(c.driver.statements, "BEGIN")
+	return c, nil
+}
+
+func (c *recordConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.statements = append(c.driver.statements, query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recordConn) Commit() error {
+	c.driver.statements = append(c.driver.statements, "COMMIT")
+	if len(c.driver.commitErrs) == 0 {
+		return nil
+	}
+	err := c.driver.commitErrs[0]
+	c.driver.commitErrs = c.driver.commitErrs[1:]
+	return err
+}
+
+func (c *recordConn) Rollback() error {
+	c.driver.statements = append(c.driver.statements, "ROLLBACK")
+	return nil
+}
+
+// TestWithTx validates transactions are retried and rolled back
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	recorder := &recordDriver{}
+	helper := &dbHelper{db: sql.OpenDB(recorder)}
+
+	t.Log("Given the need to run transactions")
+	{
+		recorder.commitErrs = []error{&mysql.MySQLError{Number: errLockDeadlock}}
+		calls := 0
+		err := helper.WithTx(ctx, nil, func(tx *sql.Tx) error {
+			calls++
+			_, err := tx.ExecContext(ctx, "UPDATE wallets SET balance = balance - 1")
+			return err
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("\tShould run again a transaction failing on a deadlock. %v %v %v", ballotX, calls, err)
+		} else {
+			t.Logf("\tShould run again a transaction failing on a deadlock. %v", checkMark)
+		}
+
+		recorder.statements = nil
+		err = helper.WithTx(ctx, nil, func(tx *sql.Tx) error {
+			err := Savepoint(ctx, tx, func(tx *sql.Tx) error {
+				return errors.New("insufficient balance")
+			})
+			if err == nil {
+				t.Errorf("\tShould return the error of the savepoint. %v", ballotX)
+			}
+			return nil
+		})
+		got := strings.Join(recorder.statements, ";")
+		if err != nil || !strings.Contains(got, "ROLLBACK TO SAVEPOINT") || !strings.HasSuffix(got, "COMMIT") {
+			t.Errorf("\tShould roll back the savepoint and commit the transaction. %v %v", ballotX, got)
+		} else {
+			t.Logf("\tShould roll back the savepoint and commit the transaction. %v", checkMark)
+		}
+
+		recorder.statements = nil
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("\tShould panic again. %v", ballotX)
+				}
+			}()
+			_ = helper.WithTx(ctx, nil, func(tx *sql.Tx) error {
+				panic("unexpected state")
+			})
+		}()
+		if got := strings.Join(recorder.statements, ";"); got != "BEGIN;ROLLBACK" {
+			t.Errorf("\tShould roll back on panic. %v %v", ballotX, got)
+		} else {
+			t.Logf("\tShould roll back on panic. %v", checkMark)
+		}
+	}
+}
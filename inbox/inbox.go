@@ -0,0 +1,141 @@
+package inbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lib/outbox"
+	"lib/pubsub"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// HeaderIdempotencyKey identifies a message set by its publisher
+const HeaderIdempotencyKey = "idempotency-key"
+
+const defaultLease = time.Minute
+
+var (
+	// ErrDuplicate is returned by Store.Begin when the message was processed
+	ErrDuplicate = errors.New("inbox: message already processed")
+	// ErrInProgress is returned by Store.Begin when the message is being
+	// processed by another consumer
+	ErrInProgress = errors.New("inbox: message being processed")
+	// ErrMissingID is returned when no ID can be found for a message
+	ErrMissingID = errors.New("inbox: missing message id")
+	// ErrClaimLost is returned by Store.Complete when the claim expired and
+	// was taken over by another consumer, or by SQLStore.CompleteTx when it
+	// was released
+	ErrClaimLost = errors.New("inbox: claim taken over by another consumer")
+)
+
+type (
+	// Store records the IDs of the processed messages
+	Store interface {
+		// Begin claims id for lease and returns the token owning the claim,
+		// it returns ErrDuplicate when id was processed and ErrInProgress
+		// while another claim is not expired
+		Begin(ctx context.Context, id string, lease time.Duration) (string, error)
+		// Complete records id as processed if token still owns its claim
+		Complete(ctx context.Context, id, token string) error
+		// Abort releases the claim of token on id so the message can be
+		// processed again, a claim taken over is left to its new owner
+		Abort(ctx context.Context, id, token string) error
+	}
+
+	// IDFunc returns the ID of a message received from a broker
+	IDFunc func(message interface{}) (string, error)
+
+	// Option represents option of Wrap
+	Option func(*options)
+
+	options struct {
+		idFunc IDFunc
+		lease  time.Duration
+	}
+)
+
+// WithIDFunc identifies messages with fn instead of MessageID
+func WithIDFunc(fn IDFunc) Option {
+	return func(o *options) {
+		o.idFunc = fn
+	}
+}
+
+// WithLease sets how long a message is reserved to the consumer handling it,
+// it must be longer than the handler takes, a minute by default
+func WithLease(lease time.Duration) Option {
+	return func(o *options) {
+		o.lease = lease
+	}
+}
+
+// Wrap returns a handler for NewSubscriber calling handler once per message
+// ID, duplicates are acknowledged without calling handler and messages being
+// processed by another consumer fail so they are redelivered later
+func Wrap(store Store, handler func(ctx context.Context, message interface{}) error, opts ...Option) func(ctx context.Context, message interface{}) error {
+	options := options{
+		idFunc: MessageID,
+		lease:  defaultLease,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return func(ctx context.Context, message interface{}) error {
+		id, err := options.idFunc(message)
+		if err != nil {
+			return err
+		}
+
+		token, err := store.Begin(ctx, id, options.lease)
+		if errors.Is(err, ErrDuplicate) {
+			zap.S().Debugw("Skipped duplicate message", "id", id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := handler(ctx, message); err != nil {
+			if abortErr := store.Abort(ctx, id, token); abortErr != nil {
+				zap.S().Warnw("Failed to abort inbox message", "id", id, "error", abortErr)
+			}
+			return err
+		}
+		return store.Complete(ctx, id, token)
+	}
+}
+
+// MessageID returns the idempotency key of message, or the ID given by the
+// outbox relay which is unique across the outboxes, kafka messages without
+// them are identified by their offset
+func MessageID(message interface{}) (string, error) {
+	headers, err := pubsub.MessageHeaders(message)
+	if err != nil {
+		return "", err
+	}
+	if id := headers[HeaderIdempotencyKey]; id != "" {
+		return id, nil
+	}
+	if id := headers[outbox.HeaderEventID]; id != "" {
+		return id, nil
+	}
+	if m, ok := message.(*sarama.ConsumerMessage); ok {
+		return fmt.Sprintf("%s-%d-%d", m.Topic, m.Partition, m.Offset), nil
+	}
+	return "", ErrMissingID
+}
+
+// newToken returns a random token identifying a claim
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,124 @@
+package inbox_test
+
+import (
+	"context"
+	"errors"
+	"lib/cache"
+	"lib/inbox"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/alicebob/miniredis/v2"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// TestWrap validates redelivered messages are handled once
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Should be able to start miniredis. %v %v", ballotX, err)
+	}
+	defer server.Close()
+
+	helper, err := cache.NewRedisCacheHelper([]string{server.Addr()}, cache.WithoutMetrics())
+	if err != nil {
+		t.Fatalf("Should be able to create the helper. %v %v", ballotX, err)
+	}
+	store, err := inbox.NewRedisStore(helper, "inbox:payments", time.Hour)
+	if err != nil {
+		t.Fatalf("Should be able to create the store. %v %v", ballotX, err)
+	}
+
+	var (
+		calls int
+		fail  = true
+	)
+	handler := inbox.Wrap(store, func(ctx context.Context, message interface{}) error {
+		calls++
+		if fail {
+			fail = false
+			return errors.New("payment gateway unavailable")
+		}
+		return nil
+	})
+
+	t.Log("Given the need to handle redelivered messages once")
+	{
+		message := &sarama.ConsumerMessage{Topic: "payments", Partition: 1, Offset: 42}
+
+		if err := handler(ctx, message); err == nil {
+			t.Fatalf("\tShould return the error of the handler. %v", ballotX)
+		}
+		if err := handler(ctx, message); err != nil || calls != 2 {
+			t.Errorf("\tShould handle again a message which failed. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould handle again a message which failed. %v", checkMark)
+		}
+
+		if err := handler(ctx, message); err != nil || calls != 2 {
+			t.Errorf("\tShould skip a processed message. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould skip a processed message. %v", checkMark)
+		}
+
+		keyed := &sarama.ConsumerMessage{Topic: "payments", Offset: 43, Headers: []*sarama.RecordHeader{
+			{Key: []byte(inbox.HeaderIdempotencyKey), Value: []byte("payment-1")},
+		}}
+		if id, err := inbox.MessageID(keyed); err != nil || id != "payment-1" {
+			t.Errorf("\tShould identify messages by their idempotency key. %v %v", ballotX, id)
+		} else {
+			t.Logf("\tShould identify messages by their idempotency key. %v", checkMark)
+		}
+
+		if _, err := store.Begin(ctx, "payment-2", time.Minute); err != nil {
+			t.Fatalf("\tShould claim a new message. %v %v", ballotX, err)
+		}
+		if _, err := store.Begin(ctx, "payment-2", time.Minute); !errors.Is(err, inbox.ErrInProgress) {
+			t.Errorf("\tShould refuse a message being processed. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould refuse a message being processed. %v", checkMark)
+		}
+
+		t.Log("\tWhen the claim expired and was taken over")
+		{
+			expired, err := store.Begin(ctx, "payment-3", time.Second)
+			if err != nil {
+				t.Fatalf("\t\tShould claim a new message. %v %v", ballotX, err)
+			}
+			server.FastForward(2 * time.Second)
+			owner, err := store.Begin(ctx, "payment-3", time.Minute)
+			if err != nil {
+				t.Fatalf("\t\tShould take over an expired claim. %v %v", ballotX, err)
+			}
+
+			if err := store.Abort(ctx, "payment-3", expired); err != nil {
+				t.Fatalf("\t\tShould abort the expired claim. %v %v", ballotX, err)
+			}
+			if _, err := store.Begin(ctx, "payment-3", time.Minute); !errors.Is(err, inbox.ErrInProgress) {
+				t.Errorf("\t\tShould not release the claim of the new owner. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould not release the claim of the new owner. %v", checkMark)
+			}
+			if err := store.Complete(ctx, "payment-3", expired); !errors.Is(err, inbox.ErrClaimLost) {
+				t.Errorf("\t\tShould not complete the claim of the new owner. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould not complete the claim of the new owner. %v", checkMark)
+			}
+
+			if err := store.Complete(ctx, "payment-3", owner); err != nil {
+				t.Fatalf("\t\tShould complete the claim of its owner. %v %v", ballotX, err)
+			}
+			if _, err := store.Begin(ctx, "payment-3", time.Minute); !errors.Is(err, inbox.ErrDuplicate) {
+				t.Errorf("\t\tShould record the message processed by the new owner. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould record the message processed by the new owner. %v", checkMark)
+			}
+		}
+	}
+}
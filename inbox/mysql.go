@@ -0,0 +1,157 @@
+package inbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"lib/db"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateEntry is the MySQL error of a unique key violation
+const errDuplicateEntry = 1062
+
+const mysqlSchema = `CREATE TABLE IF NOT EXISTS %s (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	token CHAR(32) NOT NULL,
+	done TINYINT(1) NOT NULL DEFAULT 0,
+	locked_until DATETIME(6) NOT NULL,
+	processed_at DATETIME(6) NULL,
+	KEY idx_%s_processed (done, processed_at)
+)`
+
+type (
+	// SQLStore represents an inbox table which can be completed within the
+	// transaction of the handler
+	SQLStore interface {
+		Store
+		// EnsureTable creates the inbox table when it does not exist
+		EnsureTable(ctx context.Context) error
+		// CompleteTx records id as processed within tx, the message is then
+		// processed exactly once as far as the writes of tx are concerned.
+		// It returns ErrDuplicate when another consumer completed id, or
+		// ErrClaimLost when the claim was released, tx must then be rolled
+		// back. The row lock of id serializes the consumers completing it
+		CompleteTx(ctx context.Context, tx *sql.Tx, id string) error
+		// Purge deletes the IDs processed before t
+		Purge(ctx context.Context, t time.Time) (int64, error)
+	}
+
+	mysqlStore struct {
+		db    *sql.DB
+		table string
+	}
+)
+
+// NewMySQLStore records the processed IDs in table of the database of helper
+func NewMySQLStore(helper db.DBHelper, table string) SQLStore {
+	return &mysqlStore{
+		db:    helper.Open(),
+		table: table,
+	}
+}
+
+func (s *mysqlStore) EnsureTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(mysqlSchema, s.table, s.table))
+	return err
+}
+
+func (s *mysqlStore) Begin(ctx context.Context, id string, lease time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, token, locked_until) VALUES (?, ?, ?)", s.table), id, token, now.Add(lease))
+	if err == nil {
+		return token, nil
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != errDuplicateEntry {
+		return "", err
+	}
+
+	// take over the claim of a consumer which did not complete in time
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET token = ?, locked_until = ? WHERE id = ? AND done = 0 AND locked_until < ?", s.table),
+		token, now.Add(lease), id, now)
+	if err != nil {
+		return "", err
+	}
+	taken, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if taken == 1 {
+		return token, nil
+	}
+
+	var done bool
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT done FROM %s WHERE id = ?", s.table), id).Scan(&done)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	if done {
+		return "", ErrDuplicate
+	}
+	return "", ErrInProgress
+}
+
+func (s *mysqlStore) Complete(ctx context.Context, id, token string) error {
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET done = 1, processed_at = ? WHERE id = ? AND token = ? AND done = 0", s.table),
+		time.Now().UTC(), id, token)
+	if err != nil {
+		return err
+	}
+	if completed, err := result.RowsAffected(); err != nil || completed == 1 {
+		return err
+	}
+
+	// the handler may have completed the claim with CompleteTx
+	var owner string
+	err = s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT token FROM %s WHERE id = ? AND done = 1", s.table), id).Scan(&owner)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if owner != token {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+func (s *mysqlStore) CompleteTx(ctx context.Context, tx *sql.Tx, id string) error {
+	result, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET done = 1, processed_at = ? WHERE id = ? AND done = 0", s.table),
+		time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if completed, err := result.RowsAffected(); err != nil || completed == 1 {
+		return err
+	}
+
+	var done bool
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT done FROM %s WHERE id = ?", s.table), id).Scan(&done)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if done {
+		return ErrDuplicate
+	}
+	return ErrClaimLost
+}
+
+func (s *mysqlStore) Abort(ctx context.Context, id, token string) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ? AND token = ? AND done = 0", s.table), id, token)
+	return err
+}
+
+func (s *mysqlStore) Purge(ctx context.Context, t time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE done = 1 AND processed_at < ?", s.table), t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
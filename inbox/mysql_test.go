@@ -0,0 +1,118 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// TestMySQLStore validates claims are taken over once expired and completed
+// once by their owner
+func TestMySQLStore(t *testing.T) {
+	ctx := context.Background()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Should be able to open the database. %v %v", ballotX, err)
+	}
+	defer conn.Close()
+	store := &mysqlStore{db: conn, table: "inbox"}
+	duplicate := &mysql.MySQLError{Number: errDuplicateEntry}
+
+	t.Log("Given the need to record the processed messages in mysql")
+	{
+		mock.ExpectExec("INSERT INTO inbox").WithArgs("payment-1", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(duplicate)
+		mock.ExpectExec("UPDATE inbox SET token = .* AND locked_until < ?").WillReturnResult(sqlmock.NewResult(0, 1))
+		token, err := store.Begin(ctx, "payment-1", time.Minute)
+		if err != nil || token == "" {
+			t.Errorf("\tShould take over an expired claim. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould take over an expired claim. %v", checkMark)
+		}
+
+		mock.ExpectExec("INSERT INTO inbox").WillReturnError(duplicate)
+		mock.ExpectExec("UPDATE inbox SET token").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT done FROM inbox").WithArgs("payment-1").WillReturnRows(sqlmock.NewRows([]string{"done"}).AddRow(false))
+		if _, err := store.Begin(ctx, "payment-1", time.Minute); !errors.Is(err, ErrInProgress) {
+			t.Errorf("\tShould refuse a claim which did not expire. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould refuse a claim which did not expire. %v", checkMark)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE inbox SET done = 1.* WHERE id = \\? AND done = 0").WithArgs(sqlmock.AnyArg(), "payment-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("\tShould begin a transaction. %v %v", ballotX, err)
+		}
+		if err := store.CompleteTx(ctx, tx, "payment-1"); err != nil {
+			t.Errorf("\tShould complete the claim within the transaction. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould complete the claim within the transaction. %v", checkMark)
+		}
+		_ = tx.Commit()
+
+		mock.ExpectExec("UPDATE inbox SET done = 1.* AND token = \\? AND done = 0").WithArgs(sqlmock.AnyArg(), "payment-1", token).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT token FROM inbox").WithArgs("payment-1").WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(token))
+		if err := store.Complete(ctx, "payment-1", token); err != nil {
+			t.Errorf("\tShould accept a claim completed within the transaction of its owner. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould accept a claim completed within the transaction of its owner. %v", checkMark)
+		}
+
+		mock.ExpectExec("UPDATE inbox SET done = 1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT token FROM inbox").WillReturnRows(sqlmock.NewRows([]string{"token"}).AddRow(token))
+		if err := store.Complete(ctx, "payment-1", "expired"); !errors.Is(err, ErrClaimLost) {
+			t.Errorf("\tShould not complete a claim taken over. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould not complete a claim taken over. %v", checkMark)
+		}
+
+		t.Log("\tWhen another consumer completed the claim")
+		{
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE inbox SET done = 1").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT done FROM inbox").WillReturnRows(sqlmock.NewRows([]string{"done"}).AddRow(true))
+			mock.ExpectRollback()
+			tx, err := conn.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatalf("\t\tShould begin a transaction. %v %v", ballotX, err)
+			}
+			if err := store.CompleteTx(ctx, tx, "payment-1"); !errors.Is(err, ErrDuplicate) {
+				t.Errorf("\t\tShould fail the transaction of a duplicate. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould fail the transaction of a duplicate. %v", checkMark)
+			}
+			_ = tx.Rollback()
+		}
+
+		mock.ExpectExec("DELETE FROM inbox WHERE id = \\? AND token = \\? AND done = 0").WithArgs("payment-2", "owner").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if err := store.Abort(ctx, "payment-2", "owner"); err != nil {
+			t.Errorf("\tShould release the claim of its owner. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould release the claim of its owner. %v", checkMark)
+		}
+
+		mock.ExpectExec("DELETE FROM inbox WHERE done = 1 AND processed_at < ?").WillReturnResult(sqlmock.NewResult(0, 3))
+		if purged, err := store.Purge(ctx, time.Now()); err != nil || purged != 3 {
+			t.Errorf("\tShould purge the processed IDs. %v %v %v", ballotX, purged, err)
+		} else {
+			t.Logf("\tShould purge the processed IDs. %v", checkMark)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("\tShould run the expected statements. %v %v", ballotX, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"lib/cache"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// stateDone is the value of the processed IDs, the claimed ones hold the
+// token of their owner
+const stateDone = "done"
+
+var (
+	// beginScript claims the ID and returns 1, or 2 when it was processed
+	// and 0 while it is claimed
+	beginScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+if redis.call("GET", KEYS[1]) == ARGV[3] then
+	return 2
+end
+return 0`)
+
+	// completeScript marks the ID processed unless the claim of the caller
+	// was taken over, an expired claim nobody took over is completed
+	completeScript = redis.NewScript(`
+local state = redis.call("GET", KEYS[1])
+if state == ARGV[3] then
+	return 1
+end
+if not state or state == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[3], "PX", ARGV[2])
+	return 1
+end
+return 0`)
+
+	// abortScript deletes the claim only if it is still owned by the caller
+	abortScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+type redisStore struct {
+	helper    cache.CacheScripting
+	prefix    string
+	retention time.Duration
+}
+
+// NewRedisStore records the processed IDs under prefix for retention, which
+// must be longer than the redeliveries of the broker may happen, helper must
+// be a redis helper returned by cache.NewCacheHelper
+func NewRedisStore(helper cache.CacheHelper, prefix string, retention time.Duration) (Store, error) {
+	scripting, ok := helper.(cache.CacheScripting)
+	if !ok {
+		return nil, errors.New("cache helper does not support scripting")
+	}
+	return &redisStore{
+		helper:    scripting,
+		prefix:    prefix,
+		retention: retention,
+	}, nil
+}
+
+func (s *redisStore) Begin(ctx context.Context, id string, lease time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.helper.EvalScript(ctx, beginScript, []string{s.key(id)}, token, lease.Milliseconds(), stateDone)
+	if err != nil {
+		return "", err
+	}
+	switch result {
+	case int64(1):
+		return token, nil
+	case int64(2):
+		return "", ErrDuplicate
+	default:
+		return "", ErrInProgress
+	}
+}
+
+func (s *redisStore) Complete(ctx context.Context, id, token string) error {
+	result, err := s.helper.EvalScript(ctx, completeScript, []string{s.key(id)}, token, s.retention.Milliseconds(), stateDone)
+	if err != nil {
+		return err
+	}
+	if result != int64(1) {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+func (s *redisStore) Abort(ctx context.Context, id, token string) error {
+	_, err := s.helper.EvalScript(ctx, abortScript, []string{s.key(id)}, token)
+	return err
+}
+
+func (s *redisStore) key(id string) string {
+	return s.prefix + ":" + id
+}
@@ -3,6 +3,7 @@ package interceptor
 import (
 	"context"
 	"lib/common"
+	"lib/jwt/model"
 	"regexp"
 	"strings"
 
@@ -16,6 +17,16 @@ const (
 	patternStr = "^Bearer (\\S*)"
 )
 
+// claimKey is the context key of the verified claim
+type claimKey struct{}
+
+// ClaimFromContext returns the claim verified by JWTAuthenticationInterceptor,
+// unlike the user_id and domain metadata it cannot be set by the caller
+func ClaimFromContext(ctx context.Context) (model.JWTToken, bool) {
+	claim, ok := ctx.Value(claimKey{}).(model.JWTToken)
+	return claim, ok
+}
+
 func checkContains(arr []string, in string) bool {
 	for _, item := range arr {
 		if strings.Contains(in, item) {
@@ -47,7 +58,9 @@ func JWTAuthenticationInterceptor(jwtAdapter JWTAdapter, excludePaths []string)
 					claim, err := jwtAdapter.VerifyToken(ctx, jwt, "bim", "")
 					if err == nil {
 						md[common.DomainMDKey] = []string{claim.Domain}
+						md[common.UserIDMDKey] = []string{claim.UserID}
 						ctx = metadata.NewIncomingContext(ctx, md)
+						ctx = context.WithValue(ctx, claimKey{}, claim)
 						return handler(ctx, req)
 					}
 					// reason := common.ParseError(err)
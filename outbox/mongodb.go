@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"context"
+	"encoding/base64"
+	"lib/common"
+	"lib/db"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type (
+	// DocumentOutbox represents an outbox collection.
+	//
+	// Write is a dual write: NoSQLDBHelper has no transactions, so the event
+	// is not written atomically with the change it describes. An event is
+	// lost when the process stops between the change and Write, or when
+	// Write fails and the caller does not retry it. Use the SQLOutbox when
+	// the events must not be lost
+	DocumentOutbox interface {
+		Store
+		// Write adds event to the outbox, it must be called right after the
+		// change the event describes succeeded and retried when it fails
+		Write(ctx context.Context, event Event) error
+	}
+
+	// Document represents an event of the outbox collection, times are
+	// stored as unix milliseconds
+	Document struct {
+		ID            string            `bson:"_id"`
+		Key           string            `bson:"key"`
+		Payload       string            `bson:"payload"`
+		Headers       map[string]string `bson:"headers,omitempty"`
+		Status        int               `bson:"status"`
+		Attempts      int               `bson:"attempts"`
+		NextAttemptAt int64             `bson:"next_attempt_at"`
+		LastError     string            `bson:"last_error,omitempty"`
+		CreatedAt     int64             `bson:"created_at"`
+		SentAt        int64             `bson:"sent_at,omitempty"`
+	}
+
+	mongoOutbox struct {
+		helper db.NoSQLDBHelper
+	}
+)
+
+// NewMongoOutbox creates an outbox stored in the collection of helper, it
+// must be created by db.NewMongoDBHelper with Document{} as template object.
+// Unlike the SQLOutbox its events are not written with the change they
+// describe, see DocumentOutbox
+func NewMongoOutbox(helper db.NoSQLDBHelper) DocumentOutbox {
+	return &mongoOutbox{
+		helper: helper,
+	}
+}
+
+func (o *mongoOutbox) Write(ctx context.Context, event Event) error {
+	// the counter of an object ID orders the events written within a
+	// millisecond
+	now := time.Now()
+	_, err := o.helper.Create(Document{
+		ID:            bson.NewObjectId().Hex(),
+		Key:           event.Key,
+		Payload:       base64.StdEncoding.EncodeToString(event.Payload),
+		Headers:       event.Headers,
+		Status:        statusPending,
+		NextAttemptAt: now.UnixMilli(),
+		CreatedAt:     now.UnixMilli(),
+	})
+	return err
+}
+
+// Claim leases the events one by one since a find and modify updates a
+// single document
+func (o *mongoOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]Record, error) {
+	// the keys of the events leased or waiting for a retry are held back
+	now := time.Now()
+	keys := []string{}
+	if err := o.helper.Distinct(bson.M{
+		"status":          statusPending,
+		"next_attempt_at": bson.M{"$gt": now.UnixMilli()},
+		"key":             bson.M{"$ne": ""},
+	}, "key", &keys); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	query := bson.M{
+		"status":          statusPending,
+		"next_attempt_at": bson.M{"$lte": now.UnixMilli()},
+		"key":             bson.M{"$nin": keys},
+	}
+
+	var records []Record
+	for len(records) < limit {
+		result, err := o.helper.UpdateOneSort(query, []string{"created_at", "_id"}, bson.M{
+			"next_attempt_at": now.Add(lease).UnixMilli(),
+		})
+		if isNotFound(err) {
+			break
+		}
+		if err != nil {
+			return records, err
+		}
+
+		documents, ok := result.([]Document)
+		if !ok || len(documents) == 0 {
+			break
+		}
+		payload, err := base64.StdEncoding.DecodeString(documents[0].Payload)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, Record{
+			ID: documents[0].ID,
+			Event: Event{
+				Key:     documents[0].Key,
+				Payload: payload,
+				Headers: documents[0].Headers,
+			},
+			Attempts: documents[0].Attempts,
+		})
+	}
+	return records, nil
+}
+
+func (o *mongoOutbox) Release(ctx context.Context, ids ...string) error {
+	return o.update(ids, bson.M{"next_attempt_at": time.Now().UnixMilli()})
+}
+
+func (o *mongoOutbox) MarkSent(ctx context.Context, ids ...string) error {
+	return o.update(ids, bson.M{"status": statusSent, "sent_at": time.Now().UnixMilli()})
+}
+
+func (o *mongoOutbox) MarkFailed(ctx context.Context, record Record, retryAt time.Time, cause error) error {
+	return o.update([]string{record.ID}, bson.M{
+		"attempts":        record.Attempts,
+		"next_attempt_at": retryAt.UnixMilli(),
+		"last_error":      cause.Error(),
+	})
+}
+
+func (o *mongoOutbox) MarkDead(ctx context.Context, record Record, cause error) error {
+	return o.update([]string{record.ID}, bson.M{
+		"status":     statusDead,
+		"attempts":   record.Attempts,
+		"last_error": cause.Error(),
+	})
+}
+
+func (o *mongoOutbox) update(ids []string, updater bson.M) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := o.helper.Update(bson.M{"_id": bson.M{"$in": ids}}, updater)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	return err != nil && err.Error() == common.ReasonNotFound.Code()
+}
@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"lib/db"
+	"strings"
+	"time"
+)
+
+const mysqlSchema = `CREATE TABLE IF NOT EXISTS %s (
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	event_id CHAR(32) NOT NULL,
+	event_key VARCHAR(255) NOT NULL,
+	payload MEDIUMBLOB NOT NULL,
+	headers TEXT NULL,
+	status TINYINT NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME(6) NOT NULL,
+	last_error TEXT NULL,
+	created_at DATETIME(6) NOT NULL,
+	sent_at DATETIME(6) NULL,
+	UNIQUE KEY uk_%s_event (event_id),
+	KEY idx_%s_pending (status, next_attempt_at, id),
+	KEY idx_%s_key (event_key, status, id)
+)`
+
+type (
+	// SQLOutbox represents an outbox table written within the transactions of
+	// the caller
+	SQLOutbox interface {
+		Store
+		// EnsureTable creates the outbox table when it does not exist
+		EnsureTable(ctx context.Context) error
+		// Write adds event to the outbox within tx, it is published once tx
+		// is committed
+		Write(ctx context.Context, tx *sql.Tx, event Event) error
+	}
+
+	mysqlOutbox struct {
+		db    *sql.DB
+		table string
+	}
+)
+
+// NewMySQLOutbox creates an outbox stored in table of the database of
+// helper, claiming relies on SKIP LOCKED which requires MySQL 8. The events
+// of a key are claimed together by a single relay
+func NewMySQLOutbox(helper db.DBHelper, table string) SQLOutbox {
+	return &mysqlOutbox{
+		db:    helper.Open(),
+		table: table,
+	}
+}
+
+func (o *mysqlOutbox) EnsureTable(ctx context.Context) error {
+	_, err := o.db.ExecContext(ctx, fmt.Sprintf(mysqlSchema, o.table, o.table, o.table, o.table))
+	return err
+}
+
+func (o *mysqlOutbox) Write(ctx context.Context, tx *sql.Tx, event Event) error {
+	var headers interface{}
+	if len(event.Headers) > 0 {
+		data, err := json.Marshal(event.Headers)
+		if err != nil {
+			return err
+		}
+		headers = string(data)
+	}
+
+	// the auto increment orders the events of a table, the event ID is
+	// unique across the outboxes of the services
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (event_id, event_key, payload, headers, status, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)", o.table),
+		hex.EncodeToString(id), event.Key, event.Payload, headers, statusPending, now, now)
+	return err
+}
+
+func (o *mysqlOutbox) Claim(ctx context.Context, limit int, lease time.Duration) (records []Record, err error) {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// the keys are claimed as a whole through their oldest pending event, a
+	// relay claiming the same key concurrently skips its locked row. A key is
+	// held back while one of its events is leased or waits for a retry
+	now := time.Now().UTC()
+	heads, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT o.id, o.event_key FROM %s o WHERE o.status = ? AND o.next_attempt_at <= ? AND (o.event_key = '' OR ("+
+			"o.id = (SELECT MIN(h.id) FROM %s h WHERE h.event_key = o.event_key AND h.status = ?) "+
+			"AND NOT EXISTS (SELECT 1 FROM %s b WHERE b.event_key = o.event_key AND b.status = ? AND b.next_attempt_at > ?))) "+
+			"ORDER BY o.id LIMIT ? FOR UPDATE OF o SKIP LOCKED", o.table, o.table, o.table),
+		statusPending, now, statusPending, statusPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ids  []interface{}
+		keys []interface{}
+	)
+	for heads.Next() {
+		var (
+			id  int64
+			key string
+		)
+		if err = heads.Scan(&id, &key); err != nil {
+			heads.Close()
+			return nil, err
+		}
+		if key == "" {
+			ids = append(ids, id)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	heads.Close()
+	if err = heads.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 && len(keys) == 0 {
+		return nil, tx.Commit()
+	}
+
+	// the events of the claimed keys can only be locked by this relay
+	var conditions []string
+	args := []interface{}{statusPending}
+	if len(ids) > 0 {
+		conditions = append(conditions, fmt.Sprintf("id IN (%s)", placeholders(len(ids))))
+		args = append(args, ids...)
+	}
+	if len(keys) > 0 {
+		conditions = append(conditions, fmt.Sprintf("event_key IN (%s)", placeholders(len(keys))))
+		args = append(args, keys...)
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		"SELECT event_id, event_key, payload, headers, attempts FROM %s WHERE status = ? AND (%s) ORDER BY id LIMIT ? FOR UPDATE",
+		o.table, strings.Join(conditions, " OR ")), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			record  Record
+			headers sql.NullString
+		)
+		if err = rows.Scan(&record.ID, &record.Event.Key, &record.Event.Payload, &headers, &record.Attempts); err != nil {
+			return nil, err
+		}
+		if headers.Valid {
+			if err = json.Unmarshal([]byte(headers.String), &record.Event.Headers); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claimed := make([]string, len(records))
+	for index, record := range records {
+		claimed[index] = record.ID
+	}
+	if err = o.update(ctx, tx, "next_attempt_at = ?", claimed, now.Add(lease)); err != nil {
+		return nil, err
+	}
+	return records, tx.Commit()
+}
+
+func (o *mysqlOutbox) Release(ctx context.Context, ids ...string) error {
+	return o.update(ctx, o.db, "next_attempt_at = ?", ids, time.Now().UTC())
+}
+
+func (o *mysqlOutbox) MarkSent(ctx context.Context, ids ...string) error {
+	return o.update(ctx, o.db, "status = ?, sent_at = ?", ids, statusSent, time.Now().UTC())
+}
+
+func (o *mysqlOutbox) MarkFailed(ctx context.Context, record Record, retryAt time.Time, cause error) error {
+	return o.update(ctx, o.db, "attempts = ?, next_attempt_at = ?, last_error = ?", []string{record.ID},
+		record.Attempts, retryAt.UTC(), cause.Error())
+}
+
+func (o *mysqlOutbox) MarkDead(ctx context.Context, record Record, cause error) error {
+	return o.update(ctx, o.db, "status = ?, attempts = ?, last_error = ?", []string{record.ID},
+		statusDead, record.Attempts, cause.Error())
+}
+
+// update sets the columns of the events ids, args are the values of set
+func (o *mysqlOutbox) update(ctx context.Context, execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}, set string, ids []string, args ...interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := execer.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE event_id IN (%s)", o.table, set, placeholders(len(ids))), args...)
+	return err
+}
+
+// placeholders returns the placeholders of n arguments
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,67 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// TestMySQLClaim validates the events are claimed by whole keys through the
+// oldest event of each key
+func TestMySQLClaim(t *testing.T) {
+	ctx := context.Background()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Should be able to open the database. %v %v", ballotX, err)
+	}
+	defer conn.Close()
+	store := &mysqlOutbox{db: conn, table: "outbox"}
+
+	t.Log("Given the need to claim the events of a key with a single relay")
+	{
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT o.id, o.event_key FROM outbox o .*SELECT MIN\\(h.id\\) .* FOR UPDATE OF o SKIP LOCKED").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "event_key"}).AddRow(1, "order-1").AddRow(2, ""))
+		mock.ExpectQuery("SELECT event_id, event_key, payload, headers, attempts FROM outbox WHERE status = \\? AND \\(id IN \\(\\?\\) OR event_key IN \\(\\?\\)\\) ORDER BY id LIMIT \\? FOR UPDATE$").
+			WithArgs(statusPending, int64(2), "order-1", 10).
+			WillReturnRows(sqlmock.NewRows([]string{"event_id", "event_key", "payload", "headers", "attempts"}).
+				AddRow("a", "order-1", []byte("created"), nil, 0).
+				AddRow("b", "", []byte("audit"), nil, 0).
+				AddRow("c", "order-1", []byte("paid"), `{"source":"checkout"}`, 1))
+		mock.ExpectExec("UPDATE outbox SET next_attempt_at = \\? WHERE event_id IN \\(\\?, \\?, \\?\\)").
+			WithArgs(sqlmock.AnyArg(), "a", "b", "c").WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectCommit()
+
+		records, err := store.Claim(ctx, 10, time.Minute)
+		if err != nil || len(records) != 3 {
+			t.Fatalf("\tShould claim the events of the keys. %v %d %v", ballotX, len(records), err)
+		}
+		if records[0].ID != "a" || records[2].ID != "c" || records[2].Event.Headers["source"] != "checkout" || records[2].Attempts != 1 {
+			t.Errorf("\tShould claim the events of the keys in order. %v %+v", ballotX, records)
+		} else {
+			t.Logf("\tShould claim the events of the keys in order. %v", checkMark)
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT o.id, o.event_key FROM outbox o").WillReturnRows(sqlmock.NewRows([]string{"id", "event_key"}))
+		mock.ExpectCommit()
+		if records, err := store.Claim(ctx, 10, time.Minute); err != nil || len(records) != 0 {
+			t.Errorf("\tShould claim nothing when every key is held back. %v %d %v", ballotX, len(records), err)
+		} else {
+			t.Logf("\tShould claim nothing when every key is held back. %v", checkMark)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("\tShould run the expected queries. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould run the expected queries. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"time"
+)
+
+// HeaderEventID carries the ID of an event, unique across the outboxes, so
+// consumers can drop the duplicates of an at least once delivery
+const HeaderEventID = "x-event-id"
+
+// status of an event
+const (
+	statusPending = iota
+	statusSent
+	statusDead
+)
+
+type (
+	// Event represents a message to publish once the transaction writing it
+	// is committed
+	Event struct {
+		Key     string
+		Payload []byte
+		Headers map[string]string
+	}
+
+	// Record represents an event claimed by a relay
+	Record struct {
+		// ID identifies the event across the outboxes
+		ID    string
+		Event Event
+		// Attempts counts the failed publications of the event
+		Attempts int
+	}
+
+	// Store represents the storage of the outbox used by the relay
+	Store interface {
+		// Claim leases up to limit pending events, in the order they were
+		// written, to the caller for lease. The events of a key are not
+		// claimed while an older event of the key is leased or waits for a
+		// retry, events without key are never held back
+		Claim(ctx context.Context, limit int, lease time.Duration) ([]Record, error)
+		// Release returns claimed events to the pending ones
+		Release(ctx context.Context, ids ...string) error
+		MarkSent(ctx context.Context, ids ...string) error
+		// MarkFailed records a failed publication, the event is claimable
+		// again at retryAt
+		MarkFailed(ctx context.Context, record Record, retryAt time.Time, cause error) error
+		// MarkDead gives up on an event
+		MarkDead(ctx context.Context, record Record, cause error) error
+	}
+)
@@ -0,0 +1,207 @@
+package outbox
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"lib/pubsub"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultLease        = 30 * time.Second
+	defaultBackoff      = time.Second
+	defaultMaxBackoff   = time.Minute
+)
+
+type (
+	// RelayOption represents option of the relay
+	RelayOption func(*Relay)
+
+	// Relay publishes the events of an outbox, an event is published at least
+	// once and duplicates carry the same HeaderEventID
+	Relay struct {
+		store        Store
+		publisher    pubsub.IPublisher
+		pollInterval time.Duration
+		batchSize    int
+		lease        time.Duration
+		backoff      time.Duration
+		maxBackoff   time.Duration
+		maxAttempts  int
+	}
+)
+
+// WithPollInterval sets how long the relay waits when the outbox is drained
+func WithPollInterval(interval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets how many events are claimed at once
+func WithBatchSize(size int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = size
+	}
+}
+
+// WithLease sets how long claimed events are reserved to the relay, the
+// events of a relay which crashed are claimed again once their lease expired
+func WithLease(lease time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.lease = lease
+	}
+}
+
+// WithRetryBackoff retries failed events after backoff doubled at every
+// attempt up to maxBackoff
+func WithRetryBackoff(backoff, maxBackoff time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.backoff = backoff
+		r.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxAttempts gives up on events failing maxAttempts times, events are
+// retried forever by default
+func WithMaxAttempts(maxAttempts int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = maxAttempts
+	}
+}
+
+// NewRelay creates a relay publishing the events of store through publisher
+func NewRelay(store Store, publisher pubsub.IPublisher, opts ...RelayOption) *Relay {
+	relay := &Relay{
+		store:        store,
+		publisher:    publisher,
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultBatchSize,
+		lease:        defaultLease,
+		backoff:      defaultBackoff,
+		maxBackoff:   defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(relay)
+	}
+	return relay
+}
+
+// Run relays the events until ctx is done. Events are published in the order
+// they were written, a failed event is retried after the following ones of
+// the other keys
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		count, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			zap.S().Warnw("Failed to relay outbox events", "error", err)
+		}
+		// keep draining while batches are full
+		if err == nil && count == r.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// RelayOnce publishes a batch of pending events and returns how many were claimed
+func (r *Relay) RelayOnce(ctx context.Context) (count int, err error) {
+	span := jaeger.Start(ctx, ">outbox.Relay/RelayOnce", ext.SpanKindProducer)
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	records, err := r.store.Claim(ctx, r.batchSize, r.lease)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		sent      = make([]string, 0, len(records))
+		failed    = map[string]bool{}
+		postponed []string
+	)
+	for index, record := range records {
+		if ctx.Err() != nil {
+			// hand the remaining events to the next claim
+			ids := make([]string, 0, len(records)-index)
+			for _, record := range records[index:] {
+				ids = append(ids, record.ID)
+			}
+			if err := r.store.Release(context.Background(), ids...); err != nil {
+				zap.S().Warnw("Failed to release outbox events", "error", err)
+			}
+			break
+		}
+
+		// the events of a key are published in order, the ones following a
+		// failed event wait for it to be published
+		if record.Event.Key != "" && failed[record.Event.Key] {
+			postponed = append(postponed, record.ID)
+			continue
+		}
+		if err := r.publish(ctx, record); err != nil {
+			r.fail(record, err)
+			failed[record.Event.Key] = true
+			continue
+		}
+		sent = append(sent, record.ID)
+	}
+	// the failed event keeps its key from being claimed until it is published
+	if err := r.store.Release(context.Background(), postponed...); err != nil {
+		zap.S().Warnw("Failed to release outbox events", "error", err)
+	}
+
+	// the events are published, they must be marked even if ctx is done
+	return len(records), r.store.MarkSent(context.Background(), sent...)
+}
+
+func (r *Relay) publish(ctx context.Context, record Record) error {
+	headers := make(map[string]string, len(record.Event.Headers)+1)
+	for key, value := range record.Event.Headers {
+		headers[key] = value
+	}
+	headers[HeaderEventID] = record.ID
+
+	return r.publisher.SendMessage(ctx, &pubsub.Message{
+		Key:     record.Event.Key,
+		Value:   record.Event.Payload,
+		Headers: headers,
+	})
+}
+
+func (r *Relay) fail(record Record, cause error) {
+	record.Attempts++
+	if r.maxAttempts > 0 && record.Attempts >= r.maxAttempts {
+		zap.S().Errorw("Gave up on outbox event", "id", record.ID, "attempts", record.Attempts, "error", cause)
+		if err := r.store.MarkDead(context.Background(), record, cause); err != nil {
+			zap.S().Warnw("Failed to mark outbox event dead", "id", record.ID, "error", err)
+		}
+		return
+	}
+
+	delay := r.backoff
+	for attempt := 1; attempt < record.Attempts && delay < r.maxBackoff; attempt++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+
+	zap.S().Warnw("Failed to publish outbox event", "id", record.ID, "attempts", record.Attempts, "retry_in", delay, "error", cause)
+	if err := r.store.MarkFailed(context.Background(), record, time.Now().Add(delay), cause); err != nil {
+		zap.S().Warnw("Failed to mark outbox event failed", "id", record.ID, "error", err)
+	}
+}
@@ -0,0 +1,218 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"lib/outbox"
+	"lib/pubsub"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+type (
+	// memoryStore keeps the outbox in memory
+	memoryStore struct {
+		mu      sync.Mutex
+		events  []outbox.Event
+		sent    map[string]bool
+		dead    map[string]bool
+		retryAt map[string]time.Time
+		tries   map[string]int
+	}
+
+	// flakyPublisher fails the first publication of the keys of failures
+	flakyPublisher struct {
+		failures  map[string]bool
+		published []*pubsub.Message
+	}
+)
+
+func newMemoryStore(events ...outbox.Event) *memoryStore {
+	return &memoryStore{
+		events:  events,
+		sent:    map[string]bool{},
+		dead:    map[string]bool{},
+		retryAt: map[string]time.Time{},
+		tries:   map[string]int{},
+	}
+}
+
+func (s *memoryStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]outbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		records []outbox.Record
+		blocked = map[string]bool{}
+	)
+	for index, event := range s.events {
+		id := strconv.Itoa(index)
+		if s.sent[id] || s.dead[id] || blocked[event.Key] {
+			continue
+		}
+		if s.retryAt[id].After(time.Now()) {
+			// hold back the following events of the key
+			blocked[event.Key] = event.Key != ""
+			continue
+		}
+		if len(records) == limit {
+			continue
+		}
+		s.retryAt[id] = time.Now().Add(lease)
+		records = append(records, outbox.Record{ID: id, Event: event, Attempts: s.tries[id]})
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.retryAt, id)
+	}
+	return nil
+}
+
+func (s *memoryStore) MarkSent(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		s.sent[id] = true
+	}
+	return nil
+}
+
+func (s *memoryStore) MarkFailed(ctx context.Context, record outbox.Record, retryAt time.Time, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tries[record.ID] = record.Attempts
+	s.retryAt[record.ID] = retryAt
+	return nil
+}
+
+func (s *memoryStore) MarkDead(ctx context.Context, record outbox.Record, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tries[record.ID] = record.Attempts
+	s.dead[record.ID] = true
+	return nil
+}
+
+func (p *flakyPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &pubsub.Message{Key: key, Value: data})
+}
+
+func (p *flakyPublisher) SendMessage(ctx context.Context, message *pubsub.Message) error {
+	if p.failures[message.Key] {
+		delete(p.failures, message.Key)
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, message)
+	return nil
+}
+
+func (p *flakyPublisher) SendBatch(ctx context.Context, messages []*pubsub.Message) error {
+	for _, message := range messages {
+		if err := p.SendMessage(ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *flakyPublisher) Close() error {
+	return nil
+}
+
+// TestRelay validates events are published once and failures are retried
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(
+		outbox.Event{Key: "order-1", Payload: []byte("created")},
+		outbox.Event{Key: "order-2", Payload: []byte("created")},
+	)
+	publisher := &flakyPublisher{failures: map[string]bool{"order-2": true}}
+	relay := outbox.NewRelay(store, publisher, outbox.WithRetryBackoff(time.Millisecond, time.Millisecond))
+
+	t.Log("Given the need to publish the events of an outbox")
+	{
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("\tShould relay the events. %v %v", ballotX, err)
+		}
+		if len(publisher.published) != 1 || !store.sent["0"] || store.sent["1"] || store.tries["1"] != 1 {
+			t.Errorf("\tShould mark the published event sent and the failed one for retry. %v %v", ballotX, store.tries)
+		} else {
+			t.Logf("\tShould mark the published event sent and the failed one for retry. %v", checkMark)
+		}
+		if publisher.published[0].Headers[outbox.HeaderEventID] != "0" {
+			t.Errorf("\tShould publish the event ID. %v %v", ballotX, publisher.published[0].Headers)
+		} else {
+			t.Logf("\tShould publish the event ID. %v", checkMark)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("\tShould relay the events. %v %v", ballotX, err)
+		}
+		if len(publisher.published) != 2 || !store.sent["1"] {
+			t.Errorf("\tShould publish the failed event once its backoff elapsed. %v %v", ballotX, len(publisher.published))
+		} else {
+			t.Logf("\tShould publish the failed event once its backoff elapsed. %v", checkMark)
+		}
+	}
+}
+
+// TestRelayKeyOrder validates the events of a key are published in the order
+// they were written when one of them fails
+func TestRelayKeyOrder(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(
+		outbox.Event{Key: "order-1", Payload: []byte("created")},
+		outbox.Event{Key: "order-2", Payload: []byte("created")},
+		outbox.Event{Key: "order-1", Payload: []byte("paid")},
+	)
+	publisher := &flakyPublisher{failures: map[string]bool{"order-1": true}}
+	relay := outbox.NewRelay(store, publisher, outbox.WithRetryBackoff(50*time.Millisecond, 50*time.Millisecond))
+
+	t.Log("Given the need to publish the events of a key in order")
+	{
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("\tShould relay the events. %v %v", ballotX, err)
+		}
+		if len(publisher.published) != 1 || publisher.published[0].Key != "order-2" || store.sent["2"] {
+			t.Errorf("\tShould not publish the events following a failed one of the same key. %v %v", ballotX, len(publisher.published))
+		} else {
+			t.Logf("\tShould not publish the events following a failed one of the same key. %v", checkMark)
+		}
+
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("\tShould relay the events. %v %v", ballotX, err)
+		}
+		if len(publisher.published) != 1 {
+			t.Errorf("\tShould hold back the key until the failed event is retried. %v %v", ballotX, len(publisher.published))
+		} else {
+			t.Logf("\tShould hold back the key until the failed event is retried. %v", checkMark)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if _, err := relay.RelayOnce(ctx); err != nil {
+			t.Fatalf("\tShould relay the events. %v %v", ballotX, err)
+		}
+		var payloads []string
+		for _, message := range publisher.published[1:] {
+			payloads = append(payloads, string(message.Value))
+		}
+		if len(payloads) != 2 || payloads[0] != "created" || payloads[1] != "paid" {
+			t.Errorf("\tShould publish the events of the key in order once retried. %v %v", ballotX, payloads)
+		} else {
+			t.Logf("\tShould publish the events of the key in order once retried. %v", checkMark)
+		}
+	}
+}
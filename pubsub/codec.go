@@ -0,0 +1,286 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/linkedin/goavro/v2"
+)
+
+// Codec represents the way a message value is encoded along with the schema
+// registered for it
+type Codec interface {
+	// Name is written in the content type header of the messages
+	Name() string
+	// Schema describes the type of value
+	Schema(value interface{}) (string, error)
+	// Compatible returns an error when data written with previous can not be
+	// read with schema
+	Compatible(schema, previous string) error
+	Marshal(schema string, value interface{}) ([]byte, error)
+	// Unmarshal decodes data written with the writer schema
+	Unmarshal(schema string, data []byte, value interface{}) error
+}
+
+var (
+	// JSONCodec encodes values as JSON, its schema lists the fields of structs
+	// and evolutions changing the type of a field are rejected
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec encodes values implementing proto.Message, its schema is
+	// the message name which must not change
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+type (
+	jsonCodec struct{}
+
+	protobufCodec struct{}
+
+	avroCodec struct {
+		schema string
+		codecs sync.Map
+	}
+)
+
+func (jsonCodec) Name() string {
+	return "application/json"
+}
+
+func (jsonCodec) Schema(value interface{}) (string, error) {
+	t := reflect.TypeOf(value)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Sprintf("%q", fmt.Sprint(t)), nil
+	}
+
+	fields := make(map[string]string)
+	for index := 0; index < t.NumField(); index++ {
+		field := t.Field(index)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag = strings.Split(tag, ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+		}
+		fields[name] = field.Type.String()
+	}
+	schema, err := json.Marshal(fields)
+	return string(schema), err
+}
+
+func (jsonCodec) Compatible(schema, previous string) error {
+	var fields, previousFields map[string]string
+	if json.Unmarshal([]byte(schema), &fields) != nil || json.Unmarshal([]byte(previous), &previousFields) != nil {
+		if schema != previous {
+			return fmt.Errorf("type changed from %s to %s", previous, schema)
+		}
+		return nil
+	}
+	for name, previousType := range previousFields {
+		if fieldType, ok := fields[name]; ok && fieldType != previousType {
+			return fmt.Errorf("type of field %s changed from %s to %s", name, previousType, fieldType)
+		}
+	}
+	return nil
+}
+
+func (jsonCodec) Marshal(_ string, value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(_ string, data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+func (protobufCodec) Name() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Schema(value interface{}) (string, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return "", errors.New("value does not implement proto.Message")
+	}
+	return proto.MessageName(message), nil
+}
+
+func (protobufCodec) Compatible(schema, previous string) error {
+	if schema != previous {
+		return fmt.Errorf("message changed from %s to %s", previous, schema)
+	}
+	return nil
+}
+
+func (protobufCodec) Marshal(_ string, value interface{}) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, errors.New("value does not implement proto.Message")
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(_ string, data []byte, value interface{}) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return errors.New("value does not implement proto.Message")
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// NewAvroCodec encodes values with the Avro schema, values are converted
+// through their JSON representation so unions must follow the Avro JSON
+// encoding. Evolutions are accepted when new fields have a default and
+// existing fields keep their type or are promoted
+func NewAvroCodec(schema string) (Codec, error) {
+	codec := &avroCodec{
+		schema: schema,
+	}
+	if _, err := codec.codec(schema); err != nil {
+		return nil, err
+	}
+	return codec, nil
+}
+
+func (c *avroCodec) Name() string {
+	return "application/avro"
+}
+
+func (c *avroCodec) Schema(interface{}) (string, error) {
+	return c.schema, nil
+}
+
+func (c *avroCodec) Compatible(schema, previous string) error {
+	if _, err := c.codec(schema); err != nil {
+		return err
+	}
+	if _, err := c.codec(previous); err != nil {
+		return err
+	}
+
+	var record, previousRecord avroRecord
+	if json.Unmarshal([]byte(schema), &record) != nil || json.Unmarshal([]byte(previous), &previousRecord) != nil ||
+		record.Type != "record" || previousRecord.Type != "record" {
+		if !avroPromotable(previous, schema) {
+			return fmt.Errorf("type changed from %s to %s", previous, schema)
+		}
+		return nil
+	}
+
+	previousFields := make(map[string]avroField, len(previousRecord.Fields))
+	for _, field := range previousRecord.Fields {
+		previousFields[field.Name] = field
+	}
+	for _, field := range record.Fields {
+		previousField, ok := previousFields[field.Name]
+		if !ok {
+			if field.Default == nil {
+				return fmt.Errorf("new field %s has no default", field.Name)
+			}
+			continue
+		}
+		if !avroPromotable(string(previousField.Type), string(field.Type)) {
+			return fmt.Errorf("type of field %s changed from %s to %s", field.Name, previousField.Type, field.Type)
+		}
+	}
+	return nil
+}
+
+func (c *avroCodec) Marshal(schema string, value interface{}) ([]byte, error) {
+	codec, err := c.codec(schema)
+	if err != nil {
+		return nil, err
+	}
+	textual, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := codec.NativeFromTextual(textual)
+	if err != nil {
+		return nil, err
+	}
+	return codec.BinaryFromNative(nil, native)
+}
+
+func (c *avroCodec) Unmarshal(schema string, data []byte, value interface{}) error {
+	codec, err := c.codec(schema)
+	if err != nil {
+		return err
+	}
+	native, _, err := codec.NativeFromBinary(data)
+	if err != nil {
+		return err
+	}
+	textual, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(textual, value)
+}
+
+// codec returns the parsed schema, writer schemas are cached by definition
+func (c *avroCodec) codec(schema string) (*goavro.Codec, error) {
+	if codec, ok := c.codecs.Load(schema); ok {
+		return codec.(*goavro.Codec), nil
+	}
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+	c.codecs.Store(schema, codec)
+	return codec, nil
+}
+
+type (
+	avroRecord struct {
+		Type   string      `json:"type"`
+		Fields []avroField `json:"fields"`
+	}
+
+	avroField struct {
+		Name    string          `json:"name"`
+		Type    json.RawMessage `json:"type"`
+		Default json.RawMessage `json:"default"`
+	}
+)
+
+// avroPromotable reports whether data of the writer type can be read as the
+// reader type
+func avroPromotable(writer, reader string) bool {
+	if compactJSON(writer) == compactJSON(reader) {
+		return true
+	}
+	promotions := map[string][]string{
+		`"int"`:    {`"long"`, `"float"`, `"double"`},
+		`"long"`:   {`"float"`, `"double"`},
+		`"float"`:  {`"double"`},
+		`"string"`: {`"bytes"`},
+		`"bytes"`:  {`"string"`},
+	}
+	for _, promoted := range promotions[compactJSON(writer)] {
+		if promoted == compactJSON(reader) {
+			return true
+		}
+	}
+	return false
+}
+
+func compactJSON(data string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return data
+	}
+	compact, _ := json.Marshal(value)
+	return string(compact)
+}
@@ -0,0 +1,95 @@
+package kafka
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+)
+
+// BatchMessageHandler handles messages of a partition in order, the batch is
+// committed at once when the handler returned nil. Retries and forwarding to
+// retry or dead-letter topics apply to the whole batch
+type BatchMessageHandler func(ctx context.Context, messages []*sarama.ConsumerMessage) error
+
+// consumeBatches accumulates the messages of claim until the batch is full or
+// its max wait elapsed
+func (consumer *Consumer) consumeBatches(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var (
+		batch    []*sarama.ConsumerMessage
+		timer    *time.Timer
+		deadline <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			consumer.received(message)
+			batch = append(batch, message)
+			if len(batch) == 1 {
+				timer = time.NewTimer(consumer.batchWait)
+				deadline = timer.C
+			}
+			if len(batch) < consumer.batchSize {
+				continue
+			}
+
+		case <-deadline:
+
+		case <-session.Context().Done():
+			return nil
+		}
+
+		timer.Stop()
+		deadline = nil
+		// the batch is left unmarked when the session ends while it is
+		// processed, the next owner of the partition receives it again
+		if err := consumer.processBatch(session, batch); err != nil {
+			return nil
+		}
+		batch = nil
+	}
+}
+
+// processBatch marks the last message of batch once the batch was handled or
+// forwarded after its failed attempts
+func (consumer *Consumer) processBatch(session sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) error {
+	ctx := session.Context()
+	first, last := batch[0], batch[len(batch)-1]
+	if err := consumer.waitRetryDelay(ctx, last); err != nil {
+		return err
+	}
+
+	// the batch continues the trace of its first message
+	spanCtx, span := consumer.startConsumerSpan(ctx, first, opentracing.Tag{Key: "kafka.batch_size", Value: len(batch)})
+	attempts, err := consumer.retry(ctx, first.Topic, func() error {
+		return consumer.batchHandler(spanCtx, batch)
+	}, "topic", first.Topic, "partition", first.Partition, "offset", first.Offset, "size", len(batch))
+	jaeger.Finish(span, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, message := range batch {
+			if err := consumer.forward(ctx, message, attempts, err); err != nil {
+				return err
+			}
+		}
+	}
+
+	session.MarkMessage(last, "")
+	if !consumer.autoCommit {
+		session.Commit()
+	}
+	return nil
+}
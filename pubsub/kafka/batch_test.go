@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// TestConsumeBatches validates batches are flushed when full or after their
+// max wait and committed at their last message
+func TestConsumeBatches(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]int64
+		flushed = make(chan struct{}, 10)
+	)
+	newConsumer := func(batchSize int, batchWait time.Duration) *Consumer {
+		return &Consumer{
+			groupID: "payments",
+			batchHandler: func(ctx context.Context, messages []*sarama.ConsumerMessage) error {
+				offsets := make([]int64, len(messages))
+				for index, message := range messages {
+					offsets[index] = message.Offset
+				}
+				mu.Lock()
+				batches = append(batches, offsets)
+				mu.Unlock()
+				flushed <- struct{}{}
+				return nil
+			},
+			batchSize: batchSize,
+			batchWait: batchWait,
+		}
+	}
+
+	t.Log("Given the need to handle messages by batches")
+	{
+		t.Log("\tWhen the batches are full")
+		{
+			session := newTestSession(context.Background())
+			claim := newTestClaim("orders", 0,
+				&sarama.ConsumerMessage{Offset: 0}, &sarama.ConsumerMessage{Offset: 1},
+				&sarama.ConsumerMessage{Offset: 2}, &sarama.ConsumerMessage{Offset: 3})
+			if err := newConsumer(2, time.Hour).ConsumeClaim(session, claim); err != nil {
+				t.Fatalf("\t\tShould consume the claim. %v %v", ballotX, err)
+			}
+
+			if len(batches) != 2 || len(batches[0]) != 2 || batches[1][1] != 3 {
+				t.Errorf("\t\tShould flush the batches by size. %v %v", ballotX, batches)
+			} else {
+				t.Logf("\t\tShould flush the batches by size. %v", checkMark)
+			}
+			if session.offset("orders", 0) != 4 || session.commits != 2 {
+				t.Errorf("\t\tShould commit the last message of every batch. %v %d %d", ballotX, session.offset("orders", 0), session.commits)
+			} else {
+				t.Logf("\t\tShould commit the last message of every batch. %v", checkMark)
+			}
+		}
+
+		t.Log("\tWhen no other message arrives within the max wait")
+		{
+			batches = nil
+			for len(flushed) > 0 {
+				<-flushed
+			}
+			session := newTestSession(context.Background())
+			claim := &testClaim{topic: "orders", messages: make(chan *sarama.ConsumerMessage, 1)}
+			claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: 9}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- newConsumer(10, 10*time.Millisecond).ConsumeClaim(session, claim)
+			}()
+			select {
+			case <-flushed:
+			case <-time.After(time.Second):
+				t.Fatalf("\t\tShould flush the batch after the max wait. %v", ballotX)
+			}
+			close(claim.messages)
+			<-done
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(batches) != 1 || len(batches[0]) != 1 || session.offset("orders", 0) != 10 {
+				t.Errorf("\t\tShould flush and commit the partial batch. %v %v", ballotX, batches)
+			} else {
+				t.Logf("\t\tShould flush and commit the partial batch. %v", checkMark)
+			}
+		}
+	}
+}
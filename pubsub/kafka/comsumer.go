@@ -4,25 +4,53 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"lib/opentracing/jaeger"
 	"log"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrConsumerNotStarted is reported by Health before Start succeeded
+	ErrConsumerNotStarted = errors.New("kafka: consumer group not started")
+	// ErrConsumerStarted is returned by Start when the group is already running
+	ErrConsumerStarted = errors.New("kafka: consumer group already started")
+	// ErrConsumerStopped is reported by Health once the group is stopped
+	ErrConsumerStopped = errors.New("kafka: consumer group stopped")
+)
+
+const (
+	consumeRetryDelay = time.Second
+	errorsBufferSize  = 64
+)
+
 type ConsumerGroup struct {
 	errorChan   chan error
 	brokers     []string
 	groupID     string
 	kafkaConfig *sarama.Config
 	consumer    Consumer
-	keepRunning chan bool
+	client      sarama.Client
 	cg          sarama.ConsumerGroup
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	closed  bool
+	lastErr error
+	cancel  context.CancelFunc
+	done    chan struct{}
 }
 
 // -brokers="127.0.0.1:9092" -topics="sarama" -group="example"
-func NewConsumerGroup(ctx context.Context, brokers []string, groupID string, kafkaConfigFn func() *sarama.Config, fnMessageHandler interface{}) *ConsumerGroup {
+func NewConsumerGroup(ctx context.Context, brokers []string, groupID string, kafkaConfigFn func() *sarama.Config, fnMessageHandler MessageHanlder, opts ...ConsumerOption) *ConsumerGroup {
 
 	sarama.Logger = log.New(os.Stdout, "[comsumer]", log.LstdFlags)
 
@@ -50,102 +78,412 @@ func NewConsumerGroup(ctx context.Context, brokers []string, groupID string, kaf
 	// }
 	config := kafkaConfigFn()
 
-	consumer := Consumer{
-		ready:   make(chan bool),
-		handler: fnMessageHandler.(MessageHanlder),
+	return newConsumerGroup(brokers, groupID, config, Consumer{
+		handler: fnMessageHandler,
+	}, opts...)
+}
+
+// NewBatchConsumerGroup creates a consumer group handing messages to
+// fnBatchHandler by batches of up to batchSize messages of a partition, or the
+// messages received within maxWait after the first one of the batch, both
+// must be positive. WithConcurrency does not apply to batches
+func NewBatchConsumerGroup(ctx context.Context, brokers []string, groupID string, kafkaConfigFn func() *sarama.Config, fnBatchHandler BatchMessageHandler, batchSize int, maxWait time.Duration, opts ...ConsumerOption) *ConsumerGroup {
+	if batchSize <= 0 || maxWait <= 0 {
+		zap.S().Panic(fmt.Errorf("invalid batch of %d messages within %v, both must be positive", batchSize, maxWait))
+		return nil
+	}
+
+	sarama.Logger = log.New(os.Stdout, "[comsumer]", log.LstdFlags)
+
+	config := kafkaConfigFn()
+
+	return newConsumerGroup(brokers, groupID, config, Consumer{
+		batchHandler: fnBatchHandler,
+		batchSize:    batchSize,
+		batchWait:    maxWait,
+	}, opts...)
+}
+
+func newConsumerGroup(brokers []string, groupID string, config *sarama.Config, consumer Consumer, opts ...ConsumerOption) *ConsumerGroup {
+	registerMetrics()
+
+	consumer.groupID = groupID
+	consumer.backoff = defaultRetryBackoff
+	consumer.maxBackoff = defaultRetryMaxBackoff
+	consumer.autoCommit = config.Consumer.Offsets.AutoCommit.Enable
+	consumer.lagInterval = defaultLagInterval
+	for _, opt := range opts {
+		opt(&consumer)
+	}
+	// the exactly once consumer forwards within its transactions
+	if consumer.forwarding() && consumer.processor == nil {
+		producer, err := sarama.NewSyncProducer(brokers, forwardConfig(config))
+		if err != nil {
+			zap.S().Panic(errors.New(fmt.Sprintf("Error creating retry producer: %v", err)))
+			return nil
+		}
+		consumer.producer = producer
 	}
 
+	// errors of the sessions are reported through Errors
+	config.Consumer.Return.Errors = true
+
 	cg := &ConsumerGroup{
-		errorChan:   make(chan error),
+		errorChan:   make(chan error, errorsBufferSize),
 		consumer:    consumer,
 		kafkaConfig: config,
 		brokers:     brokers,
 		groupID:     groupID,
-		keepRunning: make(chan bool),
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
 	}
+	cg.consumer.setup = cg.setup
 
-	cg.keepRunning <- true
-	client, err := sarama.NewConsumerGroup(cg.brokers, cg.groupID, cg.kafkaConfig)
+	client, err := sarama.NewClient(cg.brokers, cg.kafkaConfig)
 	if err != nil {
 		zap.S().Panic(errors.New(fmt.Sprintf("Error creating consumer group client: %v", err)))
 		return nil
 	}
+	group, err := sarama.NewConsumerGroupFromClient(cg.groupID, client)
+	if err != nil {
+		_ = client.Close()
+		zap.S().Panic(errors.New(fmt.Sprintf("Error creating consumer group client: %v", err)))
+		return nil
+	}
+	if consumer.lagInterval > 0 {
+		cg.consumer.lag = &lagMonitor{
+			groupID:  groupID,
+			source:   &saramaLagSource{client: client},
+			interval: consumer.lagInterval,
+		}
+	}
 
-	cg.cg = client
+	cg.client = client
+	cg.cg = group
+	go cg.forwardErrors()
 
 	return cg
 }
 
+// Subscribe starts consuming topics, see Start
 func (cg *ConsumerGroup) Subscribe(ctx context.Context, topics []string) error {
+	return cg.Start(ctx, topics)
+}
 
+// Start joins the group and consumes topics in background until ctx is done
+// or Stop is called. It returns once the first session is set up, or the
+// error preventing it
+func (cg *ConsumerGroup) Start(ctx context.Context, topics []string) error {
+	cg.mu.Lock()
+	if cg.started || cg.stopped {
+		cg.mu.Unlock()
+		return ErrConsumerStarted
+	}
+	cg.started = true
+	ctx, cg.cancel = context.WithCancel(ctx)
+	cg.mu.Unlock()
+
+	topics = append(topics, cg.consumer.retryTopicNames()...)
+	if cg.consumer.lag != nil {
+		go cg.consumer.lag.run(ctx)
+	}
+	failed := make(chan error, 1)
 	go func() {
+		defer close(cg.done)
 		for {
-			if ok := <-cg.keepRunning; !ok {
-				return
-			}
-
 			// `Consume` should be called inside an infinite loop, when a
 			// server-side rebalance happens, the consumer session will need to be
 			// recreated to get the new claims
-			if err := cg.cg.Consume(ctx, topics, &cg.consumer); err != nil {
-				cg.errorChan <- errors.New(fmt.Sprintf("Error from consumer: %v", err))
+			err := cg.cg.Consume(ctx, topics, &cg.consumer)
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) || ctx.Err() != nil {
 				return
 			}
+			if err != nil {
+				select {
+				case <-cg.ready:
+					cg.setErr(err)
+					cg.report(fmt.Errorf("error from consumer: %w", err))
+				default:
+					failed <- err
+					return
+				}
 
-			if ctx.Err() != nil {
-				return
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(consumeRetryDelay):
+				}
 			}
-
-			cg.consumer.ready = make(chan bool)
 		}
 	}()
 
-	<-cg.consumer.ready // Await till the consumer has been set up
-	log.Println("Sarama consumer up and running!...")
+	select {
+	case <-cg.ready:
+		zap.S().Infow("Kafka consumer group up and running", "group", cg.groupID, "topics", topics)
+		return nil
+	case err := <-failed:
+		cg.setErr(err)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	return nil
+// Stop leaves the group after the messages being handled are done, or when
+// ctx is done. The group can not be started again
+func (cg *ConsumerGroup) Stop(ctx context.Context) error {
+	cg.mu.Lock()
+	if cg.stopped {
+		cg.mu.Unlock()
+		return nil
+	}
+	cg.stopped = true
+	started := cg.started
+	if cg.cancel != nil {
+		cg.cancel()
+	}
+	cg.mu.Unlock()
+
+	if started {
+		select {
+		case <-cg.done:
+		case <-ctx.Done():
+			zap.S().Warnw("Stopped kafka consumer group before draining", "group", cg.groupID)
+		}
+	}
+
+	err := cg.cg.Close()
+	// the group does not close a client it did not create
+	if cg.client != nil {
+		if closeErr := cg.client.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if cg.consumer.producer != nil {
+		if closeErr := cg.consumer.producer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
+// Close stops the group waiting for the messages being handled
 func (cg *ConsumerGroup) Close() error {
-	cg.keepRunning <- false
+	return cg.Stop(context.Background())
+}
 
-	if err := cg.cg.Close(); err != nil {
-		return err
+// Errors returns the errors of the sessions, errors are dropped when the
+// channel is not drained. It is closed once the group is stopped
+func (cg *ConsumerGroup) Errors() <-chan error {
+	return cg.errorChan
+}
+
+// Health returns nil while the group consumes, it can be used by readiness probes
+func (cg *ConsumerGroup) Health() error {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	switch {
+	case cg.stopped:
+		return ErrConsumerStopped
+	case !cg.started:
+		return ErrConsumerNotStarted
+	}
+	return cg.lastErr
+}
+
+// Pause stops fetching partitions of topic until they are resumed, messages
+// already fetched are still handled
+func (cg *ConsumerGroup) Pause(topic string, partitions ...int32) {
+	cg.cg.Pause(map[string][]int32{topic: partitions})
+}
+
+// Resume fetches again partitions of topic paused by Pause
+func (cg *ConsumerGroup) Resume(topic string, partitions ...int32) {
+	cg.cg.Resume(map[string][]int32{topic: partitions})
+}
+
+// setup is called at the beginning of every session
+func (cg *ConsumerGroup) setup() {
+	cg.setErr(nil)
+	cg.readyOnce.Do(func() {
+		close(cg.ready)
+	})
+}
+
+func (cg *ConsumerGroup) setErr(err error) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.lastErr = err
+}
+
+// forwardErrors reports the errors of sarama until the group is closed
+func (cg *ConsumerGroup) forwardErrors() {
+	for err := range cg.cg.Errors() {
+		cg.report(err)
+	}
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.closed = true
+	close(cg.errorChan)
+}
+
+func (cg *ConsumerGroup) report(err error) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	if cg.closed {
+		return
+	}
+
+	select {
+	case cg.errorChan <- err:
+	default:
+		zap.S().Warnw("Dropped kafka consumer group error", "group", cg.groupID, "error", err)
 	}
-	return nil
 }
 
-type MessageHanlder func(*sarama.ConsumerMessage)
+// ConsumerOption represents option of the consumer
+type ConsumerOption func(*Consumer)
+
+// WithPartitionsAssigned calls fn with the partitions claimed by every new
+// session, before their messages are consumed
+func WithPartitionsAssigned(fn func(claims map[string][]int32)) ConsumerOption {
+	return func(c *Consumer) {
+		c.onAssigned = fn
+	}
+}
+
+// WithPartitionsRevoked calls fn with the partitions of a session ending on
+// rebalance or stop, once their messages are no longer handled
+func WithPartitionsRevoked(fn func(claims map[string][]int32)) ConsumerOption {
+	return func(c *Consumer) {
+		c.onRevoked = fn
+	}
+}
+
+// MessageHanlder handles a message, the message is only committed once the
+// handler returned nil
+type MessageHanlder func(ctx context.Context, message *sarama.ConsumerMessage) error
 
 // Consumer represents a Sarama consumer group consumer
 type Consumer struct {
-	ready   chan bool
+	groupID string
 	handler MessageHanlder
+	setup   func()
+
+	onAssigned func(claims map[string][]int32)
+	onRevoked  func(claims map[string][]int32)
+
+	maxAttempts     int
+	backoff         time.Duration
+	maxBackoff      time.Duration
+	retryTopics     []RetryTopic
+	deadLetterTopic string
+	producer        sarama.SyncProducer
+	autoCommit      bool
+
+	lagInterval time.Duration
+	lag         *lagMonitor
+
+	workers     int
+	maxInFlight int
+
+	batchHandler BatchMessageHandler
+	batchSize    int
+	batchWait    time.Duration
+
+	processor ProcessFunc
+	provider  *ProducerProvider
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (consumer *Consumer) Setup(sarama.ConsumerGroupSession) error {
+func (consumer *Consumer) Setup(session sarama.ConsumerGroupSession) error {
 	// Mark the consumer as ready
-	close(consumer.ready)
+	if consumer.setup != nil {
+		consumer.setup()
+	}
+	if consumer.lag != nil {
+		consumer.lag.assign(session.Claims())
+	}
+	if consumer.onAssigned != nil {
+		consumer.onAssigned(session.Claims())
+	}
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (consumer *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+func (consumer *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	if consumer.lag != nil {
+		consumer.lag.revoke(session.Claims())
+	}
+	if consumer.onRevoked != nil {
+		consumer.onRevoked(session.Claims())
+	}
 	return nil
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages().
 func (consumer *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if consumer.processor != nil {
+		return consumer.consumeExactlyOnce(session, claim)
+	}
+	if consumer.batchHandler != nil {
+		return consumer.consumeBatches(session, claim)
+	}
+	if consumer.workers > 1 {
+		return consumer.consumeConcurrently(session, claim)
+	}
+
 	for {
 		select {
-		case message := <-claim.Messages():
-			log.Printf("Message claimed: value = %s, timestamp = %v, topic = %s", string(message.Value), message.Timestamp, message.Topic)
-			session.MarkMessage(message, "")
-			consumer.handler(message)
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			consumer.received(message)
+			// the message is left unmarked when the session ends while it is
+			// processed, the next owner of the partition receives it again
+			if err := consumer.process(session, message); err != nil {
+				return nil
+			}
 
 		case <-session.Context().Done():
 			return nil
 		}
 	}
 }
+
+// process marks message once it was handled or forwarded after its failed attempts
+func (consumer *Consumer) process(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
+	if err := consumer.execute(session.Context(), message); err != nil {
+		return err
+	}
+
+	session.MarkMessage(message, "")
+	if !consumer.autoCommit {
+		session.Commit()
+	}
+	return nil
+}
+
+// execute handles message or forwards it after its failed attempts, it only
+// fails when ctx is done
+func (consumer *Consumer) execute(ctx context.Context, message *sarama.ConsumerMessage) error {
+	if err := consumer.waitRetryDelay(ctx, message); err != nil {
+		return err
+	}
+
+	spanCtx, span := consumer.startConsumerSpan(ctx, message)
+	attempts, err := consumer.handle(spanCtx, message)
+	jaeger.Finish(span, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := consumer.forward(ctx, message, attempts, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetTracker tracks the in-flight offsets of a partition and returns the
+// low watermark, the offset following the contiguous completed ones
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending []int64
+	done    map[int64]bool
+}
+
+// WithConcurrency handles the messages of every claimed partition with
+// workers goroutines, messages with the same key are handled in order by the
+// same worker. Dispatching blocks while maxInFlight messages of the partition
+// are being handled, only offsets below the oldest unfinished message are
+// committed
+func WithConcurrency(workers, maxInFlight int) ConsumerOption {
+	return func(c *Consumer) {
+		if maxInFlight < workers {
+			maxInFlight = workers
+		}
+		c.workers = workers
+		c.maxInFlight = maxInFlight
+	}
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		done: make(map[int64]bool),
+	}
+}
+
+// add registers a dispatched offset, offsets must be added in order
+func (t *offsetTracker) add(offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// complete returns the new low watermark, false when it did not move
+func (t *offsetTracker) complete(offset int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done[offset] = true
+	watermark := int64(-1)
+	for len(t.pending) != 0 && t.done[t.pending[0]] {
+		watermark = t.pending[0] + 1
+		delete(t.done, t.pending[0])
+		t.pending = t.pending[1:]
+	}
+	return watermark, watermark >= 0
+}
+
+// consumeConcurrently dispatches the messages of claim to the workers
+func (consumer *Consumer) consumeConcurrently(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var (
+		wg       sync.WaitGroup
+		tracker  = newOffsetTracker()
+		inFlight = make(chan struct{}, consumer.maxInFlight)
+		queues   = make([]chan *sarama.ConsumerMessage, consumer.workers)
+		commitMu sync.Mutex
+		ctx      = session.Context()
+	)
+
+	for index := range queues {
+		queues[index] = make(chan *sarama.ConsumerMessage, consumer.maxInFlight)
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for message := range queue {
+				// an unfinished message holds the watermark so it is received again
+				if err := consumer.execute(ctx, message); err == nil {
+					if watermark, ok := tracker.complete(message.Offset); ok {
+						commitMu.Lock()
+						session.MarkOffset(message.Topic, message.Partition, watermark, "")
+						if !consumer.autoCommit {
+							session.Commit()
+						}
+						commitMu.Unlock()
+					}
+				}
+				<-inFlight
+			}
+		}(queues[index])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			consumer.received(message)
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return nil
+			}
+			tracker.add(message.Offset)
+			queues[consumer.worker(message)] <- message
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// worker returns the worker of message, messages without key are spread by
+// offset since they have no ordering requirement
+func (consumer *Consumer) worker(message *sarama.ConsumerMessage) int {
+	if len(message.Key) == 0 {
+		return int(message.Offset % int64(consumer.workers))
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write(message.Key)
+	return int(hash.Sum32() % uint32(consumer.workers))
+}
@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// TestOffsetTracker validates only contiguous completed offsets are committed
+func TestOffsetTracker(t *testing.T) {
+	t.Log("Given the need to commit offsets handled out of order")
+	{
+		tracker := newOffsetTracker()
+		for offset := int64(10); offset < 14; offset++ {
+			tracker.add(offset)
+		}
+
+		if _, ok := tracker.complete(12); ok {
+			t.Errorf("\tShould not move the watermark past an unfinished offset. %v", ballotX)
+		} else {
+			t.Logf("\tShould not move the watermark past an unfinished offset. %v", checkMark)
+		}
+
+		if watermark, ok := tracker.complete(10); !ok || watermark != 11 {
+			t.Errorf("\tShould move the watermark after the oldest offset. %v %d", ballotX, watermark)
+		} else {
+			t.Logf("\tShould move the watermark after the oldest offset. %v", checkMark)
+		}
+
+		if watermark, ok := tracker.complete(11); !ok || watermark != 13 {
+			t.Errorf("\tShould move the watermark over completed offsets. %v %d", ballotX, watermark)
+		} else {
+			t.Logf("\tShould move the watermark over completed offsets. %v", checkMark)
+		}
+	}
+}
+
+// TestConsumeConcurrently validates the messages of a key are handled in
+// order, the watermark is committed and the in-flight messages are bounded
+func TestConsumeConcurrently(t *testing.T) {
+	var (
+		started = make(chan int64, 4)
+		release = map[int64]chan struct{}{}
+		mu      sync.Mutex
+		handled = map[string][]int64{}
+	)
+	for offset := int64(0); offset < 4; offset++ {
+		release[offset] = make(chan struct{})
+	}
+	consumer := &Consumer{
+		groupID: "payments",
+		handler: func(ctx context.Context, message *sarama.ConsumerMessage) error {
+			started <- message.Offset
+			<-release[message.Offset]
+			mu.Lock()
+			defer mu.Unlock()
+			handled[string(message.Key)] = append(handled[string(message.Key)], message.Offset)
+			return nil
+		},
+	}
+	WithConcurrency(2, 2)(consumer)
+
+	// keys handled by different workers
+	first, second := []byte("order-0"), []byte("order-1")
+	for index := 2; consumer.worker(&sarama.ConsumerMessage{Key: second}) == consumer.worker(&sarama.ConsumerMessage{Key: first}); index++ {
+		second = []byte(fmt.Sprintf("order-%d", index))
+	}
+
+	claim := &testClaim{topic: "orders", messages: make(chan *sarama.ConsumerMessage)}
+	session := newTestSession(context.Background(), claim)
+	done := make(chan error)
+	go func() {
+		done <- consumer.consumeConcurrently(session, claim)
+	}()
+	send := func(offset int64, key []byte) bool {
+		select {
+		case claim.messages <- &sarama.ConsumerMessage{Topic: "orders", Offset: offset, Key: key}:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+	// wait returns the next count offsets whose handling started
+	wait := func(count int) map[int64]bool {
+		offsets := map[int64]bool{}
+		for len(offsets) < count {
+			select {
+			case offset := <-started:
+				offsets[offset] = true
+			case <-time.After(time.Second):
+				return offsets
+			}
+		}
+		return offsets
+	}
+
+	t.Log("Given the need to handle the messages of a partition concurrently")
+	{
+		if !send(0, first) || !send(1, second) || !send(2, first) {
+			t.Fatalf("\tShould dispatch the messages. %v", ballotX)
+		}
+		if offsets := wait(2); !offsets[0] || !offsets[1] {
+			t.Fatalf("\tShould handle messages of different keys concurrently. %v", ballotX)
+		}
+		if send(3, second) {
+			t.Errorf("\tShould stop dispatching once max in flight messages are handled. %v", ballotX)
+		} else {
+			t.Logf("\tShould stop dispatching once max in flight messages are handled. %v", checkMark)
+		}
+
+		close(release[1])
+		if !send(3, second) {
+			t.Fatalf("\tShould dispatch again once a message is handled. %v", ballotX)
+		}
+		if offset := session.offset("orders", 0); offset != -1 {
+			t.Errorf("\tShould not commit past an unfinished message. %v %d", ballotX, offset)
+		} else {
+			t.Logf("\tShould not commit past an unfinished message. %v", checkMark)
+		}
+
+		close(release[0])
+		if offsets := wait(2); !offsets[2] || !offsets[3] {
+			t.Fatalf("\tShould handle a message once the previous one of its key is handled. %v", ballotX)
+		}
+		close(release[2])
+		close(release[3])
+		close(claim.messages)
+		if err := <-done; err != nil {
+			t.Fatalf("\tShould consume the claim. %v %v", ballotX, err)
+		}
+
+		if offsets := handled[string(first)]; len(offsets) != 2 || offsets[0] != 0 || offsets[1] != 2 {
+			t.Errorf("\tShould handle the messages of a key in order. %v %v", ballotX, offsets)
+		} else {
+			t.Logf("\tShould handle the messages of a key in order. %v", checkMark)
+		}
+		if offset := session.offset("orders", 0); offset != 4 {
+			t.Errorf("\tShould commit the watermark once the messages are handled. %v %d", ballotX, offset)
+		} else {
+			t.Logf("\tShould commit the watermark once the messages are handled. %v", checkMark)
+		}
+	}
+}
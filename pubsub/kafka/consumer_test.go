@@ -0,0 +1,313 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+type (
+	// testSession records the offsets marked and committed by the consumer
+	testSession struct {
+		ctx     context.Context
+		claims  map[string][]int32
+		mu      sync.Mutex
+		marked  map[string]int64
+		commits int
+	}
+
+	// testClaim delivers the messages of a single partition
+	testClaim struct {
+		topic         string
+		partition     int32
+		highWaterMark int64
+		messages      chan *sarama.ConsumerMessage
+	}
+
+	// testGroup runs a single session over claims until it is closed
+	testGroup struct {
+		claims []*testClaim
+		errors chan error
+	}
+)
+
+func newTestSession(ctx context.Context, claims ...*testClaim) *testSession {
+	session := &testSession{
+		ctx:    ctx,
+		claims: map[string][]int32{},
+		marked: map[string]int64{},
+	}
+	for _, claim := range claims {
+		session.claims[claim.topic] = append(session.claims[claim.topic], claim.partition)
+	}
+	return session
+}
+
+func (s *testSession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *testSession) MemberID() string {
+	return "member"
+}
+
+func (s *testSession) GenerationID() int32 {
+	return 1
+}
+
+func (s *testSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked[fmt.Sprintf("%s/%d", topic, partition)] = offset
+}
+
+func (s *testSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+}
+
+func (s *testSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *testSession) Context() context.Context {
+	return s.ctx
+}
+
+// offset returns the next offset marked for the partition, -1 when none
+func (s *testSession) offset(topic string, partition int32) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset, ok := s.marked[fmt.Sprintf("%s/%d", topic, partition)]; ok {
+		return offset
+	}
+	return -1
+}
+
+// newTestClaim delivers messages then closes the claim, as on rebalance
+func newTestClaim(topic string, partition int32, messages ...*sarama.ConsumerMessage) *testClaim {
+	claim := &testClaim{
+		topic:     topic,
+		partition: partition,
+		messages:  make(chan *sarama.ConsumerMessage, len(messages)),
+	}
+	for _, message := range messages {
+		message.Topic, message.Partition = topic, partition
+		claim.messages <- message
+		claim.highWaterMark = message.Offset + 1
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (c *testClaim) Topic() string {
+	return c.topic
+}
+
+func (c *testClaim) Partition() int32 {
+	return c.partition
+}
+
+func (c *testClaim) InitialOffset() int64 {
+	return 0
+}
+
+func (c *testClaim) HighWaterMarkOffset() int64 {
+	return c.highWaterMark
+}
+
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func (g *testGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	session := newTestSession(ctx, g.claims...)
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+	for _, claim := range g.claims {
+		if err := handler.ConsumeClaim(session, claim); err != nil {
+			return err
+		}
+	}
+	if err := handler.Cleanup(session); err != nil {
+		return err
+	}
+	g.claims = nil
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (g *testGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *testGroup) Close() error {
+	close(g.errors)
+	return nil
+}
+
+func (g *testGroup) Pause(partitions map[string][]int32) {}
+
+func (g *testGroup) Resume(partitions map[string][]int32) {}
+
+func (g *testGroup) PauseAll() {}
+
+func (g *testGroup) ResumeAll() {}
+
+// headerValue returns the value of the header key of message
+func headerValue(message *sarama.ProducerMessage, key string) string {
+	for _, header := range message.Headers {
+		if string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// TestConsumerForwarding validates failed messages go through the retry topics
+// then to the dead-letter topic
+func TestConsumerForwarding(t *testing.T) {
+	var forwarded []*sarama.ProducerMessage
+	producer := mocks.NewSyncProducer(t, nil)
+	for i := 0; i < 2; i++ {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+			forwarded = append(forwarded, message)
+			return nil
+		})
+	}
+	defer producer.Close()
+
+	calls := 0
+	consumer := &Consumer{
+		groupID: "payments",
+		handler: func(ctx context.Context, message *sarama.ConsumerMessage) error {
+			calls++
+			return errors.New("payment gateway unavailable")
+		},
+		backoff:         time.Millisecond,
+		maxBackoff:      time.Millisecond,
+		retryTopics:     []RetryTopic{{Topic: "orders.retry"}},
+		deadLetterTopic: "orders.dlq",
+		producer:        producer,
+	}
+
+	t.Log("Given the need to forward a message the handler keeps failing on")
+	{
+		session := newTestSession(context.Background())
+		claim := newTestClaim("orders", 0, &sarama.ConsumerMessage{Offset: 5, Key: []byte("order-1")})
+		if err := consumer.ConsumeClaim(session, claim); err != nil {
+			t.Fatalf("\tShould consume the claim. %v %v", ballotX, err)
+		}
+
+		if calls != defaultForwardAttempts || len(forwarded) != 1 || forwarded[0].Topic != "orders.retry" {
+			t.Fatalf("\tShould forward to the retry topic after the default attempts. %v %d %d", ballotX, calls, len(forwarded))
+		}
+		t.Logf("\tShould forward to the retry topic after the default attempts. %v", checkMark)
+
+		if session.offset("orders", 0) != 6 || session.commits != 1 {
+			t.Errorf("\tShould commit the forwarded message. %v %d %d", ballotX, session.offset("orders", 0), session.commits)
+		} else {
+			t.Logf("\tShould commit the forwarded message. %v", checkMark)
+		}
+
+		t.Log("\tWhen the message fails on the last retry topic")
+		{
+			value, _ := forwarded[0].Value.Encode()
+			message := &sarama.ConsumerMessage{Offset: 0, Key: []byte("order-1"), Value: value}
+			for _, header := range forwarded[0].Headers {
+				header := header
+				message.Headers = append(message.Headers, &header)
+			}
+			if err := consumer.ConsumeClaim(session, newTestClaim("orders.retry", 0, message)); err != nil {
+				t.Fatalf("\t\tShould consume the claim. %v %v", ballotX, err)
+			}
+
+			if len(forwarded) != 2 || forwarded[1].Topic != "orders.dlq" {
+				t.Fatalf("\t\tShould forward to the dead-letter topic. %v %d", ballotX, len(forwarded))
+			}
+			t.Logf("\t\tShould forward to the dead-letter topic. %v", checkMark)
+
+			if headerValue(forwarded[1], HeaderOriginalTopic) != "orders" || headerValue(forwarded[1], HeaderAttempts) != "6" {
+				t.Errorf("\t\tShould keep the original topic and accumulate the attempts. %v %v", ballotX, forwarded[1].Headers)
+			} else {
+				t.Logf("\t\tShould keep the original topic and accumulate the attempts. %v", checkMark)
+			}
+		}
+	}
+}
+
+// TestConsumerGroupLifecycle validates the hooks and the health of a group
+func TestConsumerGroupLifecycle(t *testing.T) {
+	var (
+		assigned, revoked map[string][]int32
+		handled           []int64
+	)
+	group := &testGroup{
+		claims: []*testClaim{newTestClaim("orders", 1, &sarama.ConsumerMessage{Offset: 7})},
+		errors: make(chan error),
+	}
+	cg := &ConsumerGroup{
+		errorChan: make(chan error, errorsBufferSize),
+		groupID:   "payments",
+		cg:        group,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+		consumer: Consumer{
+			groupID: "payments",
+			handler: func(ctx context.Context, message *sarama.ConsumerMessage) error {
+				handled = append(handled, message.Offset)
+				return nil
+			},
+			onAssigned: func(claims map[string][]int32) { assigned = claims },
+			onRevoked:  func(claims map[string][]int32) { revoked = claims },
+		},
+	}
+	cg.consumer.setup = cg.setup
+	go cg.forwardErrors()
+
+	t.Log("Given the need to start and stop a consumer group")
+	{
+		if err := cg.Health(); err != ErrConsumerNotStarted {
+			t.Errorf("\tShould not be healthy before it is started. %v %v", ballotX, err)
+		}
+		if err := cg.Start(context.Background(), []string{"orders"}); err != nil {
+			t.Fatalf("\tShould start the group. %v %v", ballotX, err)
+		}
+		if err := cg.Health(); err != nil {
+			t.Errorf("\tShould be healthy once started. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould be healthy once started. %v", checkMark)
+		}
+
+		if err := cg.Stop(context.Background()); err != nil {
+			t.Fatalf("\tShould stop the group. %v %v", ballotX, err)
+		}
+		if len(handled) != 1 || len(assigned["orders"]) != 1 || len(revoked["orders"]) != 1 {
+			t.Errorf("\tShould handle the message between the assignment and the revocation. %v %v %v %v", ballotX, handled, assigned, revoked)
+		} else {
+			t.Logf("\tShould handle the message between the assignment and the revocation. %v", checkMark)
+		}
+
+		if err := cg.Health(); err != ErrConsumerStopped {
+			t.Errorf("\tShould not be healthy once stopped. %v %v", ballotX, err)
+		}
+		if err := cg.Start(context.Background(), []string{"orders"}); err != ErrConsumerStarted {
+			t.Errorf("\tShould not start a stopped group again. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould not start a stopped group again. %v", checkMark)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// ProcessFunc transforms a consumed message into the messages to produce,
+// it may be called several times for the same message
+type ProcessFunc func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error)
+
+// NewExactlyOnceConsumerGroup creates a consumer group producing the output of
+// fnProcess and committing the consumed offset in the same transaction, with
+// a transactional producer borrowed from provider. Only committed messages are
+// consumed and the offsets are never committed outside of the transactions,
+// messages forwarded to a retry or dead-letter topic are produced in the
+// transaction of their offset. A failed transaction is aborted and committed
+// again until the session ends, the next owner of the partition then consumes
+// the message again from the last committed offset
+func NewExactlyOnceConsumerGroup(ctx context.Context, brokers []string, groupID string, kafkaConfigFn func() *sarama.Config, provider *ProducerProvider, fnProcess ProcessFunc, opts ...ConsumerOption) *ConsumerGroup {
+	sarama.Logger = log.New(os.Stdout, "[comsumer]", log.LstdFlags)
+
+	config := kafkaConfigFn()
+	config.Consumer.IsolationLevel = sarama.ReadCommitted
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	return newConsumerGroup(brokers, groupID, config, Consumer{
+		processor: fnProcess,
+		provider:  provider,
+	}, opts...)
+}
+
+// consumeExactlyOnce processes the messages of claim one transaction each
+func (consumer *Consumer) consumeExactlyOnce(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			consumer.received(message)
+			// the offset of a message is only committed by its transaction,
+			// the next owner of the partition consumes the message again when
+			// the session ends before the transaction is committed
+			if err := consumer.processExactlyOnce(session, message); err != nil {
+				return nil
+			}
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// processExactlyOnce commits the output of message along with its offset,
+// messages the process function keeps failing on are forwarded instead of
+// their output
+func (consumer *Consumer) processExactlyOnce(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
+	ctx := session.Context()
+	if err := consumer.waitRetryDelay(ctx, message); err != nil {
+		return err
+	}
+
+	var (
+		outputs   []*sarama.ProducerMessage
+		forwarded *sarama.ProducerMessage
+	)
+	spanCtx, span := consumer.startConsumerSpan(ctx, message)
+	attempts, err := consumer.retry(ctx, message.Topic, func() (err error) {
+		outputs, err = consumer.processor(spanCtx, message)
+		return err
+	}, "topic", message.Topic, "partition", message.Partition, "offset", message.Offset)
+	if err == nil {
+		for _, output := range outputs {
+			output.Headers = injectSpan(spanCtx, output.Headers)
+		}
+	}
+	jaeger.Finish(span, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		outputs = nil
+		if forwarded = consumer.forwardMessage(message, attempts, err); forwarded != nil {
+			outputs = append(outputs, forwarded)
+		}
+	}
+
+	backoff := consumer.backoff
+	for {
+		err := consumer.produceInTxn(message, outputs)
+		if err == nil {
+			if forwarded != nil {
+				consumer.observeForwarded(message, forwarded.Topic)
+			}
+			return nil
+		}
+
+		zap.S().Warnw("Failed to commit kafka transaction", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > consumer.maxBackoff {
+			backoff = consumer.maxBackoff
+		}
+	}
+}
+
+// produceInTxn sends outputs and the offset following message in a single
+// transaction, outputs are copied since sarama changes the messages it sends
+func (consumer *Consumer) produceInTxn(message *sarama.ConsumerMessage, outputs []*sarama.ProducerMessage) (err error) {
+	producer := consumer.provider.Borrow()
+	// a producer in error is closed instead of returned to the pool
+	defer consumer.provider.Release(producer)
+
+	start := time.Now()
+	defer func() {
+		producerSendDuration.WithLabelValues(message.Topic, status(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	if err := producer.BeginTxn(); err != nil {
+		return err
+	}
+	// the outcome of the outputs is known once the transaction ends, their
+	// successes and errors are drained so the producer never blocks on them
+	stop := drainResults(producer)
+	defer stop()
+
+	for _, output := range outputs {
+		producer.Input() <- copyMessage(output)
+	}
+	if err := producer.AddMessageToTxn(message, consumer.groupID, nil); err != nil {
+		consumer.abortTxn(producer)
+		return err
+	}
+	if err := producer.CommitTxn(); err != nil {
+		if producer.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+			consumer.abortTxn(producer)
+		}
+		return err
+	}
+	return nil
+}
+
+func (consumer *Consumer) abortTxn(producer sarama.AsyncProducer) {
+	if err := producer.AbortTxn(); err != nil {
+		zap.S().Warnw("Failed to abort kafka transaction", "error", err)
+		return
+	}
+	producerTxnAborts.Inc()
+}
+
+// drainResults discards the successes and errors of producer until stop is
+// called
+func drainResults(producer sarama.AsyncProducer) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		successes, errs := producer.Successes(), producer.Errors()
+		for successes != nil || errs != nil {
+			select {
+			case <-done:
+				return
+			case _, ok := <-successes:
+				if !ok {
+					successes = nil
+				}
+			case producerErr, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				zap.S().Debugw("Failed to produce kafka message in transaction", "topic", producerErr.Msg.Topic, "error", producerErr.Err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// copyMessage returns a message sarama never sent with the content of message
+func copyMessage(message *sarama.ProducerMessage) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic:     message.Topic,
+		Key:       message.Key,
+		Value:     message.Value,
+		Headers:   append([]sarama.RecordHeader(nil), message.Headers...),
+		Metadata:  message.Metadata,
+		Partition: message.Partition,
+		Timestamp: message.Timestamp,
+	}
+}
@@ -0,0 +1,201 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// testTxnProducer is a transactional producer delivering the messages and
+// offsets of a transaction once it is committed, like sarama it reports the
+// outcome of the messages on unbuffered channels
+type testTxnProducer struct {
+	mu          sync.Mutex
+	input       chan *sarama.ProducerMessage
+	successes   chan *sarama.ProducerMessage
+	errors      chan *sarama.ProducerError
+	status      sarama.ProducerTxnStatusFlag
+	failCommits int
+	pending     []int64
+	committed   []*sarama.ProducerMessage
+	aborted     []*sarama.ProducerMessage
+	offsets     []int64
+	aborts      int
+}
+
+func newTestTxnProducer(failCommits int) *testTxnProducer {
+	return &testTxnProducer{
+		input:       make(chan *sarama.ProducerMessage, 16),
+		successes:   make(chan *sarama.ProducerMessage),
+		errors:      make(chan *sarama.ProducerError),
+		status:      sarama.ProducerTxnFlagReady,
+		failCommits: failCommits,
+	}
+}
+
+// drain returns the messages sent since the transaction began
+func (p *testTxnProducer) drain() (messages []*sarama.ProducerMessage) {
+	for {
+		select {
+		case message := <-p.input:
+			messages = append(messages, message)
+		default:
+			return messages
+		}
+	}
+}
+
+func (p *testTxnProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func (p *testTxnProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *testTxnProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func (p *testTxnProducer) Successes() <-chan *sarama.ProducerMessage {
+	return p.successes
+}
+
+func (p *testTxnProducer) Errors() <-chan *sarama.ProducerError {
+	return p.errors
+}
+
+func (p *testTxnProducer) IsTransactional() bool {
+	return true
+}
+
+func (p *testTxnProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status
+}
+
+// BeginTxn yields to the other goroutines like the round trip of sarama
+func (p *testTxnProducer) BeginTxn() error {
+	defer runtime.Gosched()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status&sarama.ProducerTxnFlagInTransaction != 0 {
+		return sarama.ErrTransitionNotAllowed
+	}
+	p.status = sarama.ProducerTxnFlagInTransaction
+	return nil
+}
+
+func (p *testTxnProducer) CommitTxn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	messages := p.drain()
+	if p.failCommits > 0 {
+		p.failCommits--
+		for _, message := range messages {
+			p.errors <- &sarama.ProducerError{Msg: message, Err: sarama.ErrOutOfOrderSequenceNumber}
+		}
+		p.aborted = append(p.aborted, messages...)
+		p.status |= sarama.ProducerTxnFlagAbortableError
+		return sarama.ErrOutOfOrderSequenceNumber
+	}
+	for _, message := range messages {
+		p.successes <- message
+	}
+	p.committed = append(p.committed, messages...)
+	p.offsets = append(p.offsets, p.pending...)
+	p.pending = nil
+	p.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (p *testTxnProducer) AbortTxn() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.aborted = append(p.aborted, p.drain()...)
+	p.pending = nil
+	p.aborts++
+	p.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (p *testTxnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
+	return nil
+}
+
+func (p *testTxnProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, msg.Offset+1)
+	return nil
+}
+
+// TestConsumeExactlyOnce validates outputs, forwarded messages and offsets are
+// only delivered by committed transactions
+func TestConsumeExactlyOnce(t *testing.T) {
+	producer := newTestTxnProducer(1)
+	consumer := &Consumer{
+		groupID: "payments",
+		processor: func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+			if string(message.Key) == "declined" {
+				return nil, errors.New("payment declined")
+			}
+			return []*sarama.ProducerMessage{{Topic: "invoices", Key: sarama.ByteEncoder(message.Key)}}, nil
+		},
+		provider: &ProducerProvider{
+			producerProvider: func() sarama.AsyncProducer { return producer },
+		},
+		backoff:         time.Millisecond,
+		maxBackoff:      time.Millisecond,
+		deadLetterTopic: "orders.dlq",
+	}
+
+	t.Log("Given the need to process messages exactly once")
+	{
+		session := newTestSession(context.Background())
+		claim := newTestClaim("orders", 0,
+			&sarama.ConsumerMessage{Offset: 3, Key: []byte("paid")},
+			&sarama.ConsumerMessage{Offset: 4, Key: []byte("declined")},
+		)
+		if err := consumer.ConsumeClaim(session, claim); err != nil {
+			t.Fatalf("\tShould consume the claim. %v %v", ballotX, err)
+		}
+
+		if producer.aborts != 1 || len(producer.offsets) != 2 || producer.offsets[0] != 4 || producer.offsets[1] != 5 {
+			t.Errorf("\tShould commit the offsets again after an aborted transaction. %v %d %v", ballotX, producer.aborts, producer.offsets)
+		} else {
+			t.Logf("\tShould commit the offsets again after an aborted transaction. %v", checkMark)
+		}
+
+		if len(producer.committed) != 2 || producer.committed[0].Topic != "invoices" {
+			t.Fatalf("\tShould deliver the output once. %v %d", ballotX, len(producer.committed))
+		}
+		t.Logf("\tShould deliver the output once. %v", checkMark)
+
+		if len(producer.aborted) != 1 || producer.aborted[0] == producer.committed[0] {
+			t.Errorf("\tShould send a new message in the transaction committed again. %v %d", ballotX, len(producer.aborted))
+		} else {
+			t.Logf("\tShould send a new message in the transaction committed again. %v", checkMark)
+		}
+
+		if producer.committed[1].Topic != "orders.dlq" || headerValue(producer.committed[1], HeaderAttempts) != "3" {
+			t.Errorf("\tShould forward the failed message in its transaction. %v %v", ballotX, producer.committed[1].Topic)
+		} else {
+			t.Logf("\tShould forward the failed message in its transaction. %v", checkMark)
+		}
+
+		if session.offset("orders", 0) != -1 || session.commits != 0 {
+			t.Errorf("\tShould not commit offsets outside of the transactions. %v", ballotX)
+		} else {
+			t.Logf("\tShould not commit offsets outside of the transactions. %v", checkMark)
+		}
+	}
+}
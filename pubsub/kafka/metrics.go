@@ -0,0 +1,273 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	metricsNamespace   = "kafka"
+	defaultLagInterval = 15 * time.Second
+)
+
+var (
+	registerMetricsOnce sync.Once
+
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "lag",
+		Help:      "Number of messages of a partition between the committed offset and the high watermark.",
+	}, []string{"group", "topic", "partition"})
+	consumerMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "messages_total",
+		Help:      "Number of messages received.",
+	}, []string{"group", "topic"})
+	consumerBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "bytes_total",
+		Help:      "Size of the keys and values of messages received.",
+	}, []string{"group", "topic"})
+	consumerHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "handler_duration_seconds",
+		Help:      "Latency of the handler calls, batches are observed as a whole.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 9),
+	}, []string{"group", "topic", "status"})
+	consumerRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "retries_total",
+		Help:      "Number of handler calls retried.",
+	}, []string{"group", "topic"})
+	consumerForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "consumer",
+		Name:      "forwarded_total",
+		Help:      "Number of failed messages forwarded to a retry or dead-letter topic.",
+	}, []string{"group", "topic", "target", "dead_letter"})
+
+	producerSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "send_duration_seconds",
+		Help:      "Latency of the sends including the transaction.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 9),
+	}, []string{"topic", "status"})
+	producerTxnAborts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "producer",
+		Name:      "transaction_aborts_total",
+		Help:      "Number of aborted transactions.",
+	})
+)
+
+// registerMetrics registers the kafka metrics with the default prometheus
+// registerer the first time a consumer group or producer is created
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		collectors := []prometheus.Collector{
+			consumerLag, consumerMessages, consumerBytes, consumerHandlerDuration,
+			consumerRetries, consumerForwarded, producerSendDuration, producerTxnAborts,
+		}
+		for _, collector := range collectors {
+			if err := prometheus.Register(collector); err != nil {
+				var registered prometheus.AlreadyRegisteredError
+				if !errors.As(err, &registered) {
+					zap.S().Warnw("Failed to register kafka metrics", "error", err)
+				}
+			}
+		}
+	})
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+type (
+	// lagSource returns the offsets the lag is computed from
+	lagSource interface {
+		// committedOffsets returns the offsets committed by group, negative
+		// for partitions without commit
+		committedOffsets(group string, claims map[string][]int32) (map[string]map[int32]int64, error)
+		highWaterMark(topic string, partition int32) (int64, error)
+	}
+
+	// lagMonitor refreshes the lag of the claimed partitions on a ticker so
+	// it keeps growing while the handler is stuck
+	lagMonitor struct {
+		groupID  string
+		source   lagSource
+		interval time.Duration
+
+		mu         sync.Mutex
+		claims     map[string][]int32
+		generation int
+	}
+
+	saramaLagSource struct {
+		client sarama.Client
+	}
+)
+
+// WithLagInterval sets how often the consumer lag is refreshed, 15 seconds by
+// default, a zero interval disables the lag metric
+func WithLagInterval(interval time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.lagInterval = interval
+	}
+}
+
+// received records a claimed message
+func (consumer *Consumer) received(message *sarama.ConsumerMessage) {
+	consumerMessages.WithLabelValues(consumer.groupID, message.Topic).Inc()
+	consumerBytes.WithLabelValues(consumer.groupID, message.Topic).Add(float64(len(message.Key) + len(message.Value)))
+}
+
+// assign starts monitoring the lag of claims
+func (m *lagMonitor) assign(claims map[string][]int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims = claims
+	m.generation++
+}
+
+// revoke drops the lag of partitions no longer claimed
+func (m *lagMonitor) revoke(claims map[string][]int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims = nil
+	m.generation++
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			consumerLag.DeleteLabelValues(m.groupID, topic, strconv.FormatInt(int64(partition), 10))
+		}
+	}
+}
+
+// run refreshes the lag until ctx is done
+func (m *lagMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.update(); err != nil {
+				zap.S().Warnw("Failed to refresh kafka consumer lag", "group", m.groupID, "error", err)
+			}
+		}
+	}
+}
+
+// update sets the lag of the claimed partitions to their high watermark minus
+// their committed offset
+func (m *lagMonitor) update() error {
+	m.mu.Lock()
+	claims, generation := m.claims, m.generation
+	m.mu.Unlock()
+	if len(claims) == 0 {
+		return nil
+	}
+
+	committed, err := m.source.committedOffsets(m.groupID, claims)
+	if err != nil {
+		return err
+	}
+	lags := map[string]map[int32]int64{}
+	for topic, partitions := range claims {
+		lags[topic] = map[int32]int64{}
+		for _, partition := range partitions {
+			offset, ok := committed[topic][partition]
+			if !ok || offset < 0 {
+				continue
+			}
+			highWaterMark, err := m.source.highWaterMark(topic, partition)
+			if err != nil {
+				return err
+			}
+			if lag := highWaterMark - offset; lag > 0 {
+				lags[topic][partition] = lag
+			} else {
+				lags[topic][partition] = 0
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the partitions were revoked in between
+	if generation != m.generation {
+		return nil
+	}
+	for topic, partitions := range lags {
+		for partition, lag := range partitions {
+			consumerLag.WithLabelValues(m.groupID, topic, strconv.FormatInt(int64(partition), 10)).Set(float64(lag))
+		}
+	}
+	return nil
+}
+
+func (s *saramaLagSource) committedOffsets(group string, claims map[string][]int32) (map[string]map[int32]int64, error) {
+	coordinator, err := s.client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 1}
+	if s.client.Config().Version.IsAtLeast(sarama.V0_10_2_0) {
+		request.Version = 2
+	}
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			request.AddPartition(topic, partition)
+		}
+	}
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[string]map[int32]int64{}
+	for topic, partitions := range claims {
+		offsets[topic] = map[int32]int64{}
+		for _, partition := range partitions {
+			block := response.GetBlock(topic, partition)
+			if block == nil || block.Err != sarama.ErrNoError {
+				continue
+			}
+			offsets[topic][partition] = block.Offset
+		}
+	}
+	return offsets, nil
+}
+
+func (s *saramaLagSource) highWaterMark(topic string, partition int32) (int64, error) {
+	return s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+}
+
+func (consumer *Consumer) observeHandler(topic string, start time.Time, err error) {
+	consumerHandlerDuration.WithLabelValues(consumer.groupID, topic, status(err)).Observe(time.Since(start).Seconds())
+}
+
+func (consumer *Consumer) observeForwarded(message *sarama.ConsumerMessage, topic string) {
+	consumerForwarded.WithLabelValues(consumer.groupID, message.Topic, topic,
+		strconv.FormatBool(topic == consumer.deadLetterTopic)).Inc()
+}
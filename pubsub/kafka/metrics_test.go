@@ -0,0 +1,75 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestLagMonitor validates the lag is the high watermark minus the committed
+// offset and keeps being refreshed while no message is handled
+func TestLagMonitor(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+
+	highWaterMark := sarama.NewMockOffsetResponse(t).SetOffset("orders", 0, sarama.OffsetNewest, 100)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "payments", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("payments", "orders", 0, 90, "", sarama.ErrNoError),
+		"OffsetRequest": highWaterMark,
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_6_0_0
+	config.Net.ReadTimeout = time.Second
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatalf("Should connect to the cluster. %v %v", ballotX, err)
+	}
+	defer client.Close()
+
+	monitor := &lagMonitor{groupID: "payments", source: &saramaLagSource{client: client}}
+	claims := map[string][]int32{"orders": {0}}
+	lag := consumerLag.WithLabelValues("payments", "orders", "0")
+
+	t.Log("Given the need to monitor the lag of a claimed partition")
+	{
+		monitor.assign(claims)
+		if err := monitor.update(); err != nil {
+			t.Fatalf("\tShould refresh the lag. %v %v", ballotX, err)
+		}
+		if value := testutil.ToFloat64(lag); value != 10 {
+			t.Errorf("\tShould set the lag from the committed offset. %v %v", ballotX, value)
+		} else {
+			t.Logf("\tShould set the lag from the committed offset. %v", checkMark)
+		}
+
+		t.Log("\tWhen messages keep being produced while the handler is stuck")
+		{
+			highWaterMark.SetOffset("orders", 0, sarama.OffsetNewest, 150)
+			if err := monitor.update(); err != nil {
+				t.Fatalf("\t\tShould refresh the lag. %v %v", ballotX, err)
+			}
+			if value := testutil.ToFloat64(lag); value != 60 {
+				t.Errorf("\t\tShould increase the lag. %v %v", ballotX, value)
+			} else {
+				t.Logf("\t\tShould increase the lag. %v", checkMark)
+			}
+		}
+
+		monitor.revoke(claims)
+		if err := monitor.update(); err != nil || testutil.CollectAndCount(consumerLag) != 0 {
+			t.Errorf("\tShould drop the lag of revoked partitions. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould drop the lag of revoked partitions. %v", checkMark)
+		}
+	}
+}
@@ -1,16 +1,26 @@
 package kafka
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"lib/opentracing/jaeger"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
 )
 
 const MAXIMUMRETRY = 3
 
+// ErrTransactionAborted is returned when a transaction failed to commit and
+// was aborted, none of its messages were delivered
+var ErrTransactionAborted = errors.New("kafka: transaction aborted")
+
 type ProducerProvider struct {
 	transactionIdGenerator int32
 
@@ -21,6 +31,8 @@ type ProducerProvider struct {
 }
 
 func NewProducerProvider(brokers []string, kafkaConfigFn func() *sarama.Config) *ProducerProvider {
+	registerMetrics()
+
 	provider := &ProducerProvider{}
 	provider.producerProvider = func() sarama.AsyncProducer {
 		config := kafkaConfigFn()
@@ -81,9 +93,14 @@ func (p *ProducerProvider) Clear() {
 	p.producers = p.producers[:0]
 }
 
-func (p *ProducerProvider) Send(producer sarama.AsyncProducer, message *sarama.ProducerMessage) error {
+func (p *ProducerProvider) Send(producer sarama.AsyncProducer, message *sarama.ProducerMessage) (err error) {
+	start := time.Now()
+	defer func() {
+		producerSendDuration.WithLabelValues(message.Topic, status(err)).Observe(time.Since(start).Seconds())
+	}()
+
 	// Start kafka transaction
-	err := producer.BeginTxn()
+	err = producer.BeginTxn()
 	if err != nil {
 		log.Printf("unable to start txn %s\n", err)
 		return err
@@ -127,7 +144,8 @@ func (p *ProducerProvider) HandleErrorProducer(producer sarama.AsyncProducer, me
 				log.Printf("Producer: %v - unable to abort transaction: %+v", retryNum, err)
 				continue
 			}
-			return nil
+			producerTxnAborts.Inc()
+			return ErrTransactionAborted
 		}
 		// if not you can retry
 		err = producer.CommitTxn()
@@ -141,27 +159,207 @@ func (p *ProducerProvider) HandleErrorProducer(producer sarama.AsyncProducer, me
 	return err
 }
 
-type Producer struct {
-	producer sarama.AsyncProducer
-	topic    string
+// ProducerMode represents how Producer delivers messages
+type ProducerMode int
+
+const (
+	// ModeTransactional sends every call in its own transaction, the config
+	// must set a transaction ID
+	ModeTransactional ProducerMode = iota
+	// ModeSync waits until the messages are acknowledged
+	ModeSync
+	// ModeAsync returns as soon as the messages are queued, delivery failures
+	// are only reported to the delivery callback
+	ModeAsync
+)
+
+type (
+	// Message represents a message to produce
+	Message struct {
+		Key     []byte
+		Value   []byte
+		Headers map[string]string
+		// Partition is used instead of the partitioner when not nil
+		Partition *int32
+		// Timestamp is set by the producer when zero
+		Timestamp time.Time
+		// Metadata is handed back in the delivery report
+		Metadata interface{}
+	}
+
+	// DeliveryReport represents the outcome of a produced message
+	DeliveryReport struct {
+		Message   *Message
+		Partition int32
+		Offset    int64
+		Err       error
+	}
+
+	// ProducerOption represents option of the producer
+	ProducerOption func(*Producer)
+
+	Producer struct {
+		producer   sarama.AsyncProducer
+		topic      string
+		mode       ProducerMode
+		onDelivery func(DeliveryReport)
+		wg         sync.WaitGroup
+		// txnMu serializes the transactions, a producer runs one at a time
+		txnMu sync.Mutex
+	}
+
+	// delivery travels with a message through sarama as its metadata
+	delivery struct {
+		message *Message
+		done    chan error
+	}
+
+	// explicitPartitioner honors the partition set on a message
+	explicitPartitioner struct {
+		sarama.Partitioner
+	}
+)
+
+// WithProducerMode selects how messages are delivered, ModeTransactional by default
+func WithProducerMode(mode ProducerMode) ProducerOption {
+	return func(p *Producer) {
+		p.mode = mode
+	}
 }
 
-func NewProducer(brokers []string, topic string, kafkaConfigFn func() *sarama.Config) *Producer {
-	config := kafkaConfigFn()
-	asyncProducer, err := sarama.NewAsyncProducer(brokers, config)
-	if err != nil {
-		return nil
+// WithDeliveryCallback calls fn with the outcome of every message, fn is
+// called from a single goroutine and must not block
+func WithDeliveryCallback(fn func(DeliveryReport)) ProducerOption {
+	return func(p *Producer) {
+		p.onDelivery = fn
 	}
+}
+
+// WithDeliveryChannel sends the outcome of every message to ch, ch must be
+// drained or production stalls
+func WithDeliveryChannel(ch chan<- DeliveryReport) ProducerOption {
+	return WithDeliveryCallback(func(report DeliveryReport) {
+		ch <- report
+	})
+}
+
+func NewProducer(brokers []string, topic string, kafkaConfigFn func() *sarama.Config, opts ...ProducerOption) *Producer {
+	registerMetrics()
 
 	producer := &Producer{
-		producer: asyncProducer,
-		topic:    topic,
+		topic: topic,
+	}
+	for _, opt := range opts {
+		opt(producer)
 	}
 
+	asyncProducer, err := sarama.NewAsyncProducer(brokers, producer.config(kafkaConfigFn()))
+	if err != nil {
+		return nil
+	}
+	producer.start(asyncProducer)
 	return producer
 }
 
+// config adapts config to the mode of the producer
+func (p *Producer) config(config *sarama.Config) *sarama.Config {
+	config.Producer.Return.Errors = true
+	config.Producer.Return.Successes = p.mode != ModeAsync || p.onDelivery != nil
+	if p.mode != ModeTransactional {
+		config.Producer.Transaction.ID = ""
+	}
+	config.Producer.Partitioner = newExplicitPartitioner(config.Producer.Partitioner)
+	return config
+}
+
+// start reports the outcome of the messages sent through asyncProducer
+func (p *Producer) start(asyncProducer sarama.AsyncProducer) {
+	p.producer = asyncProducer
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		for message := range asyncProducer.Successes() {
+			p.deliver(message, nil)
+		}
+	}()
+	go func() {
+		defer p.wg.Done()
+		for producerErr := range asyncProducer.Errors() {
+			p.deliver(producerErr.Msg, producerErr.Err)
+		}
+	}()
+}
+
+// Send produces data with key, see SendMessage
 func (p *Producer) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   []byte(key),
+		Value: data,
+	})
+}
+
+// SendMessage produces message according to the mode of the producer
+func (p *Producer) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+// SendBatch produces messages in a single transaction in transactional mode,
+// the transactions of concurrent callers run one after the other. In sync
+// mode it waits for all of them and returns the first failure
+func (p *Producer) SendBatch(ctx context.Context, messages []*Message) (err error) {
+	start := time.Now()
+	span := jaeger.Start(ctx, ">kafka.Producer/Send", ext.SpanKindProducer,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: p.topic})
+	defer func() {
+		jaeger.Finish(span, err)
+		producerSendDuration.WithLabelValues(p.topic, status(err)).Observe(time.Since(start).Seconds())
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	switch p.mode {
+	case ModeTransactional:
+		return p.sendTransaction(ctx, messages)
+	case ModeSync:
+		deliveries := make([]*delivery, len(messages))
+		for index, message := range messages {
+			deliveries[index] = &delivery{message: message, done: make(chan error, 1)}
+			if err := p.enqueue(ctx, deliveries[index]); err != nil {
+				return err
+			}
+		}
+		for _, delivery := range deliveries {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case err := <-delivery.done:
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, message := range messages {
+		if err := p.enqueue(ctx, &delivery{message: message}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the queued messages
+func (p *Producer) Close() error {
+	err := p.producer.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Producer) sendTransaction(ctx context.Context, messages []*Message) error {
+	p.txnMu.Lock()
+	defer p.txnMu.Unlock()
+
 	// Start kafka transaction
 	err := p.producer.BeginTxn()
 	if err != nil {
@@ -170,13 +368,88 @@ func (p *Producer) Send(key string, data []byte) error {
 	}
 
 	// Produce some records in transaction
-	p.producer.Input() <- &sarama.ProducerMessage{Topic: p.topic, Key: sarama.ByteEncoder(key), Value: sarama.ByteEncoder(data)}
+	for _, message := range messages {
+		if err := p.enqueue(ctx, &delivery{message: message}); err != nil {
+			p.abort()
+			return err
+		}
+	}
 
 	// commit transaction
 	err = p.producer.CommitTxn()
 	if err != nil {
 		log.Printf("Producer: unable to commit txn %s\n", err)
+		if p.producer.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+			p.abort()
+		}
 		return err
 	}
 	return nil
 }
+
+func (p *Producer) abort() {
+	if err := p.producer.AbortTxn(); err != nil {
+		log.Printf("Producer: unable to abort txn %s\n", err)
+		return
+	}
+	producerTxnAborts.Inc()
+}
+
+func (p *Producer) enqueue(ctx context.Context, delivery *delivery) error {
+	message := &sarama.ProducerMessage{
+		Topic:     p.topic,
+		Value:     sarama.ByteEncoder(delivery.message.Value),
+		Timestamp: delivery.message.Timestamp,
+		Metadata:  delivery,
+	}
+	if delivery.message.Key != nil {
+		message.Key = sarama.ByteEncoder(delivery.message.Key)
+	}
+	for key, value := range delivery.message.Headers {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	message.Headers = injectSpan(ctx, message.Headers)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case p.producer.Input() <- message:
+		return nil
+	}
+}
+
+func (p *Producer) deliver(message *sarama.ProducerMessage, err error) {
+	delivery, ok := message.Metadata.(*delivery)
+	if !ok {
+		return
+	}
+	if delivery.done != nil {
+		delivery.done <- err
+	}
+	if p.onDelivery != nil {
+		p.onDelivery(DeliveryReport{
+			Message:   delivery.message,
+			Partition: message.Partition,
+			Offset:    message.Offset,
+			Err:       err,
+		})
+	} else if err != nil && p.mode == ModeAsync {
+		zap.S().Warnw("Failed to deliver kafka message", "topic", p.topic, "error", err)
+	}
+}
+
+func newExplicitPartitioner(fallback sarama.PartitionerConstructor) sarama.PartitionerConstructor {
+	if fallback == nil {
+		fallback = sarama.NewHashPartitioner
+	}
+	return func(topic string) sarama.Partitioner {
+		return &explicitPartitioner{fallback(topic)}
+	}
+}
+
+func (p *explicitPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if delivery, ok := message.Metadata.(*delivery); ok && delivery.message.Partition != nil {
+		return *delivery.message.Partition, nil
+	}
+	return p.Partitioner.Partition(message, numPartitions)
+}
@@ -0,0 +1,176 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// newMockProducer returns a producer of topic "orders" sending through a
+// sarama mock, the mock expectations are checked when the producer is closed
+func newMockProducer(t *testing.T, opts ...ProducerOption) (*Producer, *mocks.AsyncProducer) {
+	producer := &Producer{topic: "orders"}
+	for _, opt := range opts {
+		opt(producer)
+	}
+	mock := mocks.NewAsyncProducer(t, producer.config(mocks.NewTestConfig()))
+	producer.start(mock)
+	return producer, mock
+}
+
+// TestProducerSync validates the sync mode waits for every message of a batch
+func TestProducerSync(t *testing.T) {
+	producer, mock := newMockProducer(t, WithProducerMode(ModeSync))
+	defer producer.Close()
+
+	t.Log("Given the need to send a batch and wait for its acknowledgement")
+	{
+		mock.ExpectInputAndSucceed()
+		mock.ExpectInputAndSucceed()
+		err := producer.SendBatch(context.Background(), []*Message{
+			{Key: []byte("order-1"), Value: []byte("created")},
+			{Key: []byte("order-1"), Value: []byte("paid")},
+		})
+		if err != nil {
+			t.Errorf("\tShould send the batch. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould send the batch. %v", checkMark)
+		}
+
+		t.Log("\tWhen a message of the batch is not acknowledged")
+		{
+			mock.ExpectInputAndSucceed()
+			mock.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+			err := producer.SendBatch(context.Background(), []*Message{
+				{Key: []byte("order-2"), Value: []byte("created")},
+				{Key: []byte("order-2"), Value: []byte("paid")},
+			})
+			if err != sarama.ErrNotEnoughReplicas {
+				t.Errorf("\t\tShould return the delivery failure. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould return the delivery failure. %v", checkMark)
+			}
+		}
+	}
+}
+
+// TestProducerAsync validates the delivery reports and the explicit partition
+// of the messages sent in async mode
+func TestProducerAsync(t *testing.T) {
+	reports := make(chan DeliveryReport, 2)
+	producer, mock := newMockProducer(t, WithProducerMode(ModeAsync), WithDeliveryChannel(reports))
+	defer producer.Close()
+
+	t.Log("Given the need to be notified of the delivery of queued messages")
+	{
+		partition := int32(7)
+		var checked *sarama.ProducerMessage
+		mock.ExpectInputWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+			checked = message
+			return nil
+		})
+		mock.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+
+		messages := []*Message{
+			{Key: []byte("order-1"), Value: []byte("created"), Headers: map[string]string{"source": "web"}, Partition: &partition, Metadata: 1},
+			{Key: []byte("order-2"), Value: []byte("created"), Metadata: 2},
+		}
+		if err := producer.SendBatch(context.Background(), messages); err != nil {
+			t.Fatalf("\tShould queue the messages. %v %v", ballotX, err)
+		}
+
+		for i := 0; i < len(messages); i++ {
+			select {
+			case report := <-reports:
+				switch report.Message.Metadata {
+				case 1:
+					if report.Err != nil || report.Partition != partition || headerValue(checked, "source") != "web" {
+						t.Errorf("\tShould deliver the message to its explicit partition. %v %v %d", ballotX, report.Err, report.Partition)
+					} else {
+						t.Logf("\tShould deliver the message to its explicit partition. %v", checkMark)
+					}
+				case 2:
+					if report.Err != sarama.ErrMessageSizeTooLarge {
+						t.Errorf("\tShould report the delivery failure. %v %v", ballotX, report.Err)
+					} else {
+						t.Logf("\tShould report the delivery failure. %v", checkMark)
+					}
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("\tShould report the delivery of every message. %v", ballotX)
+			}
+		}
+	}
+}
+
+// TestProducerTransactional validates a batch is committed in one transaction
+// and aborted when the commit fails
+func TestProducerTransactional(t *testing.T) {
+	txnProducer := newTestTxnProducer(0)
+	producer := &Producer{topic: "orders", mode: ModeTransactional}
+	producer.start(txnProducer)
+	defer producer.Close()
+
+	t.Log("Given the need to send a batch atomically")
+	{
+		err := producer.SendBatch(context.Background(), []*Message{
+			{Key: []byte("order-1"), Value: []byte("created")},
+			{Key: []byte("order-1"), Value: []byte("paid")},
+		})
+		if err != nil || len(txnProducer.committed) != 2 {
+			t.Errorf("\tShould commit the batch in one transaction. %v %v %d", ballotX, err, len(txnProducer.committed))
+		} else {
+			t.Logf("\tShould commit the batch in one transaction. %v", checkMark)
+		}
+
+		t.Log("\tWhen the transaction fails to commit")
+		{
+			txnProducer.failCommits = 1
+			err := producer.SendMessage(context.Background(), &Message{Key: []byte("order-2"), Value: []byte("created")})
+			if err == nil || txnProducer.aborts != 1 || len(txnProducer.committed) != 2 {
+				t.Errorf("\t\tShould abort the transaction. %v %v %d", ballotX, err, txnProducer.aborts)
+			} else {
+				t.Logf("\t\tShould abort the transaction. %v", checkMark)
+			}
+		}
+
+		t.Log("\tWhen batches are sent concurrently")
+		{
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for i := 0; i < 8; i++ {
+				key := []byte(fmt.Sprintf("order-%d", i+3))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					err := producer.SendBatch(context.Background(), []*Message{
+						{Key: key, Value: []byte("created")},
+						{Key: key, Value: []byte("paid")},
+					})
+					if err != nil {
+						t.Errorf("\t\tShould send the batch. %v %v", ballotX, err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			committed := txnProducer.committed[2:]
+			atomic := len(committed) == 16
+			for index := 0; atomic && index < len(committed); index += 2 {
+				atomic = string(committed[index].Key.(sarama.ByteEncoder)) == string(committed[index+1].Key.(sarama.ByteEncoder))
+			}
+			if !atomic {
+				t.Errorf("\t\tShould commit every batch in its own transaction. %v %d", ballotX, len(committed))
+			} else {
+				t.Logf("\t\tShould commit every batch in its own transaction. %v", checkMark)
+			}
+		}
+	}
+}
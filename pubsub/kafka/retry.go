@@ -0,0 +1,246 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.uber.org/zap"
+)
+
+// headers added to the messages forwarded to a retry or dead-letter topic
+const (
+	HeaderError             = "x-error"
+	HeaderAttempts          = "x-attempts"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+	// defaultForwardAttempts bounds the attempts when failed messages are
+	// forwarded, retrying forever would never forward them
+	defaultForwardAttempts = 3
+)
+
+// RetryTopic is a topic failed messages are forwarded to, its messages are
+// handled again Delay after they were forwarded
+type RetryTopic struct {
+	Topic string
+	Delay time.Duration
+}
+
+// WithRetry calls the handler up to maxAttempts times per message with an
+// exponential backoff between backoff and maxBackoff, a zero maxAttempts
+// retries until the handler succeeds which is the default without retry or
+// dead-letter topic, with them it is 3 attempts
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.maxAttempts = maxAttempts
+		c.backoff = backoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetryTopics forwards messages still failing after the retries to the
+// first retry topic, then from one retry topic to the next and finally to the
+// dead-letter topic. Retry topics are subscribed along with the other topics
+func WithRetryTopics(topics ...RetryTopic) ConsumerOption {
+	return func(c *Consumer) {
+		c.retryTopics = topics
+	}
+}
+
+// WithDeadLetterTopic forwards messages failing on the last retry topic, or
+// after the retries when there is no retry topic, to topic. Without
+// dead-letter topic such messages are logged and skipped
+func WithDeadLetterTopic(topic string) ConsumerOption {
+	return func(c *Consumer) {
+		c.deadLetterTopic = topic
+	}
+}
+
+// retryTopicNames returns the topics to subscribe along with the ones of the caller
+func (consumer *Consumer) retryTopicNames() []string {
+	topics := make([]string, len(consumer.retryTopics))
+	for index, topic := range consumer.retryTopics {
+		topics[index] = topic.Topic
+	}
+	return topics
+}
+
+func (consumer *Consumer) forwarding() bool {
+	return len(consumer.retryTopics) != 0 || consumer.deadLetterTopic != ""
+}
+
+// waitRetryDelay holds messages of a retry topic until their delay elapsed
+func (consumer *Consumer) waitRetryDelay(ctx context.Context, message *sarama.ConsumerMessage) error {
+	for _, topic := range consumer.retryTopics {
+		if topic.Topic != message.Topic || topic.Delay <= 0 {
+			continue
+		}
+		wait := time.Until(message.Timestamp.Add(topic.Delay))
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return nil
+}
+
+// handle calls the handler until it succeeds or the attempts are exhausted,
+// it returns the last error of the handler and the number of attempts
+func (consumer *Consumer) handle(ctx context.Context, message *sarama.ConsumerMessage) (int, error) {
+	return consumer.retry(ctx, message.Topic, func() error {
+		return consumer.handler(ctx, message)
+	}, "topic", message.Topic, "partition", message.Partition, "offset", message.Offset)
+}
+
+func (consumer *Consumer) retry(ctx context.Context, topic string, fn func() error, keysAndValues ...interface{}) (int, error) {
+	backoff := consumer.backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := fn()
+		consumer.observeHandler(topic, start, err)
+		if err == nil {
+			return attempt, nil
+		}
+		if maxAttempts := consumer.attemptsLimit(); maxAttempts > 0 && attempt >= maxAttempts {
+			return attempt, err
+		}
+
+		consumerRetries.WithLabelValues(consumer.groupID, topic).Inc()
+		zap.S().Warnw("Failed to handle message", append(keysAndValues, "attempt", attempt, "error", err)...)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > consumer.maxBackoff {
+			backoff = consumer.maxBackoff
+		}
+	}
+}
+
+// attemptsLimit returns the attempts per message, 0 when unbounded
+func (consumer *Consumer) attemptsLimit() int {
+	if consumer.maxAttempts <= 0 && consumer.forwarding() {
+		return defaultForwardAttempts
+	}
+	return consumer.maxAttempts
+}
+
+// forward sends a message the handler failed on to the next retry topic or
+// the dead-letter topic, it only gives up when ctx is done
+func (consumer *Consumer) forward(ctx context.Context, message *sarama.ConsumerMessage, attempts int, handleErr error) error {
+	forwarded := consumer.forwardMessage(message, attempts, handleErr)
+	if forwarded == nil {
+		return nil
+	}
+
+	backoff := consumer.backoff
+	for {
+		_, _, err := consumer.producer.SendMessage(forwarded)
+		if err == nil {
+			consumer.observeForwarded(message, forwarded.Topic)
+			return nil
+		}
+
+		zap.S().Warnw("Failed to forward message", "topic", forwarded.Topic, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > consumer.maxBackoff {
+			backoff = consumer.maxBackoff
+		}
+	}
+}
+
+// forwardMessage returns the message forwarding message to the next retry
+// topic or the dead-letter topic, nil when message is skipped
+func (consumer *Consumer) forwardMessage(message *sarama.ConsumerMessage, attempts int, handleErr error) *sarama.ProducerMessage {
+	topic := consumer.nextTopic(message.Topic)
+	if topic == "" {
+		zap.S().Errorw("Skipped message after failed attempts", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "attempts", attempts, "error", handleErr)
+		return nil
+	}
+
+	return &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: failureHeaders(message, attempts, handleErr),
+	}
+}
+
+// nextTopic returns the topic following current in the retry chain, empty
+// when failed messages of current are skipped
+func (consumer *Consumer) nextTopic(current string) string {
+	next := 0
+	for index, topic := range consumer.retryTopics {
+		if topic.Topic == current {
+			next = index + 1
+			break
+		}
+	}
+	if next < len(consumer.retryTopics) {
+		return consumer.retryTopics[next].Topic
+	}
+	return consumer.deadLetterTopic
+}
+
+// failureHeaders keeps the headers of message, the original location of a
+// message already forwarded and accumulates the attempts
+func failureHeaders(message *sarama.ConsumerMessage, attempts int, handleErr error) []sarama.RecordHeader {
+	original := map[string]string{
+		HeaderOriginalTopic:     message.Topic,
+		HeaderOriginalPartition: strconv.FormatInt(int64(message.Partition), 10),
+		HeaderOriginalOffset:    strconv.FormatInt(message.Offset, 10),
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+5)
+	for _, header := range message.Headers {
+		key := string(header.Key)
+		switch key {
+		case HeaderError:
+			continue
+		case HeaderAttempts:
+			if previous, err := strconv.Atoi(string(header.Value)); err == nil {
+				attempts += previous
+			}
+			continue
+		case HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset:
+			original[key] = string(header.Value)
+			continue
+		}
+		headers = append(headers, *header)
+	}
+
+	for _, key := range []string{HeaderOriginalTopic, HeaderOriginalPartition, HeaderOriginalOffset} {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(original[key])})
+	}
+	return append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderError), Value: []byte(handleErr.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderAttempts), Value: []byte(strconv.Itoa(attempts))},
+	)
+}
+
+// forwardConfig derives a non transactional sync producer config from the
+// consumer config
+func forwardConfig(config *sarama.Config) *sarama.Config {
+	forward := *config
+	forward.Producer.Transaction.ID = ""
+	forward.Producer.Return.Successes = true
+	forward.Producer.Return.Errors = true
+	return &forward
+}
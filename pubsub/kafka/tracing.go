@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// consumerHeaders reads the span context injected in the headers of a message
+type consumerHeaders []*sarama.RecordHeader
+
+func (h consumerHeaders) ForeachKey(handler func(key, val string) error) error {
+	for _, header := range h {
+		if err := handler(string(header.Key), string(header.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startConsumerSpan continues the trace of the producer of message when its
+// headers carry one, the span is stored in the returned context
+func (consumer *Consumer) startConsumerSpan(ctx context.Context, message *sarama.ConsumerMessage, tags ...opentracing.Tag) (context.Context, opentracing.Span) {
+	tags = append(tags,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: message.Topic},
+		opentracing.Tag{Key: "kafka.group", Value: consumer.groupID},
+		opentracing.Tag{Key: "kafka.partition", Value: message.Partition},
+		opentracing.Tag{Key: "kafka.offset", Value: message.Offset},
+	)
+
+	var span opentracing.Span
+	spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, consumerHeaders(message.Headers))
+	if err == nil {
+		span = jaeger.Continue(spanCtx, ">kafka.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	} else {
+		span = jaeger.Start(ctx, ">kafka.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	}
+	return opentracing.ContextWithSpan(ctx, span), span
+}
+
+// injectSpan adds the span context of ctx to headers
+func injectSpan(ctx context.Context, headers []sarama.RecordHeader) []sarama.RecordHeader {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return headers
+	}
+
+	carrier := opentracing.TextMapCarrier{}
+	if err := opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		return headers
+	}
+	for key, value := range carrier {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	}
+	return headers
+}
@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+// referenceTracer records the references of the consumer spans
+type referenceTracer struct {
+	*mocktracer.MockTracer
+	references [][]opentracing.SpanReference
+}
+
+func (t *referenceTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	if operationName == ">kafka.Consumer/Handle" {
+		options := opentracing.StartSpanOptions{}
+		for _, opt := range opts {
+			opt.Apply(&options)
+		}
+		t.references = append(t.references, options.References)
+	}
+	return t.MockTracer.StartSpan(operationName, opts...)
+}
+
+// followsFrom tells if the last consumer span follows from parent
+func (t *referenceTracer) followsFrom(parent mocktracer.MockSpanContext) bool {
+	if len(t.references) == 0 {
+		return false
+	}
+	references := t.references[len(t.references)-1]
+	return len(references) == 1 && references[0].Type == opentracing.FollowsFromRef &&
+		references[0].ReferencedContext.(mocktracer.MockSpanContext).SpanID == parent.SpanID
+}
+
+// extract returns the span context carried by headers
+func (t *referenceTracer) extract(headers []sarama.RecordHeader) (mocktracer.MockSpanContext, error) {
+	carrier := make(consumerHeaders, len(headers))
+	for index := range headers {
+		carrier[index] = &headers[index]
+	}
+	spanCtx, err := t.Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		return mocktracer.MockSpanContext{}, err
+	}
+	return spanCtx.(mocktracer.MockSpanContext), nil
+}
+
+// TestTracing validates the span context travels in the headers of the
+// messages and the consumers continue it
+func TestTracing(t *testing.T) {
+	tracer := &referenceTracer{MockTracer: mocktracer.New()}
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	producer, mock := newMockProducer(t, WithProducerMode(ModeSync))
+	defer producer.Close()
+
+	t.Log("Given the need to trace messages from their producer to their consumers")
+	{
+		checkout := tracer.StartSpan("checkout")
+		var produced *sarama.ProducerMessage
+		mock.ExpectInputWithMessageCheckerFunctionAndSucceed(func(message *sarama.ProducerMessage) error {
+			produced = message
+			return nil
+		})
+		ctx := opentracing.ContextWithSpan(context.Background(), checkout)
+		if err := producer.SendMessage(ctx, &Message{Key: []byte("order-1"), Value: []byte("created")}); err != nil {
+			t.Fatalf("\tShould send the message. %v %v", ballotX, err)
+		}
+		checkout.Finish()
+
+		sent, err := tracer.extract(produced.Headers)
+		if err != nil || sent.TraceID != checkout.Context().(mocktracer.MockSpanContext).TraceID {
+			t.Fatalf("\tShould inject the span context in the headers. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould inject the span context in the headers. %v", checkMark)
+
+		message := &sarama.ConsumerMessage{Topic: "orders", Key: []byte("order-1")}
+		for index := range produced.Headers {
+			message.Headers = append(message.Headers, &produced.Headers[index])
+		}
+
+		var handled opentracing.Span
+		consumer := &Consumer{
+			groupID: "payments",
+			handler: func(ctx context.Context, message *sarama.ConsumerMessage) error {
+				handled = opentracing.SpanFromContext(ctx)
+				return nil
+			},
+			batchHandler: func(ctx context.Context, messages []*sarama.ConsumerMessage) error {
+				handled = opentracing.SpanFromContext(ctx)
+				return nil
+			},
+			backoff:    time.Millisecond,
+			maxBackoff: time.Millisecond,
+		}
+
+		if err := consumer.execute(context.Background(), message); err != nil || handled == nil || !tracer.followsFrom(sent) {
+			t.Errorf("\tShould handle the message in a span following from the producer. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould handle the message in a span following from the producer. %v", checkMark)
+		}
+
+		handled, tracer.references = nil, nil
+		session := newTestSession(context.Background())
+		if err := consumer.processBatch(session, []*sarama.ConsumerMessage{message}); err != nil || handled == nil || !tracer.followsFrom(sent) {
+			t.Errorf("\tShould handle the batch in a span following from its first message. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould handle the batch in a span following from its first message. %v", checkMark)
+		}
+
+		tracer.references = nil
+		txnProducer := newTestTxnProducer(0)
+		consumer.processor = func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+			handled = opentracing.SpanFromContext(ctx)
+			return []*sarama.ProducerMessage{{Topic: "invoices"}}, nil
+		}
+		consumer.provider = &ProducerProvider{
+			producerProvider: func() sarama.AsyncProducer { return txnProducer },
+		}
+		if err := consumer.processExactlyOnce(session, message); err != nil || !tracer.followsFrom(sent) {
+			t.Fatalf("\tShould process the message in a span following from the producer. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould process the message in a span following from the producer. %v", checkMark)
+
+		output, err := tracer.extract(txnProducer.committed[0].Headers)
+		if err != nil || output.SpanID != handled.Context().(mocktracer.MockSpanContext).SpanID {
+			t.Errorf("\tShould inject the span of the processing in its outputs. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould inject the span of the processing in its outputs. %v", checkMark)
+		}
+	}
+}
@@ -1 +1,240 @@
 package nats
+
+import (
+	"context"
+	"errors"
+	"lib/opentracing/jaeger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+// ErrConsumerStarted is returned by Subscribe when the consumer already runs
+var ErrConsumerStarted = errors.New("nats: consumer already started")
+
+const (
+	defaultRedeliveryDelay    = time.Second
+	defaultMaxRedeliveryDelay = time.Minute
+)
+
+// durableReplacer removes the characters not allowed in durable names
+var durableReplacer = strings.NewReplacer(".", "_", "*", "any", ">", "all")
+
+type (
+	// MessageHandler handles a message, the message is redelivered when an
+	// error is returned
+	MessageHandler func(ctx context.Context, message *nats.Msg) error
+
+	// ConsumerOption represents option of the consumer
+	ConsumerOption func(*Consumer)
+
+	// Consumer consumes JetStream subjects through durable consumers shared by
+	// the instances of the same durable name
+	Consumer struct {
+		conn       *nats.Conn
+		js         nats.JetStreamContext
+		durable    string
+		handler    MessageHandler
+		stream     string
+		maxDeliver int
+		// the redelivery of a failed message is delayed from delay to
+		// maxDelay, doubling at every delivery
+		delay    time.Duration
+		maxDelay time.Duration
+
+		mu      sync.Mutex
+		started bool
+		subs    []*nats.Subscription
+	}
+)
+
+// WithMaxDeliver stops redelivering a message after n attempts, messages are
+// redelivered forever by default
+func WithMaxDeliver(n int) ConsumerOption {
+	return func(c *Consumer) {
+		c.maxDeliver = n
+	}
+}
+
+// WithRedeliveryBackoff delays the redelivery of a failed message from delay
+// to maxDelay, doubling at every delivery, from a second to a minute by
+// default. The delay is ignored by servers older than 2.7.1
+func WithRedeliveryBackoff(delay, maxDelay time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.delay = delay
+		c.maxDelay = maxDelay
+	}
+}
+
+// WithBindStream consumes from the stream name instead of looking up the
+// stream capturing the subjects
+func WithBindStream(name string) ConsumerOption {
+	return func(c *Consumer) {
+		c.stream = name
+	}
+}
+
+// NewConsumer connects to the servers of urls, messages are acknowledged once
+// handler succeeds and negatively acknowledged otherwise
+func NewConsumer(urls []string, durable string, handler MessageHandler, opts ...ConsumerOption) (*Consumer, error) {
+	consumer := &Consumer{
+		durable:  durable,
+		handler:  handler,
+		delay:    defaultRedeliveryDelay,
+		maxDelay: defaultMaxRedeliveryDelay,
+	}
+	for _, opt := range opts {
+		opt(consumer)
+	}
+
+	conn, err := nats.Connect(strings.Join(urls, ","))
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	consumer.conn = conn
+	consumer.js = js
+	return consumer, nil
+}
+
+// Subscribe consumes subjects in background until ctx is done or Close is
+// called, every subject has its own durable consumer
+func (c *Consumer) Subscribe(ctx context.Context, subjects []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return ErrConsumerStarted
+	}
+	c.started = true
+
+	for _, subject := range subjects {
+		durable := durableName(c.durable, subject)
+		sub, err := c.js.QueueSubscribe(subject, durable, c.handle(ctx, subject), c.subscribeOptions(durable)...)
+		if err != nil {
+			c.drain()
+			return err
+		}
+		c.subs = append(c.subs, sub)
+	}
+	zap.S().Infow("NATS consumer up and running", "durable", c.durable, "subjects", subjects)
+
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+	return nil
+}
+
+// Close waits for the messages being handled and closes the connection
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	c.drain()
+	c.mu.Unlock()
+
+	if c.conn.IsClosed() {
+		return nil
+	}
+	return c.conn.Drain()
+}
+
+func (c *Consumer) subscribeOptions(durable string) []nats.SubOpt {
+	opts := []nats.SubOpt{
+		nats.Durable(durable),
+		nats.ManualAck(),
+		nats.AckExplicit(),
+	}
+	if c.maxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(c.maxDeliver))
+	}
+	if c.stream != "" {
+		opts = append(opts, nats.BindStream(c.stream))
+	}
+	return opts
+}
+
+func (c *Consumer) handle(ctx context.Context, subject string) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		msgCtx, span := c.startConsumerSpan(ctx, msg)
+		err := c.handler(msgCtx, msg)
+		jaeger.Finish(span, err)
+
+		if err != nil {
+			delay := c.redeliveryDelay(msg)
+			zap.S().Warnw("Failed to handle nats message", "subject", subject, "delay", delay, "error", err)
+			if err := msg.NakWithDelay(delay); err != nil {
+				zap.S().Warnw("Failed to nak nats message", "subject", subject, "error", err)
+			}
+			return
+		}
+		if err := msg.Ack(); err != nil {
+			zap.S().Warnw("Failed to ack nats message", "subject", subject, "error", err)
+		}
+	}
+}
+
+// redeliveryDelay returns the backoff of msg after its deliveries so far
+func (c *Consumer) redeliveryDelay(msg *nats.Msg) time.Duration {
+	delay := c.delay
+	metadata, err := msg.Metadata()
+	if err != nil {
+		return delay
+	}
+	for delivered := uint64(1); delivered < metadata.NumDelivered && delay < c.maxDelay; delivered++ {
+		delay *= 2
+	}
+	if delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+	return delay
+}
+
+// drain stops the subscriptions after their pending messages are handled
+func (c *Consumer) drain() {
+	for _, sub := range c.subs {
+		if err := sub.Drain(); err != nil {
+			zap.S().Warnw("Failed to drain nats subscription", "subject", sub.Subject, "error", err)
+		}
+	}
+	c.subs = nil
+}
+
+// startConsumerSpan continues the trace of the producer of msg when its
+// headers carry one, the span is stored in the returned context
+func (c *Consumer) startConsumerSpan(ctx context.Context, msg *nats.Msg) (context.Context, opentracing.Span) {
+	tags := []opentracing.Tag{
+		{Key: string(ext.MessageBusDestination), Value: msg.Subject},
+		{Key: "nats.durable", Value: c.durable},
+	}
+
+	var span opentracing.Span
+	spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(msg.Header))
+	if err == nil {
+		span = jaeger.Continue(spanCtx, ">nats.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	} else {
+		span = jaeger.Start(ctx, ">nats.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	}
+	return opentracing.ContextWithSpan(ctx, span), span
+}
+
+// injectSpan adds the span context of ctx to header
+func injectSpan(ctx context.Context, header nats.Header) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	_ = opentracing.GlobalTracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+}
+
+func durableName(durable, subject string) string {
+	return durable + "_" + durableReplacer.Replace(subject)
+}
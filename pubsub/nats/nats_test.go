@@ -0,0 +1,114 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// runServer starts an embedded JetStream server stopped with the test
+func runServer(t *testing.T) string {
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Should create the server. %v %v", ballotX, err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatalf("Should start the server. %v", ballotX)
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv.ClientURL()
+}
+
+// TestConsumer validates handled messages are acknowledged and failed ones
+// redelivered until the max deliveries
+func TestConsumer(t *testing.T) {
+	url := runServer(t)
+	producer, err := NewProducer([]string{url}, "orders.created", WithStream("orders"))
+	if err != nil {
+		t.Fatalf("Should create the producer. %v %v", ballotX, err)
+	}
+	defer producer.Close()
+
+	var (
+		mu         sync.Mutex
+		deliveries = map[string]int{}
+		handled    = make(chan string, 10)
+	)
+	consumer, err := NewConsumer([]string{url}, "billing", func(ctx context.Context, message *nats.Msg) error {
+		key := message.Header.Get(HeaderKey)
+		mu.Lock()
+		deliveries[key]++
+		count := deliveries[key]
+		mu.Unlock()
+
+		handled <- key
+		if key == "declined" || (key == "flaky" && count == 1) {
+			return errors.New("payment gateway unavailable")
+		}
+		return nil
+	}, WithMaxDeliver(3), WithRedeliveryBackoff(10*time.Millisecond, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Should create the consumer. %v %v", ballotX, err)
+	}
+	defer consumer.Close()
+
+	t.Log("Given the need to consume messages of a stream")
+	{
+		if err := consumer.Subscribe(context.Background(), []string{"orders.created"}); err != nil {
+			t.Fatalf("\tShould subscribe. %v %v", ballotX, err)
+		}
+		for _, key := range []string{"paid", "flaky", "declined"} {
+			if err := producer.SendMessage(context.Background(), &Message{Key: key, Value: []byte(key)}); err != nil {
+				t.Fatalf("\tShould publish the message. %v %v", ballotX, err)
+			}
+		}
+
+		// paid once, flaky twice and declined up to the max deliveries
+		for i := 0; i < 6; i++ {
+			select {
+			case <-handled:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("\tShould deliver the messages. %v %v", ballotX, deliveries)
+			}
+		}
+		select {
+		case key := <-handled:
+			t.Fatalf("\tShould not redeliver %s. %v", key, ballotX)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if deliveries["paid"] != 1 {
+			t.Errorf("\tShould acknowledge a handled message. %v %d", ballotX, deliveries["paid"])
+		} else {
+			t.Logf("\tShould acknowledge a handled message. %v", checkMark)
+		}
+		if deliveries["flaky"] != 2 {
+			t.Errorf("\tShould redeliver a failed message. %v %d", ballotX, deliveries["flaky"])
+		} else {
+			t.Logf("\tShould redeliver a failed message. %v", checkMark)
+		}
+		if deliveries["declined"] != 3 {
+			t.Errorf("\tShould stop redelivering after the max deliveries. %v %d", ballotX, deliveries["declined"])
+		} else {
+			t.Logf("\tShould stop redelivering after the max deliveries. %v", checkMark)
+		}
+	}
+}
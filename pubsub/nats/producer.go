@@ -1 +1,201 @@
 package nats
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// HeaderKey carries the key of a message since NATS messages have none
+const HeaderKey = "x-message-key"
+
+type (
+	// Message represents a message to publish
+	Message struct {
+		Key     string
+		Value   []byte
+		Headers map[string]string
+		// Metadata is handed back in the delivery report
+		Metadata interface{}
+	}
+
+	// DeliveryReport represents the outcome of a published message
+	DeliveryReport struct {
+		Message  *Message
+		Stream   string
+		Sequence uint64
+		Err      error
+	}
+
+	// ProducerOption represents option of the producer
+	ProducerOption func(*Producer)
+
+	// Producer publishes messages to a JetStream subject
+	Producer struct {
+		conn       *nats.Conn
+		js         nats.JetStreamContext
+		subject    string
+		stream     string
+		async      bool
+		onDelivery func(DeliveryReport)
+		wg         sync.WaitGroup
+	}
+)
+
+// WithStream creates the stream name capturing the subject of the producer
+// when it does not exist
+func WithStream(name string) ProducerOption {
+	return func(p *Producer) {
+		p.stream = name
+	}
+}
+
+// WithAsync returns as soon as the messages are sent, failures are only
+// reported to the delivery callback
+func WithAsync() ProducerOption {
+	return func(p *Producer) {
+		p.async = true
+	}
+}
+
+// WithDeliveryCallback calls fn with the outcome of every message, fn must
+// not block
+func WithDeliveryCallback(fn func(DeliveryReport)) ProducerOption {
+	return func(p *Producer) {
+		p.onDelivery = fn
+	}
+}
+
+// NewProducer connects to the servers of urls and publishes to subject, a
+// stream must capture subject, see WithStream
+func NewProducer(urls []string, subject string, opts ...ProducerOption) (*Producer, error) {
+	producer := &Producer{
+		subject: subject,
+	}
+	for _, opt := range opts {
+		opt(producer)
+	}
+
+	conn, err := nats.Connect(strings.Join(urls, ","))
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if producer.stream != "" {
+		if err := ensureStream(js, producer.stream, subject); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	producer.conn = conn
+	producer.js = js
+	return producer, nil
+}
+
+// Send publishes data with key, see SendMessage
+func (p *Producer) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+// SendMessage publishes message and waits for the acknowledgement of the
+// stream unless the producer is asynchronous
+func (p *Producer) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+// SendBatch publishes messages one by one, JetStream has no transactions so
+// the messages before a failure are delivered
+func (p *Producer) SendBatch(ctx context.Context, messages []*Message) (err error) {
+	span := jaeger.Start(ctx, ">nats.Producer/Send", ext.SpanKindProducer,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: p.subject})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	for _, message := range messages {
+		msg := p.toMsg(ctx, message)
+		if p.async {
+			future, err := p.js.PublishMsgAsync(msg)
+			if err != nil {
+				return err
+			}
+			p.wg.Add(1)
+			go p.wait(message, future)
+			continue
+		}
+
+		ack, err := p.js.PublishMsg(msg, nats.Context(ctx))
+		if ack != nil {
+			p.deliver(DeliveryReport{Message: message, Stream: ack.Stream, Sequence: ack.Sequence})
+		} else {
+			p.deliver(DeliveryReport{Message: message, Err: err})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close waits for the acknowledgements of asynchronous messages and closes
+// the connection
+func (p *Producer) Close() error {
+	p.wg.Wait()
+	return p.conn.Drain()
+}
+
+func (p *Producer) toMsg(ctx context.Context, message *Message) *nats.Msg {
+	msg := nats.NewMsg(p.subject)
+	msg.Data = message.Value
+	for key, value := range message.Headers {
+		msg.Header.Set(key, value)
+	}
+	if message.Key != "" {
+		msg.Header.Set(HeaderKey, message.Key)
+	}
+	injectSpan(ctx, msg.Header)
+	return msg
+}
+
+func (p *Producer) wait(message *Message, future nats.PubAckFuture) {
+	defer p.wg.Done()
+
+	select {
+	case ack := <-future.Ok():
+		p.deliver(DeliveryReport{Message: message, Stream: ack.Stream, Sequence: ack.Sequence})
+	case err := <-future.Err():
+		p.deliver(DeliveryReport{Message: message, Err: err})
+	}
+}
+
+func (p *Producer) deliver(report DeliveryReport) {
+	if p.onDelivery != nil {
+		p.onDelivery(report)
+	}
+}
+
+// ensureStream creates the stream name capturing subject when it is missing
+func ensureStream(js nats.JetStreamContext, name, subject string) error {
+	if _, err := js.StreamInfo(name); err == nil {
+		return nil
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     name,
+		Subjects: []string{subject},
+	})
+	return err
+}
@@ -1,22 +1,125 @@
 package pubsub
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"lib/pubsub/kafka"
+	"lib/pubsub/nats"
+	"lib/pubsub/pulsar"
+	rabbitmq "lib/pubsub/rabbitMQ"
+	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"go.uber.org/zap"
 )
 
-type IPublisher interface {
-	Send(key string, data []byte) error
+// PublishMode represents how a publisher delivers messages
+type PublishMode int
+
+const (
+	// ModeTransactional publishes every call atomically, only kafka supports
+	// it and the other brokers behave as ModeSync
+	ModeTransactional PublishMode = iota
+	// ModeSync waits until the messages are acknowledged
+	ModeSync
+	// ModeAsync returns as soon as the messages are queued, failures are
+	// only reported to the delivery callback
+	ModeAsync
+)
+
+type (
+	IPublisher interface {
+		Send(key string, data []byte) error
+		// SendMessage publishes message with its headers, partition and timestamp
+		SendMessage(ctx context.Context, message *Message) error
+		// SendBatch publishes messages, atomically in transactional mode
+		SendBatch(ctx context.Context, messages []*Message) error
+		// Close flushes the queued messages
+		Close() error
+	}
+
+	// Message represents a message to publish
+	Message struct {
+		Key     string
+		Value   []byte
+		Headers map[string]string
+		// Partition is used instead of the partitioner when not nil, it is
+		// ignored by brokers without partitions
+		Partition *int32
+		// Timestamp is set by the broker client when zero
+		Timestamp time.Time
+	}
+
+	// PublisherOption represents option of the publisher
+	PublisherOption func(*publisherOptions)
+
+	publisherOptions struct {
+		mode            PublishMode
+		onDelivery      func(message *Message, err error)
+		transactionalID string
+	}
+
+	kafkaPublisher struct {
+		*kafka.Producer
+	}
+
+	natsPublisher struct {
+		*nats.Producer
+	}
+
+	rabbitMQPublisher struct {
+		*rabbitmq.Producer
+	}
+
+	pulsarPublisher struct {
+		*pulsar.Producer
+	}
+)
+
+// WithPublishMode selects how messages are delivered, ModeTransactional by default
+func WithPublishMode(mode PublishMode) PublisherOption {
+	return func(o *publisherOptions) {
+		o.mode = mode
+	}
+}
+
+// WithDeliveryReport calls fn with the outcome of every published message,
+// fn must not block
+func WithDeliveryReport(fn func(message *Message, err error)) PublisherOption {
+	return func(o *publisherOptions) {
+		o.onDelivery = fn
+	}
+}
+
+// WithTransactionalID sets the transactional ID of the kafka producer in
+// ModeTransactional, the brokers fence a previous producer of the same ID so
+// it must be unique among the running publishers. A random ID is generated
+// for every publisher by default
+func WithTransactionalID(id string) PublisherOption {
+	return func(o *publisherOptions) {
+		o.transactionalID = id
+	}
 }
 
-func NewPublisher(brokers []string, topic, party string) IPublisher {
+func NewPublisher(brokers []string, topic, party string, opts ...PublisherOption) IPublisher {
 	var publisher IPublisher
 
+	options := publisherOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	switch party {
 	case "kafka":
-		publisher = newKafkaPublisher(brokers, topic)
+		publisher = newKafkaPublisher(brokers, topic, options)
+	case "nats":
+		publisher = newNATSPublisher(brokers, topic, options)
+	case "rabbitmq":
+		publisher = newRabbitMQPublisher(brokers, topic, options)
+	case "pulsar":
+		publisher = newPulsarPublisher(brokers, topic, options)
 	default:
 		zap.S().Panic("Failed to init publisher")
 	}
@@ -24,19 +127,217 @@ func NewPublisher(brokers []string, topic, party string) IPublisher {
 	return publisher
 }
 
-func newKafkaPublisher(brokers []string, topic string) *kafka.Producer {
+func newKafkaPublisher(brokers []string, topic string, options publisherOptions) *kafkaPublisher {
+	producerOpts := []kafka.ProducerOption{
+		kafka.WithProducerMode(kafka.ProducerMode(options.mode)),
+	}
+	if options.onDelivery != nil {
+		producerOpts = append(producerOpts, kafka.WithDeliveryCallback(func(report kafka.DeliveryReport) {
+			options.onDelivery(report.Message.Metadata.(*Message), report.Err)
+		}))
+	}
+
+	transactionalID := options.transactionalID
+	if transactionalID == "" && options.mode == ModeTransactional {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			zap.S().Panicw("Failed to generate kafka transactional id", "error", err)
+		}
+		transactionalID = "txn_producer-" + hex.EncodeToString(id)
+	}
+
 	producer := kafka.NewProducer(brokers, topic, func() *sarama.Config {
 		ver, _ := sarama.ParseKafkaVersion("2.6.0")
 		config := sarama.NewConfig()
 		config.Version = ver
-		config.Producer.Idempotent = true
-		config.Producer.Return.Errors = false
-		config.Producer.RequiredAcks = sarama.WaitForAll
-		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
-		config.Producer.Transaction.Retry.Backoff = 10
-		config.Producer.Transaction.ID = "txn_producer"
-		config.Net.MaxOpenRequests = 1
+		switch options.mode {
+		case ModeTransactional:
+			config.Producer.Idempotent = true
+			config.Producer.RequiredAcks = sarama.WaitForAll
+			config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+			config.Producer.Transaction.Retry.Backoff = 10
+			config.Producer.Transaction.ID = transactionalID
+			config.Net.MaxOpenRequests = 1
+		case ModeSync:
+			config.Producer.Idempotent = true
+			config.Producer.RequiredAcks = sarama.WaitForAll
+			config.Net.MaxOpenRequests = 1
+		case ModeAsync:
+			config.Producer.RequiredAcks = sarama.WaitForLocal
+			config.Producer.Compression = sarama.CompressionSnappy
+			config.Producer.Flush.Frequency = 100 * time.Millisecond
+		}
 		return config
+	}, producerOpts...)
+	if producer == nil {
+		zap.S().Panic("Failed to init kafka publisher")
+	}
+	return &kafkaPublisher{producer}
+}
+
+func (p *kafkaPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+func (p *kafkaPublisher) SendMessage(ctx context.Context, message *Message) error {
+	return p.Producer.SendMessage(ctx, toKafkaMessage(message))
+}
+
+func (p *kafkaPublisher) SendBatch(ctx context.Context, messages []*Message) error {
+	kafkaMessages := make([]*kafka.Message, len(messages))
+	for index, message := range messages {
+		kafkaMessages[index] = toKafkaMessage(message)
+	}
+	return p.Producer.SendBatch(ctx, kafkaMessages)
+}
+
+func toKafkaMessage(message *Message) *kafka.Message {
+	kafkaMessage := &kafka.Message{
+		Value:     message.Value,
+		Headers:   message.Headers,
+		Partition: message.Partition,
+		Timestamp: message.Timestamp,
+		Metadata:  message,
+	}
+	if message.Key != "" {
+		kafkaMessage.Key = []byte(message.Key)
+	}
+	return kafkaMessage
+}
+
+// newNATSPublisher publishes to the JetStream subject topic, the stream
+// capturing it is created when missing
+func newNATSPublisher(urls []string, subject string, options publisherOptions) *natsPublisher {
+	producerOpts := []nats.ProducerOption{
+		nats.WithStream(strings.NewReplacer(".", "_", "*", "_", ">", "_").Replace(subject)),
+	}
+	if options.mode == ModeAsync {
+		producerOpts = append(producerOpts, nats.WithAsync())
+	}
+	if options.onDelivery != nil {
+		producerOpts = append(producerOpts, nats.WithDeliveryCallback(func(report nats.DeliveryReport) {
+			options.onDelivery(report.Message.Metadata.(*Message), report.Err)
+		}))
+	}
+
+	producer, err := nats.NewProducer(urls, subject, producerOpts...)
+	if err != nil {
+		zap.S().Panicw("Failed to init nats publisher", "error", err)
+	}
+	return &natsPublisher{producer}
+}
+
+func (p *natsPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+func (p *natsPublisher) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+func (p *natsPublisher) SendBatch(ctx context.Context, messages []*Message) error {
+	natsMessages := make([]*nats.Message, len(messages))
+	for index, message := range messages {
+		natsMessages[index] = &nats.Message{
+			Key:      message.Key,
+			Value:    message.Value,
+			Headers:  message.Headers,
+			Metadata: message,
+		}
+	}
+	return p.Producer.SendBatch(ctx, natsMessages)
+}
+
+// newRabbitMQPublisher publishes to the default topic exchange with topic as
+// routing key
+func newRabbitMQPublisher(urls []string, routingKey string, options publisherOptions) *rabbitMQPublisher {
+	var producerOpts []rabbitmq.ProducerOption
+	if options.mode == ModeAsync {
+		producerOpts = append(producerOpts, rabbitmq.WithAsync())
+	}
+	if options.onDelivery != nil {
+		producerOpts = append(producerOpts, rabbitmq.WithDeliveryCallback(func(report rabbitmq.DeliveryReport) {
+			options.onDelivery(report.Message.Metadata.(*Message), report.Err)
+		}))
+	}
+
+	producer, err := rabbitmq.NewProducer(urls, routingKey, producerOpts...)
+	if err != nil {
+		zap.S().Panicw("Failed to init rabbitmq publisher", "error", err)
+	}
+	return &rabbitMQPublisher{producer}
+}
+
+func (p *rabbitMQPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+func (p *rabbitMQPublisher) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+func (p *rabbitMQPublisher) SendBatch(ctx context.Context, messages []*Message) error {
+	rabbitMQMessages := make([]*rabbitmq.Message, len(messages))
+	for index, message := range messages {
+		rabbitMQMessages[index] = &rabbitmq.Message{
+			Key:       message.Key,
+			Value:     message.Value,
+			Headers:   message.Headers,
+			Timestamp: message.Timestamp,
+			Metadata:  message,
+		}
+	}
+	return p.Producer.SendBatch(ctx, rabbitMQMessages)
+}
+
+func newPulsarPublisher(urls []string, topic string, options publisherOptions) *pulsarPublisher {
+	var producerOpts []pulsar.ProducerOption
+	if options.mode == ModeAsync {
+		producerOpts = append(producerOpts, pulsar.WithAsync())
+	}
+	if options.onDelivery != nil {
+		producerOpts = append(producerOpts, pulsar.WithDeliveryCallback(func(report pulsar.DeliveryReport) {
+			options.onDelivery(report.Message.Metadata.(*Message), report.Err)
+		}))
+	}
+
+	producer, err := pulsar.NewProducer(urls, topic, producerOpts...)
+	if err != nil {
+		zap.S().Panicw("Failed to init pulsar publisher", "error", err)
+	}
+	return &pulsarPublisher{producer}
+}
+
+func (p *pulsarPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
 	})
-	return producer
+}
+
+func (p *pulsarPublisher) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+func (p *pulsarPublisher) SendBatch(ctx context.Context, messages []*Message) error {
+	pulsarMessages := make([]*pulsar.Message, len(messages))
+	for index, message := range messages {
+		pulsarMessages[index] = &pulsar.Message{
+			Key:       message.Key,
+			Value:     message.Value,
+			Headers:   message.Headers,
+			Timestamp: message.Timestamp,
+			Metadata:  message,
+		}
+	}
+	return p.Producer.SendBatch(ctx, pulsarMessages)
 }
@@ -1 +1,201 @@
 package pulsar
+
+import (
+	"context"
+	"errors"
+	"lib/opentracing/jaeger"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"go.uber.org/zap"
+)
+
+// ErrConsumerStarted is returned by Subscribe when the consumer already runs
+var ErrConsumerStarted = errors.New("pulsar: consumer already started")
+
+const (
+	defaultNackRedeliveryDelay = time.Second
+	receiveRetryDelay          = time.Second
+)
+
+type (
+	// MessageHandler handles a message, the message is redelivered when an
+	// error is returned
+	MessageHandler func(ctx context.Context, message pulsar.Message) error
+
+	// ConsumerOption represents option of the consumer
+	ConsumerOption func(*Consumer)
+
+	// Consumer consumes topics through a shared subscription, the messages are
+	// spread over the instances of the same subscription
+	Consumer struct {
+		client        pulsar.Client
+		consumer      pulsar.Consumer
+		subscription  string
+		handler       MessageHandler
+		redelivery    time.Duration
+		maxDeliveries uint32
+		deadLetter    string
+
+		mu      sync.Mutex
+		started bool
+		cancel  context.CancelFunc
+		done    chan struct{}
+	}
+)
+
+// WithNackRedeliveryDelay redelivers failed messages after delay, a second
+// by default
+func WithNackRedeliveryDelay(delay time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.redelivery = delay
+	}
+}
+
+// WithDeadLetterTopic publishes the messages failing maxDeliveries times to
+// topic instead of redelivering them
+func WithDeadLetterTopic(topic string, maxDeliveries uint32) ConsumerOption {
+	return func(c *Consumer) {
+		c.deadLetter = topic
+		c.maxDeliveries = maxDeliveries
+	}
+}
+
+// NewConsumer connects to the service urls, messages are acknowledged once
+// handler succeeds and negatively acknowledged otherwise
+func NewConsumer(urls []string, subscription string, handler MessageHandler, opts ...ConsumerOption) (*Consumer, error) {
+	consumer := &Consumer{
+		subscription: subscription,
+		handler:      handler,
+		redelivery:   defaultNackRedeliveryDelay,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(consumer)
+	}
+
+	client, err := newClient(urls)
+	if err != nil {
+		return nil, err
+	}
+	consumer.client = client
+	return consumer, nil
+}
+
+// Subscribe consumes topics in background until ctx is done or Close is called
+func (c *Consumer) Subscribe(ctx context.Context, topics []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return ErrConsumerStarted
+	}
+
+	consumer, err := c.client.Subscribe(c.options(topics))
+	if err != nil {
+		return err
+	}
+	c.consumer = consumer
+	c.started = true
+	ctx, c.cancel = context.WithCancel(ctx)
+
+	go c.consume(ctx)
+	zap.S().Infow("Pulsar consumer up and running", "subscription", c.subscription, "topics", topics)
+	return nil
+}
+
+// Close waits for the message being handled and closes the client
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	started := c.started
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.mu.Unlock()
+
+	if started {
+		<-c.done
+	}
+	c.client.Close()
+	return nil
+}
+
+// options returns the options of the shared subscription to topics
+func (c *Consumer) options(topics []string) pulsar.ConsumerOptions {
+	options := pulsar.ConsumerOptions{
+		Topics:              topics,
+		SubscriptionName:    c.subscription,
+		Type:                pulsar.Shared,
+		NackRedeliveryDelay: c.redelivery,
+	}
+	if c.deadLetter != "" {
+		options.DLQ = &pulsar.DLQPolicy{
+			MaxDeliveries:   c.maxDeliveries,
+			DeadLetterTopic: c.deadLetter,
+		}
+	}
+	return options
+}
+
+func (c *Consumer) consume(ctx context.Context) {
+	defer func() {
+		c.consumer.Close()
+		close(c.done)
+	}()
+
+	for {
+		message, err := c.consumer.Receive(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			zap.S().Warnw("Failed to receive pulsar message", "subscription", c.subscription, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(receiveRetryDelay):
+			}
+			continue
+		}
+
+		msgCtx, span := c.startConsumerSpan(ctx, message)
+		err = c.handler(msgCtx, message)
+		jaeger.Finish(span, err)
+
+		if err != nil {
+			zap.S().Warnw("Failed to handle pulsar message", "topic", message.Topic(), "error", err)
+			c.consumer.Nack(message)
+			continue
+		}
+		c.consumer.Ack(message)
+	}
+}
+
+// startConsumerSpan continues the trace of the producer of message when its
+// properties carry one, the span is stored in the returned context
+func (c *Consumer) startConsumerSpan(ctx context.Context, message pulsar.Message) (context.Context, opentracing.Span) {
+	tags := []opentracing.Tag{
+		{Key: string(ext.MessageBusDestination), Value: message.Topic()},
+		{Key: "pulsar.subscription", Value: c.subscription},
+	}
+
+	var span opentracing.Span
+	spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, opentracing.TextMapCarrier(message.Properties()))
+	if err == nil {
+		span = jaeger.Continue(spanCtx, ">pulsar.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	} else {
+		span = jaeger.Start(ctx, ">pulsar.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	}
+	return opentracing.ContextWithSpan(ctx, span), span
+}
+
+// injectSpan adds the span context of ctx to properties
+func injectSpan(ctx context.Context, properties map[string]string) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	_ = opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, opentracing.TextMapCarrier(properties))
+}
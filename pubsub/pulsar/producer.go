@@ -1 +1,170 @@
 package pulsar
+
+import (
+	"context"
+	"lib/opentracing/jaeger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+type (
+	// Message represents a message to publish
+	Message struct {
+		Key     string
+		Value   []byte
+		Headers map[string]string
+		// Timestamp is the event time of the message, none when zero
+		Timestamp time.Time
+		// Metadata is handed back in the delivery report
+		Metadata interface{}
+	}
+
+	// DeliveryReport represents the outcome of a published message
+	DeliveryReport struct {
+		Message *Message
+		ID      pulsar.MessageID
+		Err     error
+	}
+
+	// ProducerOption represents option of the producer
+	ProducerOption func(*Producer)
+
+	// Producer publishes messages to a topic
+	Producer struct {
+		client     pulsar.Client
+		producer   pulsar.Producer
+		topic      string
+		async      bool
+		onDelivery func(DeliveryReport)
+		wg         sync.WaitGroup
+	}
+)
+
+// WithAsync returns as soon as the messages are queued, failures are only
+// reported to the delivery callback
+func WithAsync() ProducerOption {
+	return func(p *Producer) {
+		p.async = true
+	}
+}
+
+// WithDeliveryCallback calls fn with the outcome of every message, fn must
+// not block
+func WithDeliveryCallback(fn func(DeliveryReport)) ProducerOption {
+	return func(p *Producer) {
+		p.onDelivery = fn
+	}
+}
+
+// NewProducer connects to the service urls such as "pulsar://localhost:6650"
+// and publishes to topic
+func NewProducer(urls []string, topic string, opts ...ProducerOption) (*Producer, error) {
+	producer := &Producer{
+		topic: topic,
+	}
+	for _, opt := range opts {
+		opt(producer)
+	}
+
+	client, err := newClient(urls)
+	if err != nil {
+		return nil, err
+	}
+	pulsarProducer, err := client.CreateProducer(pulsar.ProducerOptions{
+		Topic: topic,
+	})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	producer.client = client
+	producer.producer = pulsarProducer
+	return producer, nil
+}
+
+// Send publishes data with key, see SendMessage
+func (p *Producer) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+// SendMessage publishes message and waits for its acknowledgement unless the
+// producer is asynchronous
+func (p *Producer) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+// SendBatch publishes messages one by one, the messages before a failure are
+// delivered
+func (p *Producer) SendBatch(ctx context.Context, messages []*Message) (err error) {
+	span := jaeger.Start(ctx, ">pulsar.Producer/Send", ext.SpanKindProducer,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: p.topic})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	for _, message := range messages {
+		producerMessage := toProducerMessage(ctx, message)
+		if p.async {
+			message := message
+			p.wg.Add(1)
+			p.producer.SendAsync(ctx, producerMessage, func(id pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+				defer p.wg.Done()
+				p.deliver(DeliveryReport{Message: message, ID: id, Err: err})
+			})
+			continue
+		}
+
+		id, err := p.producer.Send(ctx, producerMessage)
+		p.deliver(DeliveryReport{Message: message, ID: id, Err: err})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the queued messages and closes the client
+func (p *Producer) Close() error {
+	err := p.producer.Flush()
+	p.wg.Wait()
+	p.producer.Close()
+	p.client.Close()
+	return err
+}
+
+func (p *Producer) deliver(report DeliveryReport) {
+	if p.onDelivery != nil {
+		p.onDelivery(report)
+	}
+}
+
+func toProducerMessage(ctx context.Context, message *Message) *pulsar.ProducerMessage {
+	properties := make(map[string]string, len(message.Headers))
+	for key, value := range message.Headers {
+		properties[key] = value
+	}
+	injectSpan(ctx, properties)
+
+	return &pulsar.ProducerMessage{
+		Payload:    message.Value,
+		Key:        message.Key,
+		Properties: properties,
+		EventTime:  message.Timestamp,
+	}
+}
+
+func newClient(urls []string) (pulsar.Client, error) {
+	return pulsar.NewClient(pulsar.ClientOptions{
+		URL: strings.Join(urls, ","),
+	})
+}
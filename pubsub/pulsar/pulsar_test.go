@@ -0,0 +1,139 @@
+package pulsar
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+type (
+	// testConsumer delivers messages and records their acknowledgements
+	testConsumer struct {
+		pulsar.Consumer
+		messages chan pulsar.Message
+
+		mu     sync.Mutex
+		acked  []string
+		nacked []string
+		closed bool
+	}
+
+	// testMessage is a message of the orders topic
+	testMessage struct {
+		pulsar.Message
+		key string
+	}
+)
+
+func (c *testConsumer) Receive(ctx context.Context) (pulsar.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case message := <-c.messages:
+		return message, nil
+	}
+}
+
+func (c *testConsumer) Ack(message pulsar.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked = append(c.acked, message.Key())
+}
+
+func (c *testConsumer) Nack(message pulsar.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nacked = append(c.nacked, message.Key())
+}
+
+func (c *testConsumer) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+// handled returns how many messages were acknowledged either way
+func (c *testConsumer) handled() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.acked) + len(c.nacked)
+}
+
+func (m *testMessage) Key() string {
+	return m.key
+}
+
+func (m *testMessage) Topic() string {
+	return "orders"
+}
+
+func (m *testMessage) Properties() map[string]string {
+	return map[string]string{}
+}
+
+// TestConsumer validates handled messages are acknowledged and failed ones
+// negatively acknowledged for redelivery or the dead-letter topic
+func TestConsumer(t *testing.T) {
+	fake := &testConsumer{messages: make(chan pulsar.Message, 2)}
+	consumer := &Consumer{
+		subscription: "billing",
+		handler: func(ctx context.Context, message pulsar.Message) error {
+			if message.Key() == "declined" {
+				return errors.New("payment declined")
+			}
+			return nil
+		},
+		redelivery: defaultNackRedeliveryDelay,
+		consumer:   fake,
+		done:       make(chan struct{}),
+	}
+	WithDeadLetterTopic("orders.dlq", 3)(consumer)
+
+	t.Log("Given the need to consume messages of a shared subscription")
+	{
+		options := consumer.options([]string{"orders"})
+		if options.Type != pulsar.Shared || options.DLQ == nil || options.DLQ.DeadLetterTopic != "orders.dlq" || options.DLQ.MaxDeliveries != 3 {
+			t.Errorf("\tShould dead letter messages after the max deliveries. %v %+v", ballotX, options.DLQ)
+		} else {
+			t.Logf("\tShould dead letter messages after the max deliveries. %v", checkMark)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go consumer.consume(ctx)
+		fake.messages <- &testMessage{key: "paid"}
+		fake.messages <- &testMessage{key: "declined"}
+		for deadline := time.Now().Add(5 * time.Second); fake.handled() < 2; {
+			if time.Now().After(deadline) {
+				t.Fatalf("\tShould handle the messages. %v", ballotX)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-consumer.done
+
+		if len(fake.acked) != 1 || fake.acked[0] != "paid" {
+			t.Errorf("\tShould acknowledge a handled message. %v %v", ballotX, fake.acked)
+		} else {
+			t.Logf("\tShould acknowledge a handled message. %v", checkMark)
+		}
+		if len(fake.nacked) != 1 || fake.nacked[0] != "declined" {
+			t.Errorf("\tShould negatively acknowledge a failed message. %v %v", ballotX, fake.nacked)
+		} else {
+			t.Logf("\tShould negatively acknowledge a failed message. %v", checkMark)
+		}
+		if !fake.closed {
+			t.Errorf("\tShould close the consumer once done. %v", ballotX)
+		} else {
+			t.Logf("\tShould close the consumer once done. %v", checkMark)
+		}
+	}
+}
@@ -1 +1,412 @@
 package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/opentracing/jaeger"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// ErrConsumerStarted is returned by Subscribe when the consumer already runs
+var ErrConsumerStarted = errors.New("rabbitmq: consumer already started")
+
+const (
+	defaultPrefetch = 1
+	// headerDeliveryCount is set by quorum queues on redelivered messages
+	headerDeliveryCount = "x-delivery-count"
+	reconnectDelay      = time.Second
+	reconnectMaxDelay   = 30 * time.Second
+)
+
+type (
+	// MessageHandler handles a message, the message is requeued when an error
+	// is returned, or dead lettered after the max deliveries of the dead
+	// letter exchange
+	MessageHandler func(ctx context.Context, delivery *amqp.Delivery) error
+
+	// ConsumerOption represents option of the consumer
+	ConsumerOption func(*Consumer)
+
+	// Consumer consumes a durable queue per topic shared by the instances of
+	// the same group, it reconnects when the connection is lost
+	Consumer struct {
+		urls          []string
+		conn          *amqp.Connection
+		channel       *amqp.Channel
+		group         string
+		handler       MessageHandler
+		exchange      string
+		deadLetter    string
+		maxDeliveries int
+		prefetch      int
+
+		mu      sync.Mutex
+		started bool
+		closed  bool
+		done    chan struct{}
+		tags    []string
+		wg      sync.WaitGroup
+	}
+)
+
+// WithConsumerExchange binds the queues to the topic exchange name,
+// DefaultExchange by default
+func WithConsumerExchange(name string) ConsumerOption {
+	return func(c *Consumer) {
+		c.exchange = name
+	}
+}
+
+// WithDeadLetterExchange routes the messages failing maxDeliveries times to
+// the fanout exchange name, a queue of the same name is bound to it. With more
+// than one delivery the queues are quorum queues counting the deliveries, a
+// classic queue declared before must be deleted first
+func WithDeadLetterExchange(name string, maxDeliveries int) ConsumerOption {
+	return func(c *Consumer) {
+		c.deadLetter = name
+		c.maxDeliveries = maxDeliveries
+	}
+}
+
+// WithPrefetch sets how many unacknowledged messages the broker sends to the
+// consumer, 1 by default
+func WithPrefetch(count int) ConsumerOption {
+	return func(c *Consumer) {
+		c.prefetch = count
+	}
+}
+
+// NewConsumer connects to the first reachable broker of urls, messages are
+// acknowledged once handler succeeds
+func NewConsumer(urls []string, group string, handler MessageHandler, opts ...ConsumerOption) (*Consumer, error) {
+	consumer := &Consumer{
+		urls:     urls,
+		group:    group,
+		handler:  handler,
+		exchange: DefaultExchange,
+		prefetch: defaultPrefetch,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(consumer)
+	}
+
+	conn, channel, err := consumer.open()
+	if err != nil {
+		return nil, err
+	}
+	consumer.conn = conn
+	consumer.channel = channel
+	return consumer, nil
+}
+
+// Subscribe consumes topics in background until ctx is done or Close is
+// called, the queue of a topic is named after the group and the topic
+func (c *Consumer) Subscribe(ctx context.Context, topics []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return ErrConsumerStarted
+	}
+	c.started = true
+
+	deliveries, err := c.consumeQueues(topics)
+	if err != nil {
+		return err
+	}
+	c.wg.Add(1)
+	go c.run(ctx, topics, deliveries)
+	zap.S().Infow("RabbitMQ consumer up and running", "group", c.group, "topics", topics)
+
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+	return nil
+}
+
+// Close waits for the messages being handled and closes the connection
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	c.cancel()
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	if c.conn.IsClosed() {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// open connects to the first reachable broker and declares the exchanges
+func (c *Consumer) open() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := dial(c.urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	channel, err := conn.Channel()
+	if err == nil {
+		err = channel.Qos(c.prefetch, 0, false)
+	}
+	if err == nil {
+		err = declareExchange(channel, c.exchange)
+	}
+	if err == nil {
+		err = c.declareDeadLetter(channel)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, channel, nil
+}
+
+// consumeQueues declares the queues of topics and starts consuming them,
+// the deliveries are in the order of topics
+func (c *Consumer) consumeQueues(topics []string) ([]<-chan amqp.Delivery, error) {
+	deliveries := make([]<-chan amqp.Delivery, len(topics))
+	for index, topic := range topics {
+		queue, err := c.declareQueue(topic)
+		if err != nil {
+			c.cancel()
+			return nil, err
+		}
+		tag := fmt.Sprintf("%s-%s", c.group, topic)
+		if deliveries[index], err = c.channel.Consume(queue, tag, false, false, false, false, nil); err != nil {
+			c.cancel()
+			return nil, err
+		}
+		c.tags = append(c.tags, tag)
+	}
+	return deliveries, nil
+}
+
+// run consumes deliveries until the consumer is closed, the queues are
+// consumed again once reconnected when the connection is lost
+func (c *Consumer) run(ctx context.Context, topics []string, deliveries []<-chan amqp.Delivery) {
+	defer c.wg.Done()
+
+	for deliveries != nil {
+		var wg sync.WaitGroup
+		for index, topic := range topics {
+			wg.Add(1)
+			go func(topic string, deliveries <-chan amqp.Delivery) {
+				defer wg.Done()
+				c.consume(ctx, topic, deliveries)
+			}(topic, deliveries[index])
+		}
+		wg.Wait()
+		deliveries = c.reconnect(ctx, topics)
+	}
+}
+
+// reconnect opens a new connection until the queues of topics are consumed
+// again, it returns nil once the consumer is closed
+func (c *Consumer) reconnect(ctx context.Context, topics []string) []<-chan amqp.Delivery {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed || ctx.Err() != nil {
+		return nil
+	}
+	zap.S().Errorw("RabbitMQ connection lost, reconnecting", "group", c.group, "topics", topics)
+
+	delay := reconnectDelay
+	for {
+		deliveries, err := c.resubscribe(topics)
+		if err == nil {
+			if deliveries != nil {
+				zap.S().Infow("RabbitMQ consumer reconnected", "group", c.group, "topics", topics)
+			}
+			return deliveries
+		}
+
+		zap.S().Warnw("Failed to reconnect to rabbitmq", "group", c.group, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.done:
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+}
+
+// resubscribe replaces the connection and consumes the queues of topics, it
+// returns no deliveries when the consumer was closed meanwhile
+func (c *Consumer) resubscribe(topics []string) ([]<-chan amqp.Delivery, error) {
+	c.mu.Lock()
+	if !c.conn.IsClosed() {
+		_ = c.conn.Close()
+	}
+	c.tags = nil
+	c.mu.Unlock()
+
+	conn, channel, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = conn.Close()
+		return nil, nil
+	}
+	c.conn, c.channel = conn, channel
+	return c.consumeQueues(topics)
+}
+
+// consume handles deliveries until they are closed, once the consumer is
+// cancelled or the connection lost
+func (c *Consumer) consume(ctx context.Context, topic string, deliveries <-chan amqp.Delivery) {
+	for delivery := range deliveries {
+		delivery := delivery
+		c.handle(ctx, topic, &delivery)
+	}
+}
+
+// handle acknowledges delivery once handled, a failed delivery is requeued
+// until its max deliveries then dead lettered
+func (c *Consumer) handle(ctx context.Context, topic string, delivery *amqp.Delivery) {
+	msgCtx, span := c.startConsumerSpan(ctx, delivery)
+	err := c.handler(msgCtx, delivery)
+	jaeger.Finish(span, err)
+
+	if err != nil {
+		requeue := c.requeue(delivery)
+		zap.S().Warnw("Failed to handle rabbitmq message", "topic", topic, "requeue", requeue, "error", err)
+		err = delivery.Nack(false, requeue)
+	} else {
+		err = delivery.Ack(false)
+	}
+	if err != nil {
+		zap.S().Warnw("Failed to acknowledge rabbitmq message", "topic", topic, "error", err)
+	}
+}
+
+// requeue reports whether a failed delivery is delivered again, without dead
+// letter exchange it always is
+func (c *Consumer) requeue(delivery *amqp.Delivery) bool {
+	if c.deadLetter == "" {
+		return true
+	}
+	return deliveryCount(delivery)+1 < c.maxDeliveries
+}
+
+// cancel stops the deliveries of the broker, the deliveries already received
+// are still handled
+func (c *Consumer) cancel() {
+	for _, tag := range c.tags {
+		if err := c.channel.Cancel(tag, false); err != nil {
+			zap.S().Warnw("Failed to cancel rabbitmq consumer", "tag", tag, "error", err)
+		}
+	}
+	c.tags = nil
+}
+
+// declareQueue declares the durable queue of topic bound to the exchange
+func (c *Consumer) declareQueue(topic string) (string, error) {
+	var args amqp.Table
+	if c.deadLetter != "" {
+		args = amqp.Table{"x-dead-letter-exchange": c.deadLetter}
+		// quorum queues count the deliveries and dead letter the messages
+		// requeued more than the limit should the consumer crash meanwhile
+		if c.maxDeliveries > 1 {
+			args["x-queue-type"] = "quorum"
+			args["x-delivery-limit"] = c.maxDeliveries
+		}
+	}
+
+	queue, err := c.channel.QueueDeclare(c.group+"."+topic, true, false, false, false, args)
+	if err != nil {
+		return "", err
+	}
+	return queue.Name, c.channel.QueueBind(queue.Name, topic, c.exchange, false, nil)
+}
+
+// declareDeadLetter declares the dead letter exchange and its queue
+func (c *Consumer) declareDeadLetter(channel *amqp.Channel) error {
+	if c.deadLetter == "" {
+		return nil
+	}
+	if err := channel.ExchangeDeclare(c.deadLetter, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := channel.QueueDeclare(c.deadLetter, true, false, false, false, nil); err != nil {
+		return err
+	}
+	return channel.QueueBind(c.deadLetter, "", c.deadLetter, false, nil)
+}
+
+// startConsumerSpan continues the trace of the producer of delivery when its
+// headers carry one, the span is stored in the returned context
+func (c *Consumer) startConsumerSpan(ctx context.Context, delivery *amqp.Delivery) (context.Context, opentracing.Span) {
+	tags := []opentracing.Tag{
+		{Key: string(ext.MessageBusDestination), Value: delivery.RoutingKey},
+		{Key: "rabbitmq.group", Value: c.group},
+	}
+
+	var span opentracing.Span
+	spanCtx, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, tableCarrier(delivery.Headers))
+	if err == nil {
+		span = jaeger.Continue(spanCtx, ">rabbitmq.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	} else {
+		span = jaeger.Start(ctx, ">rabbitmq.Consumer/Handle", ext.SpanKindConsumer, tags...)
+	}
+	return opentracing.ContextWithSpan(ctx, span), span
+}
+
+// tableCarrier reads and writes span contexts in AMQP headers
+type tableCarrier amqp.Table
+
+func (t tableCarrier) Set(key, val string) {
+	t[key] = val
+}
+
+func (t tableCarrier) ForeachKey(handler func(key, val string) error) error {
+	for key, value := range t {
+		if val, ok := value.(string); ok {
+			if err := handler(key, val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// injectSpan adds the span context of ctx to headers
+func injectSpan(ctx context.Context, headers amqp.Table) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	_ = opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, tableCarrier(headers))
+}
+
+// deliveryCount returns how many times delivery was delivered before
+func deliveryCount(delivery *amqp.Delivery) int {
+	switch count := delivery.Headers[headerDeliveryCount].(type) {
+	case int64:
+		return int(count)
+	case int32:
+		return int(count)
+	case int:
+		return count
+	}
+	return 0
+}
@@ -1 +1,337 @@
 package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"lib/opentracing/jaeger"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderKey carries the key of a message since AMQP messages have none
+	HeaderKey = "x-message-key"
+	// DefaultExchange is the topic exchange used when none is configured
+	DefaultExchange = "amq.topic"
+)
+
+var (
+	// ErrNacked is returned when the broker did not take responsibility for
+	// a message
+	ErrNacked = errors.New("rabbitmq: message nacked by the broker")
+	// ErrDisconnected is returned while the producer reconnects after the
+	// connection or its channel was closed
+	ErrDisconnected = errors.New("rabbitmq: producer disconnected, reconnecting")
+	// ErrProducerClosed is returned once the producer is closed
+	ErrProducerClosed = errors.New("rabbitmq: producer closed")
+)
+
+type (
+	// Message represents a message to publish
+	Message struct {
+		Key     string
+		Value   []byte
+		Headers map[string]string
+		// Timestamp is set by the producer when zero
+		Timestamp time.Time
+		// Metadata is handed back in the delivery report
+		Metadata interface{}
+	}
+
+	// DeliveryReport represents the outcome of a published message
+	DeliveryReport struct {
+		Message *Message
+		Err     error
+	}
+
+	// ProducerOption represents option of the producer
+	ProducerOption func(*Producer)
+
+	// Producer publishes persistent messages to a topic exchange with
+	// publisher confirms enabled, it reconnects when the connection or its
+	// channel is closed
+	Producer struct {
+		urls       []string
+		exchange   string
+		routingKey string
+		async      bool
+		onDelivery func(DeliveryReport)
+		wg         sync.WaitGroup
+
+		mu      sync.RWMutex
+		conn    *amqp.Connection
+		channel *amqp.Channel
+		closed  bool
+		done    chan struct{}
+	}
+)
+
+// WithExchange publishes to the topic exchange name, it is declared when it
+// does not exist
+func WithExchange(name string) ProducerOption {
+	return func(p *Producer) {
+		p.exchange = name
+	}
+}
+
+// WithAsync returns as soon as the messages are sent, failures are only
+// reported to the delivery callback
+func WithAsync() ProducerOption {
+	return func(p *Producer) {
+		p.async = true
+	}
+}
+
+// WithDeliveryCallback calls fn with the outcome of every message, fn must
+// not block
+func WithDeliveryCallback(fn func(DeliveryReport)) ProducerOption {
+	return func(p *Producer) {
+		p.onDelivery = fn
+	}
+}
+
+// NewProducer connects to the first reachable broker of urls and publishes
+// to the exchange with routingKey
+func NewProducer(urls []string, routingKey string, opts ...ProducerOption) (*Producer, error) {
+	producer := &Producer{
+		urls:       urls,
+		exchange:   DefaultExchange,
+		routingKey: routingKey,
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(producer)
+	}
+
+	conn, channel, err := producer.open()
+	if err != nil {
+		return nil, err
+	}
+	producer.conn, producer.channel = conn, channel
+	producer.wg.Add(1)
+	go producer.watch(channel)
+	return producer, nil
+}
+
+// Send publishes data with key, see SendMessage
+func (p *Producer) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &Message{
+		Key:   key,
+		Value: data,
+	})
+}
+
+// SendMessage publishes message and waits for its confirmation unless the
+// producer is asynchronous
+func (p *Producer) SendMessage(ctx context.Context, message *Message) error {
+	return p.SendBatch(ctx, []*Message{message})
+}
+
+// SendBatch publishes messages then waits for their confirmations, AMQP has
+// no atomic publishing so the messages confirmed before a failure are delivered
+func (p *Producer) SendBatch(ctx context.Context, messages []*Message) (err error) {
+	span := jaeger.Start(ctx, ">rabbitmq.Producer/Send", ext.SpanKindProducer,
+		opentracing.Tag{Key: string(ext.MessageBusDestination), Value: p.routingKey})
+	defer func() {
+		jaeger.Finish(span, err)
+	}()
+	ctx = opentracing.ContextWithSpan(ctx, span)
+
+	channel, err := p.current()
+	if err != nil {
+		return err
+	}
+	confirmations := make([]*amqp.DeferredConfirmation, len(messages))
+	for index, message := range messages {
+		confirmation, err := channel.PublishWithDeferredConfirmWithContext(ctx, p.exchange, p.routingKey, false, false, p.toPublishing(ctx, message))
+		if err != nil {
+			return err
+		}
+		confirmations[index] = confirmation
+	}
+
+	if p.async {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for index, confirmation := range confirmations {
+				p.deliver(messages[index], confirmed(confirmation.Wait()))
+			}
+		}()
+		return nil
+	}
+
+	for index, confirmation := range confirmations {
+		acked, err := confirmation.WaitContext(ctx)
+		if err == nil {
+			err = confirmed(acked)
+		}
+		p.deliver(messages[index], err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close waits for the confirmations of asynchronous messages and closes the
+// connection
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn.IsClosed() {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+// current returns the channel to publish on, the messages published on a
+// channel closed meanwhile are nacked
+func (p *Producer) current() (*amqp.Channel, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch {
+	case p.closed:
+		return nil, ErrProducerClosed
+	case p.channel.IsClosed():
+		return nil, ErrDisconnected
+	}
+	return p.channel, nil
+}
+
+// open connects to the first reachable broker and opens a channel in
+// confirm mode
+func (p *Producer) open() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := dial(p.urls)
+	if err != nil {
+		return nil, nil, err
+	}
+	channel, err := conn.Channel()
+	if err == nil {
+		err = declareExchange(channel, p.exchange)
+	}
+	if err == nil {
+		err = channel.Confirm(false)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, channel, nil
+}
+
+// watch reconnects once channel, or its connection, is closed until the
+// producer is closed
+func (p *Producer) watch(channel *amqp.Channel) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case amqpErr := <-channel.NotifyClose(make(chan *amqp.Error, 1)):
+			zap.S().Errorw("RabbitMQ producer channel closed, reconnecting", "routing_key", p.routingKey, "error", amqpErr)
+		}
+
+		delay := reconnectDelay
+		for {
+			conn, reopened, err := p.open()
+			if err == nil {
+				p.mu.Lock()
+				if p.closed {
+					p.mu.Unlock()
+					_ = conn.Close()
+					return
+				}
+				if !p.conn.IsClosed() {
+					_ = p.conn.Close()
+				}
+				p.conn, p.channel = conn, reopened
+				p.mu.Unlock()
+				channel = reopened
+				zap.S().Infow("RabbitMQ producer reconnected", "routing_key", p.routingKey)
+				break
+			}
+
+			zap.S().Warnw("Failed to reconnect to rabbitmq", "routing_key", p.routingKey, "error", err)
+			select {
+			case <-p.done:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > reconnectMaxDelay {
+				delay = reconnectMaxDelay
+			}
+		}
+	}
+}
+
+func (p *Producer) toPublishing(ctx context.Context, message *Message) amqp.Publishing {
+	publishing := amqp.Publishing{
+		Headers:      amqp.Table{},
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    message.Timestamp,
+		Body:         message.Value,
+	}
+	if publishing.Timestamp.IsZero() {
+		publishing.Timestamp = time.Now()
+	}
+	for key, value := range message.Headers {
+		publishing.Headers[key] = value
+	}
+	if message.Key != "" {
+		publishing.Headers[HeaderKey] = message.Key
+	}
+	injectSpan(ctx, publishing.Headers)
+	return publishing
+}
+
+func (p *Producer) deliver(message *Message, err error) {
+	if p.onDelivery != nil {
+		p.onDelivery(DeliveryReport{Message: message, Err: err})
+	}
+}
+
+func confirmed(acked bool) error {
+	if !acked {
+		return ErrNacked
+	}
+	return nil
+}
+
+// dial connects to the first reachable broker of urls
+func dial(urls []string) (*amqp.Connection, error) {
+	err := errors.New("rabbitmq: missing broker url")
+	for _, url := range urls {
+		var conn *amqp.Connection
+		conn, err = amqp.Dial(url)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// declareExchange declares the durable topic exchange name, the predeclared
+// exchanges can not be declared again
+func declareExchange(channel *amqp.Channel, name string) error {
+	if name == DefaultExchange {
+		return nil
+	}
+	return channel.ExchangeDeclare(name, amqp.ExchangeTopic, true, false, false, false, nil)
+}
@@ -0,0 +1,100 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// testAcknowledger records how the deliveries were acknowledged by tag
+type testAcknowledger struct {
+	mu      sync.Mutex
+	acked   map[uint64]bool
+	requeue map[uint64]bool
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked[tag] = true
+	return nil
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requeue[tag] = requeue
+	return nil
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// TestConsumerAcknowledgements validates handled deliveries are acknowledged
+// and failed ones requeued until their max deliveries
+func TestConsumerAcknowledgements(t *testing.T) {
+	acknowledger := &testAcknowledger{acked: map[uint64]bool{}, requeue: map[uint64]bool{}}
+	consumer := &Consumer{
+		group: "billing",
+		handler: func(ctx context.Context, delivery *amqp.Delivery) error {
+			if string(delivery.Body) == "declined" {
+				return errors.New("payment declined")
+			}
+			return nil
+		},
+	}
+	WithDeadLetterExchange("billing.dlx", 3)(consumer)
+
+	deliveries := make(chan amqp.Delivery, 4)
+	for tag, delivery := range map[uint64]amqp.Delivery{
+		1: {Body: []byte("paid")},
+		2: {Body: []byte("declined")},
+		3: {Body: []byte("declined"), Headers: amqp.Table{headerDeliveryCount: int64(1)}},
+		4: {Body: []byte("declined"), Headers: amqp.Table{headerDeliveryCount: int64(2)}},
+	} {
+		delivery.DeliveryTag, delivery.Acknowledger = tag, acknowledger
+		deliveries <- delivery
+	}
+	close(deliveries)
+
+	t.Log("Given the need to acknowledge the deliveries of a queue")
+	{
+		consumer.consume(context.Background(), "orders", deliveries)
+
+		if !acknowledger.acked[1] {
+			t.Errorf("\tShould acknowledge a handled delivery. %v", ballotX)
+		} else {
+			t.Logf("\tShould acknowledge a handled delivery. %v", checkMark)
+		}
+		if requeue, ok := acknowledger.requeue[2]; !ok || !requeue || !acknowledger.requeue[3] {
+			t.Errorf("\tShould requeue a failed delivery before its max deliveries. %v %v", ballotX, acknowledger.requeue)
+		} else {
+			t.Logf("\tShould requeue a failed delivery before its max deliveries. %v", checkMark)
+		}
+		if requeue, ok := acknowledger.requeue[4]; !ok || requeue {
+			t.Errorf("\tShould dead letter a delivery failing its last delivery. %v %v", ballotX, acknowledger.requeue)
+		} else {
+			t.Logf("\tShould dead letter a delivery failing its last delivery. %v", checkMark)
+		}
+
+		t.Log("\tWhen the queue has no dead letter exchange")
+		{
+			consumer.deadLetter = ""
+			delivery := &amqp.Delivery{Body: []byte("declined"), Headers: amqp.Table{headerDeliveryCount: int64(5)}}
+			if !consumer.requeue(delivery) {
+				t.Errorf("\t\tShould always requeue a failed delivery. %v", ballotX)
+			} else {
+				t.Logf("\t\tShould always requeue a failed delivery. %v", checkMark)
+			}
+		}
+	}
+}
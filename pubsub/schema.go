@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrIncompatibleSchema is returned when a schema can not read the data
+	// written with the latest schema of its subject
+	ErrIncompatibleSchema = errors.New("pubsub: incompatible schema")
+	// ErrSchemaNotFound is returned when no schema is registered with an ID
+	ErrSchemaNotFound = errors.New("pubsub: schema not found")
+	// ErrSchemaMismatch is returned when the schema registered with the ID of
+	// a message is not the schema the message was written with
+	ErrSchemaMismatch = errors.New("pubsub: schema mismatch")
+)
+
+type (
+	// Schema represents a registered schema
+	Schema struct {
+		ID      int
+		Subject string
+		Version int
+		// Codec is the name of the codec the schema belongs to
+		Codec      string
+		Definition string
+		// Fingerprint identifies the codec and definition across registries
+		Fingerprint string
+	}
+
+	// SchemaRegistry stores the schemas of message values by subject
+	SchemaRegistry interface {
+		// Register returns the schema of subject with definition, a new
+		// version is registered when it is compatible with the latest one
+		Register(ctx context.Context, subject string, codec Codec, definition string) (Schema, error)
+		// Lookup returns the schema registered with id
+		Lookup(ctx context.Context, id int) (Schema, error)
+	}
+
+	localSchemaRegistry struct {
+		mu       sync.RWMutex
+		schemas  map[int]Schema
+		subjects map[string][]Schema
+	}
+)
+
+// NewLocalSchemaRegistry creates an in-memory schema registry, its IDs are
+// local to the process. Messages carry the fingerprint of their schema so a
+// message whose ID was registered with another schema is rejected
+func NewLocalSchemaRegistry() SchemaRegistry {
+	return &localSchemaRegistry{
+		schemas:  make(map[int]Schema),
+		subjects: make(map[string][]Schema),
+	}
+}
+
+func (r *localSchemaRegistry) Register(ctx context.Context, subject string, codec Codec, definition string) (Schema, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	versions := r.subjects[subject]
+	for _, schema := range versions {
+		if schema.Codec == codec.Name() && schema.Definition == definition {
+			return schema, nil
+		}
+	}
+	if len(versions) != 0 {
+		latest := versions[len(versions)-1]
+		if latest.Codec != codec.Name() {
+			return Schema{}, fmt.Errorf("%w: codec of %s changed from %s to %s", ErrIncompatibleSchema, subject, latest.Codec, codec.Name())
+		}
+		if err := codec.Compatible(definition, latest.Definition); err != nil {
+			return Schema{}, fmt.Errorf("%w: %s: %v", ErrIncompatibleSchema, subject, err)
+		}
+	}
+
+	schema := Schema{
+		ID:          len(r.schemas) + 1,
+		Subject:     subject,
+		Version:     len(versions) + 1,
+		Codec:       codec.Name(),
+		Definition:  definition,
+		Fingerprint: Fingerprint(codec.Name(), definition),
+	}
+	r.schemas[schema.ID] = schema
+	r.subjects[subject] = append(versions, schema)
+	return schema, nil
+}
+
+func (r *localSchemaRegistry) Lookup(ctx context.Context, id int) (Schema, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schema, ok := r.schemas[id]
+	if !ok {
+		return Schema{}, ErrSchemaNotFound
+	}
+	return schema, nil
+}
+
+// Fingerprint returns the SHA-256 of the codec name and the definition of a
+// schema, registries must set it on the schemas they return
+func Fingerprint(codec, definition string) string {
+	sum := sha256.Sum256([]byte(codec + "\x00" + definition))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,22 +3,38 @@ package pubsub
 import (
 	"context"
 	"lib/pubsub/kafka"
+	"lib/pubsub/nats"
+	"lib/pubsub/pulsar"
+	rabbitmq "lib/pubsub/rabbitMQ"
 
 	"github.com/Shopify/sarama"
+	pulsarclient "github.com/apache/pulsar-client-go/pulsar"
+	natsclient "github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
 	"go.uber.org/zap"
 )
 
+// maxDeliveries bounds the redeliveries of a failing message by the brokers
+// other than kafka
+const maxDeliveries = 10
+
 type ISubscriber interface {
 	Close() error
 	Subscribe(context.Context, []string) error
 }
 
-func NewSubscriber(ctx context.Context, brokers []string, party, groupID string, fnMessageHandler func(interface{})) ISubscriber {
+func NewSubscriber(ctx context.Context, brokers []string, party, groupID string, fnMessageHandler func(context.Context, interface{}) error) ISubscriber {
 	var subscriber ISubscriber
 
 	switch party {
 	case "kafka":
 		subscriber = newKafkaConsumer(ctx, brokers, groupID, fnMessageHandler)
+	case "nats":
+		subscriber = newNATSConsumer(brokers, groupID, fnMessageHandler)
+	case "rabbitmq":
+		subscriber = newRabbitMQConsumer(brokers, groupID, fnMessageHandler)
+	case "pulsar":
+		subscriber = newPulsarConsumer(brokers, groupID, fnMessageHandler)
 	default:
 		zap.S().Panic("Failed to init subscriber")
 	}
@@ -26,7 +42,7 @@ func NewSubscriber(ctx context.Context, brokers []string, party, groupID string,
 	return subscriber
 }
 
-func newKafkaConsumer(ctx context.Context, brokers []string, groupID string, fnMessageHandler func(interface{})) *kafka.ConsumerGroup {
+func newKafkaConsumer(ctx context.Context, brokers []string, groupID string, fnMessageHandler func(context.Context, interface{}) error) *kafka.ConsumerGroup {
 
 	consumerGroup := kafka.NewConsumerGroup(ctx, brokers, groupID, func() *sarama.Config {
 		ver, _ := sarama.ParseKafkaVersion("2.6.0")
@@ -40,7 +56,45 @@ func newKafkaConsumer(ctx context.Context, brokers []string, groupID string, fnM
 		config.Producer.Transaction.ID = "txn_producer"
 		config.Net.MaxOpenRequests = 1
 		return config
-	}, fnMessageHandler)
+	}, func(ctx context.Context, message *sarama.ConsumerMessage) error {
+		return fnMessageHandler(ctx, message)
+	})
 
 	return consumerGroup
 }
+
+// newNATSConsumer consumes through durable consumers named after groupID,
+// messages are dropped after maxDeliveries attempts
+func newNATSConsumer(urls []string, groupID string, fnMessageHandler func(context.Context, interface{}) error) *nats.Consumer {
+	consumer, err := nats.NewConsumer(urls, groupID, func(ctx context.Context, message *natsclient.Msg) error {
+		return fnMessageHandler(ctx, message)
+	}, nats.WithMaxDeliver(maxDeliveries))
+	if err != nil {
+		zap.S().Panicw("Failed to init nats subscriber", "error", err)
+	}
+	return consumer
+}
+
+// newRabbitMQConsumer consumes queues named after groupID, messages failing
+// maxDeliveries times are dead lettered to groupID.dlx
+func newRabbitMQConsumer(urls []string, groupID string, fnMessageHandler func(context.Context, interface{}) error) *rabbitmq.Consumer {
+	consumer, err := rabbitmq.NewConsumer(urls, groupID, func(ctx context.Context, delivery *amqp.Delivery) error {
+		return fnMessageHandler(ctx, delivery)
+	}, rabbitmq.WithDeadLetterExchange(groupID+".dlx", maxDeliveries))
+	if err != nil {
+		zap.S().Panicw("Failed to init rabbitmq subscriber", "error", err)
+	}
+	return consumer
+}
+
+// newPulsarConsumer consumes through the shared subscription groupID, messages
+// failing maxDeliveries times are sent to groupID-DLQ
+func newPulsarConsumer(urls []string, groupID string, fnMessageHandler func(context.Context, interface{}) error) *pulsar.Consumer {
+	consumer, err := pulsar.NewConsumer(urls, groupID, func(ctx context.Context, message pulsarclient.Message) error {
+		return fnMessageHandler(ctx, message)
+	}, pulsar.WithDeadLetterTopic(groupID+"-DLQ", maxDeliveries))
+	if err != nil {
+		zap.S().Panicw("Failed to init pulsar subscriber", "error", err)
+	}
+	return consumer
+}
@@ -0,0 +1,183 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/pubsub/nats"
+	rabbitmq "lib/pubsub/rabbitMQ"
+	"reflect"
+	"strconv"
+
+	"github.com/Shopify/sarama"
+	pulsarclient "github.com/apache/pulsar-client-go/pulsar"
+	natsclient "github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headers written by Publish
+const (
+	HeaderSchemaID          = "x-schema-id"
+	HeaderSchemaFingerprint = "x-schema-fingerprint"
+	HeaderContentType       = "content-type"
+)
+
+type (
+	// Topic binds the values of T to a codec and the schema registered for them
+	Topic[T any] struct {
+		codec    Codec
+		registry SchemaRegistry
+		schema   Schema
+		newValue func() T
+	}
+
+	// Envelope represents a decoded message
+	Envelope[T any] struct {
+		Key     string
+		Value   T
+		Headers map[string]string
+		// Schema is the schema the message was written with
+		Schema Schema
+		// Raw is the message of the broker client
+		Raw interface{}
+	}
+)
+
+// NewTopic registers the schema of T under subject, it fails with
+// ErrIncompatibleSchema when T can not read the latest registered version
+func NewTopic[T any](ctx context.Context, subject string, codec Codec, registry SchemaRegistry) (*Topic[T], error) {
+	topic := &Topic[T]{
+		codec:    codec,
+		registry: registry,
+	}
+	if valueType := reflect.TypeOf((*T)(nil)).Elem(); valueType.Kind() == reflect.Pointer {
+		elemType := valueType.Elem()
+		topic.newValue = func() T {
+			return reflect.New(elemType).Interface().(T)
+		}
+	}
+
+	definition, err := codec.Schema(topic.value())
+	if err != nil {
+		return nil, err
+	}
+	topic.schema, err = registry.Register(ctx, subject, codec, definition)
+	if err != nil {
+		return nil, err
+	}
+	return topic, nil
+}
+
+// Schema returns the schema values are published with
+func (t *Topic[T]) Schema() Schema {
+	return t.schema
+}
+
+// Publish encodes value and sends it with the schema ID and fingerprint in its headers
+func Publish[T any](ctx context.Context, publisher IPublisher, topic *Topic[T], key string, value T) error {
+	data, err := topic.codec.Marshal(topic.schema.Definition, value)
+	if err != nil {
+		return err
+	}
+	return publisher.SendMessage(ctx, &Message{
+		Key:   key,
+		Value: data,
+		Headers: map[string]string{
+			HeaderSchemaID:          strconv.Itoa(topic.schema.ID),
+			HeaderSchemaFingerprint: topic.schema.Fingerprint,
+			HeaderContentType:       topic.codec.Name(),
+		},
+	})
+}
+
+// Subscribe returns a message handler for NewSubscriber decoding values with
+// the schema they were written with before calling fn. Messages without
+// schema ID are decoded with the schema of topic, messages whose fingerprint
+// differs from the schema registered with their ID fail with ErrSchemaMismatch
+func Subscribe[T any](topic *Topic[T], fn func(ctx context.Context, envelope Envelope[T]) error) func(ctx context.Context, message interface{}) error {
+	return func(ctx context.Context, message interface{}) error {
+		key, data, headers, err := messageContent(message)
+		if err != nil {
+			return err
+		}
+
+		schema := topic.schema
+		fingerprint, hasFingerprint := headers[HeaderSchemaFingerprint]
+		if header, ok := headers[HeaderSchemaID]; ok && (!hasFingerprint || fingerprint != schema.Fingerprint) {
+			id, err := strconv.Atoi(header)
+			if err != nil {
+				return fmt.Errorf("invalid schema ID %q: %w", header, err)
+			}
+			if schema, err = topic.registry.Lookup(ctx, id); err != nil {
+				return err
+			}
+			if hasFingerprint && fingerprint != schema.Fingerprint {
+				return fmt.Errorf("%w: schema %d of %s is not the schema %s the message was written with", ErrSchemaMismatch, id, schema.Subject, fingerprint)
+			}
+			if schema.Subject != topic.schema.Subject || schema.Codec != topic.codec.Name() {
+				return fmt.Errorf("%w: message of %s written as %s", ErrIncompatibleSchema, topic.schema.Subject, schema.Subject)
+			}
+		}
+
+		envelope := Envelope[T]{
+			Key:     key,
+			Headers: headers,
+			Schema:  schema,
+			Raw:     message,
+		}
+		if topic.newValue != nil {
+			envelope.Value = topic.newValue()
+			err = topic.codec.Unmarshal(schema.Definition, data, envelope.Value)
+		} else {
+			err = topic.codec.Unmarshal(schema.Definition, data, &envelope.Value)
+		}
+		if err != nil {
+			return err
+		}
+		return fn(ctx, envelope)
+	}
+}
+
+func (t *Topic[T]) value() (value T) {
+	if t.newValue != nil {
+		return t.newValue()
+	}
+	return value
+}
+
+// MessageHeaders returns the headers of a message received from a broker
+func MessageHeaders(message interface{}) (map[string]string, error) {
+	_, _, headers, err := messageContent(message)
+	return headers, err
+}
+
+// messageContent extracts key, value and headers of a message received from a broker
+func messageContent(message interface{}) (string, []byte, map[string]string, error) {
+	switch m := message.(type) {
+	case *sarama.ConsumerMessage:
+		headers := make(map[string]string, len(m.Headers))
+		for _, header := range m.Headers {
+			headers[string(header.Key)] = string(header.Value)
+		}
+		return string(m.Key), m.Value, headers, nil
+	case *natsclient.Msg:
+		headers := make(map[string]string, len(m.Header))
+		for key := range m.Header {
+			headers[key] = m.Header.Get(key)
+		}
+		return headers[nats.HeaderKey], m.Data, headers, nil
+	case *amqp.Delivery:
+		headers := make(map[string]string, len(m.Headers))
+		for key, value := range m.Headers {
+			if value, ok := value.(string); ok {
+				headers[key] = value
+			}
+		}
+		return headers[rabbitmq.HeaderKey], m.Body, headers, nil
+	case pulsarclient.Message:
+		return m.Key(), m.Payload(), m.Properties(), nil
+	case *Message:
+		return m.Key, m.Value, m.Headers, nil
+	}
+	return "", nil, nil, errors.New("unsupported message type")
+}
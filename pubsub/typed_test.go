@@ -0,0 +1,185 @@
+package pubsub_test
+
+import (
+	"context"
+	"errors"
+	"lib/pubsub"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+type (
+	orderV1 struct {
+		ID    string `json:"id"`
+		Total int    `json:"total"`
+	}
+
+	orderV2 struct {
+		ID       string `json:"id"`
+		Total    int    `json:"total"`
+		Currency string `json:"currency"`
+	}
+
+	orderBroken struct {
+		ID    string  `json:"id"`
+		Total float64 `json:"total"`
+	}
+
+	// recordingPublisher keeps the published messages
+	recordingPublisher struct {
+		messages []*pubsub.Message
+	}
+)
+
+func (p *recordingPublisher) Send(key string, data []byte) error {
+	return p.SendMessage(context.Background(), &pubsub.Message{Key: key, Value: data})
+}
+
+func (p *recordingPublisher) SendMessage(ctx context.Context, message *pubsub.Message) error {
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func (p *recordingPublisher) SendBatch(ctx context.Context, messages []*pubsub.Message) error {
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+// TestTypedMessages validates values are decoded with the schema they were
+// written with and incompatible schemas are rejected
+func TestTypedMessages(t *testing.T) {
+	ctx := context.Background()
+	registry := pubsub.NewLocalSchemaRegistry()
+
+	t.Log("Given the need to publish typed messages")
+	{
+		v1, err := pubsub.NewTopic[orderV1](ctx, "orders", pubsub.JSONCodec, registry)
+		if err != nil {
+			t.Fatalf("\tShould register the first schema. %v %v", ballotX, err)
+		}
+		v2, err := pubsub.NewTopic[*orderV2](ctx, "orders", pubsub.JSONCodec, registry)
+		if err != nil || v2.Schema().Version != 2 {
+			t.Fatalf("\tShould register a compatible schema as a new version. %v %v", ballotX, err)
+		}
+		t.Logf("\tShould register compatible schemas. %v", checkMark)
+
+		if _, err := pubsub.NewTopic[orderBroken](ctx, "orders", pubsub.JSONCodec, registry); !errors.Is(err, pubsub.ErrIncompatibleSchema) {
+			t.Errorf("\tShould reject a schema changing the type of a field. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould reject a schema changing the type of a field. %v", checkMark)
+		}
+
+		publisher := &recordingPublisher{}
+		if err := pubsub.Publish(ctx, publisher, v1, "order-1", orderV1{ID: "order-1", Total: 42}); err != nil {
+			t.Fatalf("\tShould publish the value. %v %v", ballotX, err)
+		}
+
+		var received *orderV2
+		handler := pubsub.Subscribe(v2, func(ctx context.Context, envelope pubsub.Envelope[*orderV2]) error {
+			if envelope.Schema.ID != v1.Schema().ID {
+				return errors.New("unexpected schema")
+			}
+			received = envelope.Value
+			return nil
+		})
+		if err := handler(ctx, publisher.messages[0]); err != nil || received == nil || received.Total != 42 {
+			t.Errorf("\tShould decode the value written with the previous schema. %v %+v %v", ballotX, received, err)
+		} else {
+			t.Logf("\tShould decode the value written with the previous schema. %v", checkMark)
+		}
+
+		published := publisher.messages[0]
+		msg := nats.NewMsg("orders")
+		msg.Data = published.Value
+		delivery := &amqp.Delivery{Headers: amqp.Table{}, Body: published.Value}
+		for key, value := range published.Headers {
+			msg.Header.Set(key, value)
+			delivery.Headers[key] = value
+		}
+		for _, message := range []interface{}{msg, delivery} {
+			received = nil
+			if err := handler(ctx, message); err != nil || received == nil || received.Total != 42 {
+				t.Errorf("\tShould decode the value received from %T. %v %v", message, ballotX, err)
+			} else {
+				t.Logf("\tShould decode the value received from %T. %v", message, checkMark)
+			}
+		}
+
+		t.Log("\tWhen the subscriber registered its schemas in another order")
+		{
+			other := pubsub.NewLocalSchemaRegistry()
+			remote, err := pubsub.NewTopic[*orderV2](ctx, "orders", pubsub.JSONCodec, other)
+			if err != nil || remote.Schema().ID != v1.Schema().ID {
+				t.Fatalf("\t\tShould register the schema with the ID of another one. %v %v", ballotX, err)
+			}
+
+			handler := pubsub.Subscribe(remote, func(ctx context.Context, envelope pubsub.Envelope[*orderV2]) error {
+				return nil
+			})
+			if err := handler(ctx, publisher.messages[0]); !errors.Is(err, pubsub.ErrSchemaMismatch) {
+				t.Errorf("\t\tShould reject a message whose ID belongs to another schema. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould reject a message whose ID belongs to another schema. %v", checkMark)
+			}
+
+			if err := pubsub.Publish(ctx, publisher, v2, "order-2", &orderV2{ID: "order-2", Total: 7}); err != nil {
+				t.Fatalf("\t\tShould publish the value. %v %v", ballotX, err)
+			}
+			if err := handler(ctx, publisher.messages[1]); err != nil {
+				t.Errorf("\t\tShould decode a message written with the same schema. %v %v", ballotX, err)
+			} else {
+				t.Logf("\t\tShould decode a message written with the same schema. %v", checkMark)
+			}
+		}
+	}
+}
+
+// TestAvroCodec validates Avro values round trip and evolutions need defaults
+func TestAvroCodec(t *testing.T) {
+	const (
+		schemaV1 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"int"}]}`
+		schemaV2 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"long"},{"name":"currency","type":"string","default":"EUR"}]}`
+		schemaV3 = `{"type":"record","name":"Order","fields":[{"name":"id","type":"string"},{"name":"total","type":"long"},{"name":"note","type":"string"}]}`
+	)
+
+	t.Log("Given the need to encode messages with Avro")
+	{
+		codec, err := pubsub.NewAvroCodec(schemaV1)
+		if err != nil {
+			t.Fatalf("\tShould parse the schema. %v %v", ballotX, err)
+		}
+
+		data, err := codec.Marshal(schemaV1, orderV1{ID: "order-1", Total: 42})
+		if err != nil {
+			t.Fatalf("\tShould encode the value. %v %v", ballotX, err)
+		}
+		var order orderV1
+		if err := codec.Unmarshal(schemaV1, data, &order); err != nil || order.Total != 42 {
+			t.Errorf("\tShould decode the value. %v %+v %v", ballotX, order, err)
+		} else {
+			t.Logf("\tShould decode the value. %v", checkMark)
+		}
+
+		if err := codec.Compatible(schemaV2, schemaV1); err != nil {
+			t.Errorf("\tShould accept promoted types and new fields with default. %v %v", ballotX, err)
+		} else {
+			t.Logf("\tShould accept promoted types and new fields with default. %v", checkMark)
+		}
+		if err := codec.Compatible(schemaV3, schemaV2); err == nil {
+			t.Errorf("\tShould reject new fields without default. %v", ballotX)
+		} else {
+			t.Logf("\tShould reject new fields without default. %v", checkMark)
+		}
+	}
+}
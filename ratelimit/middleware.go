@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"context"
+	"lib/jwt/interceptor"
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterMDKey is the metadata key holding the seconds to wait before retrying
+const RetryAfterMDKey = "retry-after"
+
+type (
+	// KeyFunc returns the identity a gRPC request is limited by, requests with
+	// an empty key are not limited
+	KeyFunc func(ctx context.Context, info *grpc.UnaryServerInfo) string
+
+	// HTTPKeyFunc returns the identity an HTTP request is limited by, requests
+	// with an empty key are not limited
+	HTTPKeyFunc func(r *http.Request) string
+)
+
+// ClaimKeyFunc limits by the user then the domain of the claim verified by
+// the JWT interceptor, it falls back to the peer address for anonymous
+// requests. The JWT interceptor must run before the rate limiter
+func ClaimKeyFunc(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	// the user_id and domain metadata are not used since callers can set
+	// them on the methods excluded from the JWT interceptor
+	if claim, ok := interceptor.ClaimFromContext(ctx); ok {
+		if claim.UserID != "" {
+			return "user:" + claim.UserID
+		}
+		if claim.Domain != "" {
+			return "domain:" + claim.Domain
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		// the port changes with every connection of the caller
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "peer:" + host
+	}
+	return ""
+}
+
+// UnaryServerInterceptor rejects requests over the limit with ResourceExhausted
+// and the retry-after header, requests are let through when the limiter fails
+func UnaryServerInterceptor(limiter Limiter, keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	if keyFunc == nil {
+		keyFunc = ClaimKeyFunc
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := keyFunc(ctx, info)
+		if key == "" {
+			return handler(ctx, req)
+		}
+
+		result, err := limiter.Allow(ctx, key)
+		if err != nil {
+			zap.S().Warnw("Failed to check rate limit", "key", key, "error", err)
+			return handler(ctx, req)
+		}
+		if !result.Allowed {
+			retryAfter := strconv.FormatInt(retryAfterSeconds(result.RetryAfter), 10)
+			_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterMDKey, retryAfter))
+			return nil, status.Error(codes.ResourceExhausted, codes.ResourceExhausted.String())
+		}
+		return handler(ctx, req)
+	}
+}
+
+// HTTPMiddleware rejects requests over the limit with 429 and the Retry-After
+// header, requests are let through when the limiter fails
+func HTTPMiddleware(limiter Limiter, keyFunc HTTPKeyFunc) func(http.Handler) http.Handler {
+	if keyFunc == nil {
+		keyFunc = RemoteAddrKeyFunc
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				zap.S().Warnw("Failed to check rate limit", "key", key, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.FormatInt(retryAfterSeconds(result.RetryAfter), 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RemoteAddrKeyFunc limits HTTP requests by the address of the caller
+func RemoteAddrKeyFunc(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lib/cache"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	// tokenBucketScript refills the bucket for the elapsed time then takes one
+	// token, it returns {allowed, remaining, retry after in ms}
+	tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate))
+return {allowed, math.floor(tokens), retry}`)
+
+	// slidingWindowScript drops the requests older than the window then records
+	// the current one if the limit is not reached, it returns {allowed,
+	// remaining, retry after in ms}
+	slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	redis.call("PEXPIRE", KEYS[1], window)
+	return {1, limit - count - 1, 0}
+end
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return {0, 0, math.max(tonumber(oldest[2]) + window - now, 1)}`)
+)
+
+type (
+	// Result represents the decision of a limiter
+	Result struct {
+		Allowed bool
+		// Remaining is the number of requests still allowed right now
+		Remaining int64
+		// RetryAfter is how long to wait before the next request is allowed,
+		// zero when the request is allowed
+		RetryAfter time.Duration
+	}
+
+	// Limiter represents a distributed rate limiter
+	Limiter interface {
+		// Allow consumes one request of key and reports whether it is allowed
+		Allow(ctx context.Context, key string) (Result, error)
+	}
+
+	tokenBucketLimiter struct {
+		scripting cache.CacheScripting
+		rate      float64
+		burst     int64
+	}
+
+	slidingWindowLimiter struct {
+		scripting cache.CacheScripting
+		limit     int64
+		window    time.Duration
+	}
+)
+
+// NewTokenBucketLimiter allows bursts of up to burst requests per key, refilled
+// at rate requests per second
+func NewTokenBucketLimiter(helper cache.CacheHelper, rate float64, burst int64) (Limiter, error) {
+	if rate <= 0 || burst <= 0 {
+		return nil, errors.New("rate and burst of token bucket must be positive")
+	}
+	scripting, ok := helper.(cache.CacheScripting)
+	if !ok {
+		return nil, errors.New("cache helper does not support scripting")
+	}
+	return &tokenBucketLimiter{
+		scripting: scripting,
+		rate:      rate,
+		burst:     burst,
+	}, nil
+}
+
+// NewSlidingWindowLimiter allows up to limit requests per key within any
+// window, every allowed request is kept in a sorted set for the window
+func NewSlidingWindowLimiter(helper cache.CacheHelper, limit int64, window time.Duration) (Limiter, error) {
+	if limit <= 0 || window < time.Millisecond {
+		return nil, errors.New("limit and window of sliding window must be positive")
+	}
+	scripting, ok := helper.(cache.CacheScripting)
+	if !ok {
+		return nil, errors.New("cache helper does not support scripting")
+	}
+	return &slidingWindowLimiter{
+		scripting: scripting,
+		limit:     limit,
+		window:    window,
+	}, nil
+}
+
+func (l *tokenBucketLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	result, err := l.scripting.EvalScript(ctx, tokenBucketScript, []string{limiterKey("tb", key)},
+		l.rate, l.burst, time.Now().UnixMilli())
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(result)
+}
+
+func (l *slidingWindowLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	member := make([]byte, 8)
+	if _, err := rand.Read(member); err != nil {
+		return Result{}, err
+	}
+
+	now := time.Now().UnixMilli()
+	result, err := l.scripting.EvalScript(ctx, slidingWindowScript, []string{limiterKey("sw", key)},
+		l.limit, l.window.Milliseconds(), now, fmt.Sprintf("%d-%s", now, hex.EncodeToString(member)))
+	if err != nil {
+		return Result{}, err
+	}
+	return parseResult(result)
+}
+
+func parseResult(result interface{}) (Result, error) {
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 3 {
+		return Result{}, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+
+	numbers := make([]int64, len(values))
+	for index, value := range values {
+		number, ok := value.(int64)
+		if !ok {
+			return Result{}, fmt.Errorf("unexpected rate limit script result %v", result)
+		}
+		numbers[index] = number
+	}
+	return Result{
+		Allowed:    numbers[0] == 1,
+		Remaining:  numbers[1],
+		RetryAfter: time.Duration(numbers[2]) * time.Millisecond,
+	}, nil
+}
+
+// retryAfterSeconds rounds up d for the Retry-After header
+func retryAfterSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+func limiterKey(algorithm, key string) string {
+	return fmt.Sprintf("ratelimit:%s:%s", algorithm, key)
+}
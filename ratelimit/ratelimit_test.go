@@ -0,0 +1,119 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lib/cache"
+	"lib/common"
+	"lib/jwt/interceptor"
+	"lib/jwt/model"
+	"lib/ratelimit"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	checkMark = "✓"
+	ballotX   = "✗"
+)
+
+// TestLimiters validates both algorithms reject requests over the limit
+func TestLimiters(t *testing.T) {
+	ctx := context.Background()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Should be able to start miniredis. %v %v", ballotX, err)
+	}
+	defer server.Close()
+	helper := cache.NewCacheHelper([]string{server.Addr()})
+
+	tokenBucket, err := ratelimit.NewTokenBucketLimiter(helper, 1, 3)
+	if err != nil {
+		t.Fatalf("Should be able to create a token bucket limiter. %v %v", ballotX, err)
+	}
+	slidingWindow, err := ratelimit.NewSlidingWindowLimiter(helper, 3, time.Minute)
+	if err != nil {
+		t.Fatalf("Should be able to create a sliding window limiter. %v %v", ballotX, err)
+	}
+
+	limiters := map[string]ratelimit.Limiter{
+		"token bucket":   tokenBucket,
+		"sliding window": slidingWindow,
+	}
+	for name, limiter := range limiters {
+		t.Logf("Given the need to limit requests with a %s", name)
+		{
+			for i := int64(0); i < 3; i++ {
+				result, err := limiter.Allow(ctx, "user:lisa")
+				if err != nil || !result.Allowed || result.Remaining != 2-i {
+					t.Fatalf("\tShould allow request %d. %v %+v %v", i, ballotX, result, err)
+				}
+			}
+			t.Logf("\tShould allow requests up to the limit. %v", checkMark)
+
+			result, err := limiter.Allow(ctx, "user:lisa")
+			if err != nil || result.Allowed || result.RetryAfter <= 0 {
+				t.Errorf("\tShould reject the request over the limit with a retry delay. %v %+v %v", ballotX, result, err)
+			} else {
+				t.Logf("\tShould reject the request over the limit with a retry delay. %v", checkMark)
+			}
+
+			result, err = limiter.Allow(ctx, "user:bill")
+			if err != nil || !result.Allowed {
+				t.Errorf("\tShould limit every key on its own. %v %+v %v", ballotX, result, err)
+			} else {
+				t.Logf("\tShould limit every key on its own. %v", checkMark)
+			}
+		}
+	}
+}
+
+// claimAdapter accepts the token "valid" for the user u1
+type claimAdapter struct{}
+
+func (claimAdapter) GenerateToken(ctx context.Context, userID string, domain string) (string, string, error) {
+	return "valid", "", nil
+}
+
+func (claimAdapter) VerifyToken(ctx context.Context, token, uid, key string) (model.JWTToken, error) {
+	if token != "valid" {
+		return model.JWTToken{}, errors.New("invalid token")
+	}
+	return model.JWTToken{UserID: "u1", Domain: "d1"}, nil
+}
+
+// TestClaimKeyFunc validates requests are only limited by verified claims
+func TestClaimKeyFunc(t *testing.T) {
+	auth := interceptor.JWTAuthenticationInterceptor(claimAdapter{}, []string{"/public"})
+	keyOf := func(method string, md metadata.MD) string {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+		ctx = metadata.NewIncomingContext(ctx, md)
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		key, _ := auth(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return ratelimit.ClaimKeyFunc(ctx, info), nil
+		})
+		return fmt.Sprint(key)
+	}
+
+	t.Log("Given the need to limit requests by caller identity")
+	{
+		if key := keyOf("/private", metadata.Pairs("authorization", "Bearer valid", common.UserIDMDKey, "spoofed")); key != "user:u1" {
+			t.Errorf("\tShould limit by the user of the verified claim. %v %v", ballotX, key)
+		} else {
+			t.Logf("\tShould limit by the user of the verified claim. %v", checkMark)
+		}
+
+		if key := keyOf("/public", metadata.Pairs(common.UserIDMDKey, "spoofed")); key != "peer:10.0.0.1" {
+			t.Errorf("\tShould ignore the user set by the caller. %v %v", ballotX, key)
+		} else {
+			t.Logf("\tShould ignore the user set by the caller. %v", checkMark)
+		}
+	}
+}
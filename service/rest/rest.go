@@ -17,6 +17,8 @@ type RestfulService struct {
 	server  *http.Server
 	httpMux *http.ServeMux
 	mux     *runtime.ServeMux
+
+	middlewares []func(http.Handler) http.Handler
 }
 
 func NewRestfulService(options ...func(*RestfulService)) *RestfulService {
@@ -44,6 +46,14 @@ func WithAddress(address int) func(*RestfulService) {
 	}
 }
 
+// WithMiddleware wraps the gateway handler, the first middleware is the
+// outermost one
+func WithMiddleware(middlewares ...func(http.Handler) http.Handler) func(*RestfulService) {
+	return func(r *RestfulService) {
+		r.middlewares = append(r.middlewares, middlewares...)
+	}
+}
+
 func (r *RestfulService) GetService() *http.Server {
 	return r.server
 }
@@ -54,6 +64,9 @@ func (r *RestfulService) GetMux() *runtime.ServeMux {
 
 func (r *RestfulService) Run() {
 	var httpHandler http.Handler = r.httpMux
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		httpHandler = r.middlewares[i](httpHandler)
+	}
 	if r.cors {
 		corsHandler := cors.New(cors.Options{
 			AllowedOrigins:   []string{"*"},
@@ -67,7 +80,7 @@ func (r *RestfulService) Run() {
 				"Grpc-Metadata-Custom-Header-Additional-Info"},
 			Debug: true,
 		})
-		httpHandler = corsHandler.Handler(r.httpMux)
+		httpHandler = corsHandler.Handler(httpHandler)
 	}
 
 	server := &http.Server{